@@ -3,6 +3,7 @@ package kuu
 import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
 	"regexp"
 	"strings"
 )
@@ -21,6 +22,8 @@ type LoginHandlerResponse struct {
 	LocaleMessageID            string
 	LocaleMessageDefaultText   string
 	LocaleMessageContextValues interface{}
+	SkipTwoFactor              bool
+	SignType                   string
 }
 
 var (
@@ -31,6 +34,16 @@ var (
 		"GET /favicon.ico",
 		"GET /whitelist",
 		"POST /login",
+		"POST /token/refresh",
+		"POST /login/2fa",
+		"POST /login/2fa/enroll",
+		"POST /password/change",
+		"POST /password/reset/request",
+		"POST /password/reset/confirm",
+		"POST /oauth2/token",
+		"POST /oauth2/introspect",
+		"POST /oauth2/revoke",
+		"GET /.well-known/jwks.json",
 		"GET /enum",
 		"GET /meta",
 		"GET /model/docs",
@@ -41,6 +54,7 @@ var (
 		"GET /intl/languages",
 		"GET /intl/messages",
 		regexp.MustCompile("GET /assets"),
+		regexp.MustCompile("GET /oidc/"),
 	}
 	ExpiresSeconds = 86400
 	loginHandler   = defaultLoginHandler
@@ -89,14 +103,14 @@ func AddWhitelist(rules ...interface{}) {
 	Whitelist = append(Whitelist, rules...)
 }
 
-func saveHistory(secretData *SignSecret) {
+func saveHistory(db *gorm.DB, secretData *SignSecret) {
 	history := SignHistory{
 		SecretID:   secretData.ID,
 		SecretData: secretData.Secret,
 		Token:      secretData.Token,
 		Method:     secretData.Method,
 	}
-	DB().Create(&history)
+	db.Create(&history)
 }
 
 func (c *Context) Token() string {
@@ -164,15 +178,29 @@ func (c *Context) DecodedContext() (sign *SignContext, err error) {
 	}
 	sign.UID = secret.UID
 	sign.Username = secret.Username
+	sign.ActorUID = secret.UID
+	if secret.ImpersonatorID != 0 {
+		sign.ActorUID = secret.ImpersonatorID
+	}
 	// 验证令牌
 	if secret.Secret == "" {
 		err = ErrSecretNotFound
 		return
 	}
-	if secret.Method == SignMethodLogout {
+	if method := SignRevokedMethod(&secret); method != "" {
 		err = ErrInvalidToken
+		if method == SignMethodEvicted {
+			err = ErrSessionEvicted
+		}
+		return
+	}
+	if !secret.IPAllowed(c.ClientIP()) {
+		err = ErrIPNotAllowed
 		return
 	}
+	if secret.IsAPIKey.Bool {
+		touchAPIKey(&secret)
+	}
 	sign.Secret = &secret
 	if secret.Type == "" {
 		secret.Type = AdminSignType
@@ -208,10 +236,13 @@ func EncodedToken(claims jwt.MapClaims, secret string) (signed string, err error
 // DecodedToken
 func DecodedToken(tokenString string, secret string) jwt.MapClaims {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		switch token.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			return []byte(secret), nil
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+			return signKeyFunc(token)
 		}
-		return []byte(secret), nil
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 	})
 
 	if token != nil {
@@ -234,16 +265,60 @@ func Acc(handler ...LoginHandlerFunc) *Mod {
 		Models: []interface{}{
 			&SignSecret{},
 			&SignHistory{},
+			&SignRefreshToken{},
+			&UserTOTP{},
+			&UserRecoveryCode{},
+			&UserIdentity{},
+			&OAuthClient{},
+			&OAuthConsent{},
+			&SignKey{},
 		},
 		Middleware: HandlersChain{
+			AuditMiddleware,
+			RateLimitMiddleware,
 			AuthMiddleware,
 		},
+		IntlMessages: map[string]string{
+			"rate_limited": "Too many requests, please try again in {{seconds}} seconds",
+		},
 		Routes: RoutesInfo{
 			LoginRoute,
 			LogoutRoute,
+			RefreshTokenRoute,
 			ValidRoute,
+			SessionsRoute,
+			SessionsRevokeRoute,
+			UserSessionsRoute,
+			UserSessionsRevokeRoute,
+			TwoFactorLoginRoute,
+			TwoFactorLoginEnrollRoute,
+			TwoFactorRoute,
+			TwoFactorEnrollRoute,
+			TwoFactorActivateRoute,
+			TwoFactorDisableRoute,
+			TwoFactorRecoveryCodesRoute,
+			OIDCProvidersRoute,
+			OIDCLoginRoute,
+			OIDCCallbackRoute,
+			OAuth2AuthorizeRoute,
+			OAuth2ConsentRoute,
+			OAuth2TokenRoute,
+			OAuth2IntrospectRoute,
+			OAuth2RevokeRoute,
+			OAuth2ClientsRoute,
+			OAuth2ClientCreateRoute,
+			OAuth2ClientSecretRoute,
+			OAuth2ClientDeleteRoute,
+			OAuth2ConsentsRoute,
+			OAuth2ConsentRevokeRoute,
+			LoginUnlockRoute,
 			APIKeyRoute,
+			APIKeysRoute,
+			APIKeyRotateRoute,
+			APIKeyRevokeRoute,
 			WhitelistRoute,
+			JWKSRoute,
 		},
+		OnImport: initSignKeys,
 	}
 }
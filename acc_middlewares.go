@@ -7,6 +7,9 @@ func AuthMiddleware(c *Context) *STDReply {
 	} else {
 		// 从请求参数中解码令牌
 		sign, err := c.DecodedContext()
+		if err == ErrSessionEvicted {
+			return c.AbortErrWithCode(err, 557, "acc_session_evicted", "You have been signed out because your account signed in elsewhere")
+		}
 		if err != nil {
 			return c.AbortErrWithCode(err, 555, "acc_please_login", "Please login")
 		}
@@ -14,7 +17,11 @@ func AuthMiddleware(c *Context) *STDReply {
 			if !c.validSignType(sign) {
 				return c.AbortErrWithCode(err, 556, "acc_incorrect_token", "Incorrect token type")
 			}
+			if !sign.Secret.RouteAllowed(c.Request.Method, c.Request.URL.Path) {
+				return c.AbortErrWithCode(ErrScopeNotAllowed, 559, "acc_token_scope_denied", "The token is not allowed to access this resource")
+			}
 			c.Next()
+			logImpersonatedRequest(c, sign)
 		} else {
 			return c.AbortErrWithCode(err, 555, "acc_incorrect_token", "Incorrect token type")
 		}
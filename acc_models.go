@@ -1,6 +1,7 @@
 package kuu
 
 import (
+	"crypto"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/jinzhu/gorm"
 	"gopkg.in/guregu/null.v3"
@@ -18,18 +19,112 @@ type SignHistory struct {
 
 // SignSecret
 type SignSecret struct {
-	gorm.Model `rest:"*" displayName:"令牌密钥"`
-	UID        uint      `name:"用户ID"`
-	Username   string    `name:"用户账号"`
-	SubDocID   uint      `name:"扩展档案ID"`
-	Desc       string    `name:"令牌描述"`
-	Secret     string    `name:"令牌密钥"`
-	Token      string    `name:"令牌" gorm:"size:4096"`
-	Iat        int64     `name:"令牌签发时间戳"`
-	Exp        int64     `name:"令牌过期时间戳"`
-	Method     string    `name:"登录/登出"`
-	IsAPIKey   null.Bool `name:"是否API Key"`
-	Type       string    `name:"令牌类型"`
+	gorm.Model   `rest:"*" displayName:"令牌密钥"`
+	UID          uint      `name:"用户ID"`
+	Username     string    `name:"用户账号"`
+	SubDocID     uint      `name:"扩展档案ID"`
+	Desc         string    `name:"令牌描述"`
+	Secret       string    `name:"令牌密钥"`
+	Token        string    `name:"令牌" gorm:"size:4096"`
+	Iat          int64     `name:"令牌签发时间戳"`
+	Exp          int64     `name:"令牌过期时间戳"`
+	Method       string    `name:"登录/登出"`
+	IsAPIKey     null.Bool `name:"是否API Key"`
+	Type         string    `name:"令牌类型"`
+	IP           string    `name:"签发IP"`
+	UserAgent    string    `name:"客户端标识" gorm:"size:1024"`
+	ClientID     string    `name:"OAuth客户端ID" gorm:"index"`
+	Scopes       string    `name:"授权范围" gorm:"size:2048"`
+	AllowedCIDRs string    `name:"IP白名单" gorm:"size:1024"`
+	LastUsedAt   int64     `name:"最后使用时间戳"`
+	UsedCount    int64     `name:"使用次数"`
+	Kid          string    `name:"签名密钥ID" gorm:"index"`
+	// 模拟登录令牌的实际操作人
+	ImpersonatorID      uint   `name:"模拟登录操作人ID" gorm:"index"`
+	ImpersonationReason string `name:"模拟登录原因" gorm:"size:1024"`
+
+	RefreshToken string `gorm:"-" json:",omitempty"`
+}
+
+// SignRefreshToken
+type SignRefreshToken struct {
+	gorm.Model `displayName:"刷新令牌"`
+	UID        uint   `name:"用户ID"`
+	Username   string `name:"用户账号"`
+	Type       string `name:"令牌类型"`
+	Desc       string `name:"令牌描述"`
+	SecretID   uint   `name:"关联令牌密钥ID" gorm:"index"`
+	Family     string `name:"令牌族" gorm:"index"`
+	TokenHash  string `name:"刷新令牌摘要" gorm:"unique_index"`
+	Payload    string `name:"令牌载荷" gorm:"type:text"`
+	ClientID   string `name:"OAuth客户端ID"`
+	Scopes     string `name:"授权范围" gorm:"size:2048"`
+	Exp        int64  `name:"过期时间戳"`
+	UsedAt     int64  `name:"使用时间戳"`
+	RevokedAt  int64  `name:"吊销时间戳"`
+}
+
+// UserTOTP
+type UserTOTP struct {
+	gorm.Model  `displayName:"双因素认证"`
+	UID         uint      `name:"用户ID" gorm:"unique_index"`
+	Secret      string    `name:"动态口令密钥" kuu:"password"`
+	Enabled     null.Bool `name:"是否启用"`
+	EnabledAt   int64     `name:"启用时间戳"`
+	LastCounter int64     `name:"最后使用的时间步"`
+}
+
+// UserRecoveryCode
+type UserRecoveryCode struct {
+	gorm.Model `displayName:"双因素认证恢复码"`
+	UID        uint   `name:"用户ID" gorm:"index"`
+	CodeHash   string `name:"恢复码摘要" kuu:"password"`
+	UsedAt     int64  `name:"使用时间戳"`
+}
+
+// UserIdentity
+type UserIdentity struct {
+	gorm.Model `displayName:"第三方身份"`
+	UID        uint   `name:"用户ID" gorm:"index"`
+	Provider   string `name:"身份提供方" gorm:"unique_index:idx_identity_subject"`
+	Subject    string `name:"身份标识" gorm:"unique_index:idx_identity_subject"`
+	Email      string `name:"邮箱地址"`
+	Claims     string `name:"身份声明" gorm:"type:text"`
+}
+
+// OAuthClient
+type OAuthClient struct {
+	gorm.Model   `displayName:"OAuth客户端"`
+	ClientID     string    `name:"客户端ID" gorm:"unique_index"`
+	SecretHash   string    `name:"客户端密钥摘要" json:"-" kuu:"password"`
+	Name         string    `name:"客户端名称"`
+	RedirectURIs string    `name:"回调地址（空格分隔）" gorm:"type:text"`
+	Scopes       string    `name:"可申请的授权范围（空格分隔）" gorm:"size:2048"`
+	GrantTypes   string    `name:"授权类型（空格分隔）"`
+	Confidential null.Bool `name:"是否机密客户端"`
+	UID          uint      `name:"客户端模式使用的用户ID"`
+	Disable      null.Bool `name:"是否禁用"`
+}
+
+// OAuthConsent
+type OAuthConsent struct {
+	gorm.Model `displayName:"OAuth授权记录"`
+	UID        uint   `name:"用户ID" gorm:"unique_index:idx_oauth_consent"`
+	ClientID   string `name:"客户端ID" gorm:"unique_index:idx_oauth_consent"`
+	Scopes     string `name:"已授权范围" gorm:"size:2048"`
+}
+
+// SignKey
+type SignKey struct {
+	gorm.Model `displayName:"令牌签名密钥"`
+	Kid        string `name:"密钥ID" gorm:"unique_index"`
+	Alg        string `name:"签名算法"`
+	PrivateKey string `name:"私钥" gorm:"type:text" json:"-" kuu:"password"`
+	PublicKey  string `name:"公钥" gorm:"type:text"`
+	RetiredAt  int64  `name:"停止签发时间戳"`
+
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
 }
 
 // SignContext
@@ -42,6 +137,8 @@ type SignContext struct {
 	SubDocID uint
 	Payload  jwt.MapClaims
 	Secret   *SignSecret
+	// ActorUID 模拟登录时为实际操作人ID，否则与UID相同
+	ActorUID uint
 }
 
 // IsValid
@@ -1,8 +1,10 @@
 package kuu
 
 import (
+	"errors"
 	"github.com/jinzhu/gorm"
 	"regexp"
+	"strconv"
 	"time"
 )
 
@@ -17,46 +19,106 @@ var LoginRoute = RouteInfo{
 	Method: "POST",
 	Path:   "/login",
 	IntlMessages: map[string]string{
-		"acc_login_failed": "Login failed",
+		"acc_login_failed":           "Login failed",
+		"acc_login_throttled":        "Too many failed login attempts, please try again in {{seconds}} seconds",
+		"acc_session_limit_exceeded": "Maximum number of concurrent sessions reached",
+		"acc_session_evicted":        "You have been signed out because your account signed in elsewhere",
 	},
 	HandlerFunc: func(c *Context) *STDReply {
 		// 调用登录处理器获取登录数据
 		if loginHandler == nil {
 			PANIC("login handler not configured")
 		}
+		if reply := loginThrottled(c, ""); reply != nil {
+			return reply
+		}
 		resp := loginHandler(c)
+		// 账号锁定期间即使密码正确也拒绝登录
+		if reply := loginThrottled(c, resp.Username); reply != nil {
+			return reply
+		}
 		if resp.Error != nil {
+			// 密码过期时返回特定状态码，客户端引导用户修改密码
+			if resp.Error != ErrPasswordExpired {
+				RecordLoginFailure(c, resp.Username)
+			}
+			if resp.Error == ErrPasswordExpired {
+				return c.STDErrWithCode(resp.Error, 558, "acc_password_expired", "Password expired, please change your password.")
+			}
 			if resp.LocaleMessageID != "" {
 				return c.STDErr(resp.Error, resp.LocaleMessageID, resp.LocaleMessageDefaultText, resp.LocaleMessageContextValues)
 			}
 			return c.STDErr(resp.Error, "acc_login_failed")
 		}
-		// 调用令牌签发
-		secretData, err := GenToken(GenTokenDesc{
+		// 双因素认证
+		if !resp.SkipTwoFactor && resp.UID != 0 {
+			challenge, enrolled, err := beginTwoFactor(resp)
+			if err != nil {
+				return c.STDErr(err, "acc_login_failed")
+			}
+			if challenge != "" {
+				c.SetCookie(CaptchaIDKey, "", -1, "/", "", false, true)
+				ClearLoginFailures(resp.Username)
+				return c.STD(D{
+					"TwoFactorRequired":  true,
+					"TwoFactorEnrolled":  enrolled,
+					"TwoFactorChallenge": challenge,
+				})
+			}
+		}
+		if _, err := signIn(c, resp); err != nil {
+			return c.STDErr(err, "acc_login_failed")
+		}
+		return c.STD(resp.Payload)
+	},
+}
+
+func loginThrottled(c *Context, username string) *STDReply {
+	retryAfter, err := checkLoginThrottle(c, username)
+	if err == nil {
+		return nil
+	}
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	return c.STDErr(err, "acc_login_throttled", "Too many failed login attempts, please try again in {{seconds}} seconds", D{"seconds": retryAfter})
+}
+
+func signIn(c *Context, resp *LoginHandlerResponse) (*SignSecret, error) {
+	// 调用令牌签发
+	signType := resp.SignType
+	if signType == "" {
+		signType = AdminSignType
+	}
+	var secretData *SignSecret
+	err := WithSessionLimit(resp.UID, signType, func() (err error) {
+		secretData, err = GenToken(GenTokenDesc{
 			UID:      resp.UID,
 			Username: resp.Username,
 			Payload:  resp.Payload,
 			Exp:      time.Now().Add(time.Second * time.Duration(ExpiresSeconds)).Unix(),
-			Type:     AdminSignType,
+			Type:     signType,
 		})
-		if err != nil {
-			return c.STDErr(err, "acc_login_failed")
-		}
-		// 设置到上下文中
-		c.Set("__kuu_sign_context__", &SignContext{
-			Token:   secretData.Token,
-			UID:     secretData.UID,
-			Payload: resp.Payload,
-			Secret:  secretData,
-		})
-		// 设置Cookie
-		c.SetCookie(LangKey, resp.Lang, ExpiresSeconds, "/", "", false, true)
-		c.SetCookie(TokenKey, secretData.Token, ExpiresSeconds, "/", "", false, true)
-		// 清空验证码Cookie和缓存
-		c.SetCookie(CaptchaIDKey, "", -1, "/", "", false, true)
-		DelCache(getFailedTimesKey(resp.Username))
-		return c.STD(resp.Payload)
-	},
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 设置到上下文中
+	c.Set("__kuu_sign_context__", &SignContext{
+		Token:   secretData.Token,
+		UID:     secretData.UID,
+		Payload: resp.Payload,
+		Secret:  secretData,
+	})
+	// 设置Cookie
+	c.SetCookie(LangKey, resp.Lang, ExpiresSeconds, "/", "", false, true)
+	c.SetCookie(TokenKey, secretData.Token, ExpiresSeconds, "/", "", false, true)
+	if secretData.RefreshToken != "" {
+		c.SetCookie(RefreshTokenKey, secretData.RefreshToken, refreshExpiresSeconds(), "/", "", false, true)
+	}
+	// 清空验证码Cookie和缓存
+	c.SetCookie(CaptchaIDKey, "", -1, "/", "", false, true)
+	ClearLoginFailures(resp.Username)
+	return secretData, nil
 }
 
 // LogoutRoute
@@ -82,13 +144,13 @@ func Logout(c *Context, tx *gorm.DB) error {
 			return err
 		}
 		if secret.ID != 0 {
-			if err := tx.Model(&secret).Updates(&SignSecret{Method: SignMethodLogout}).Error; err != nil {
+			// 吊销令牌及关联的刷新令牌
+			if err := RevokeSignSecrets(tx, []SignSecret{secret}); err != nil {
 				return err
 			}
-			// 保存登出历史
-			saveHistory(&secret)
 			// 设置Cookie过期
 			c.SetCookie(TokenKey, secret.Token, -1, "/", "", false, true)
+			c.SetCookie(RefreshTokenKey, "", -1, "/", "", false, true)
 			c.SetCookie(LangKey, "", -1, "/", "", false, true)
 		}
 	}
@@ -140,6 +202,10 @@ var APIKeyRoute = RouteInfo{
 		if err := c.ShouldBindJSON(&body); err != nil {
 			return c.STDErr(err, "apikeys_failed")
 		}
+		// 避免受限的令牌创建权限更大的API Key
+		if !isUserSign(c) {
+			return c.STDErr(errors.New("API Keys can only be created with a user token"), "apikeys_failed")
+		}
 		body.Payload = c.SignInfo.Payload
 		body.UID = c.SignInfo.UID
 		body.IsAPIKey = true
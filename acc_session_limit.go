@@ -0,0 +1,136 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+const (
+	// SignMethodEvicted 因超出会话数被淘汰
+	SignMethodEvicted = "EVICTED"
+	// SessionLimitPolicyEvict 淘汰最早的会话
+	SessionLimitPolicyEvict = "evict"
+	// SessionLimitPolicyReject 拒绝新的登录
+	SessionLimitPolicyReject = "reject"
+)
+
+// SessionLimitLockTTL 校验会话数并签发新会话时持有的锁的过期时间
+var SessionLimitLockTTL = 10 * time.Second
+
+// SessionLimit 会话数限制，对应配置sessionLimit
+type SessionLimit struct {
+	// Max 每个用户每种令牌类型可同时持有的会话数，0表示不限制
+	Max int `json:"max"`
+	// Policy 超出时的处理策略：evict（默认）或reject
+	Policy string `json:"policy"`
+	// Types 按令牌类型单独设置的会话数
+	Types map[string]int `json:"types"`
+}
+
+// GetSessionLimit
+func GetSessionLimit() (limit SessionLimit) {
+	C().GetInterface("sessionLimit", &limit)
+	if limit.Policy == "" {
+		limit.Policy = SessionLimitPolicyEvict
+	}
+	return
+}
+
+// MaxSessions 返回用户指定令牌类型可同时持有的会话数，优先取角色设置（多个角色取最大值），0表示不限制，可覆盖
+var MaxSessions = func(uid uint, signType string) (int, error) {
+	user, err := GetUserWithRoles(uid)
+	if err != nil {
+		return 0, err
+	}
+	var max int
+	for _, assign := range user.RoleAssigns {
+		if assign.Role != nil && assign.Role.MaxSessions > max {
+			max = assign.Role.MaxSessions
+		}
+	}
+	if max > 0 {
+		return max, nil
+	}
+	limit := GetSessionLimit()
+	if v, ok := limit.Types[signType]; ok {
+		return v, nil
+	}
+	return limit.Max, nil
+}
+
+// 查询计入会话数的令牌（不含API Key、OAuth令牌和模拟登录令牌）
+func limitedSessions(db *gorm.DB, uid uint, signType string) ([]SignSecret, error) {
+	secrets, err := ActiveSessions(db, uid)
+	if err != nil {
+		return nil, err
+	}
+	var list []SignSecret
+	for _, item := range secrets {
+		if item.ClientID == "" && item.ImpersonatorID == 0 && item.Type == signType {
+			list = append(list, item)
+		}
+	}
+	return list, nil
+}
+
+// EnforceSessionLimit 签发新会话前校验会话数，超出时按策略淘汰最早的会话或返回ErrSessionLimitExceeded，dryRun为true时只校验不淘汰
+func EnforceSessionLimit(uid uint, signType string, dryRun ...bool) error {
+	max, err := MaxSessions(uid, signType)
+	if err != nil || max <= 0 {
+		return err
+	}
+	return enforceSessionLimit(uid, signType, max, len(dryRun) > 0 && dryRun[0])
+}
+
+// WithSessionLimit 持有用户的会话数锁校验会话数后执行fn签发新会话，避免并发登录时超出限制
+func WithSessionLimit(uid uint, signType string, fn func() error) error {
+	max, err := MaxSessions(uid, signType)
+	if err != nil {
+		return err
+	}
+	if max <= 0 {
+		return fn()
+	}
+	return WithLock(fmt.Sprintf("session_limit_%d", uid), SessionLimitLockTTL, func(*CacheLock) error {
+		if err := enforceSessionLimit(uid, signType, max, false); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+func enforceSessionLimit(uid uint, signType string, max int, dryRun bool) error {
+	sessions, err := limitedSessions(DB(), uid, signType)
+	if err != nil || len(sessions) < max {
+		return err
+	}
+	if GetSessionLimit().Policy == SessionLimitPolicyReject {
+		return NewIntlError(ErrSessionLimitExceeded, "acc_session_limit_exceeded", "Maximum number of concurrent sessions reached")
+	}
+	if dryRun {
+		return nil
+	}
+	// 会话按创建时间倒序，保留最新的max-1个
+	evicted := sessions[max-1:]
+	err = WithTransaction(func(tx *gorm.DB) error {
+		return RevokeSignSecrets(tx, evicted, SignMethodEvicted)
+	})
+	if err != nil {
+		return err
+	}
+	var ids []uint
+	for _, item := range evicted {
+		ids = append(ids, item.ID)
+	}
+	if _, err := AddEventLog(EventLogDesc{
+		Class:   EventLogClassSecurity,
+		Subject: "Sessions evicted",
+		Summary: fmt.Sprintf("%d sessions of user %d evicted by a new %s login", len(evicted), uid, signType),
+		Data:    D{"UID": uid, "Type": signType, "SecretIDs": ids, "Max": max},
+		Labels:  map[string]string{"UID": fmt.Sprintf("%d", uid)},
+	}); err != nil {
+		ERROR(err)
+	}
+	return nil
+}
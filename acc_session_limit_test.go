@@ -0,0 +1,97 @@
+package kuu
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func useTestMaxSessions(max int) func() {
+	prev := MaxSessions
+	MaxSessions = func(uint, string) (int, error) {
+		return max, nil
+	}
+	return func() {
+		MaxSessions = prev
+	}
+}
+
+func TestSessionLimitEvict(t *testing.T) {
+	defer useTestDB(t, &SignSecret{}, &SignHistory{}, &SignRefreshToken{}, &EventLog{})()
+	defer useTestMaxSessions(2)()
+
+	secrets := createTestSessions(t, 2, 3)
+	for i, item := range secrets {
+		DB().Model(&item).UpdateColumn("created_at", time.Now().Add(time.Duration(i-10)*time.Minute))
+	}
+	// 只校验时不淘汰
+	if err := EnforceSessionLimit(2, AdminSignType, true); err != nil {
+		t.Fatal(err)
+	}
+	if active, _ := ActiveSessions(DB(), 2); len(active) != 3 {
+		t.Fatalf("sessions evicted by dry run: %d", len(active))
+	}
+	var created []SignSecret
+	if err := WithSessionLimit(2, AdminSignType, func() error {
+		created = createTestSessions(t, 2, 1)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	active, err := ActiveSessions(DB(), 2)
+	if err != nil || len(active) != 2 || active[0].ID != created[0].ID || active[1].ID != secrets[2].ID {
+		t.Fatalf("unexpected active sessions: %+v %v", active, err)
+	}
+	for _, item := range secrets[:2] {
+		if SignRevokedMethod(&item) != SignMethodEvicted {
+			t.Errorf("session %d not evicted", item.ID)
+		}
+	}
+}
+
+func TestSessionLimitReject(t *testing.T) {
+	defer useTestDB(t, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestConfig(`{"sessionLimit":{"policy":"reject"}}`)()
+	defer useTestMaxSessions(1)()
+
+	createTestSessions(t, 2, 1)
+	called := false
+	err := WithSessionLimit(2, AdminSignType, func() error {
+		called = true
+		return nil
+	})
+	if called || err == nil {
+		t.Fatalf("expected login to be rejected: %v", err)
+	}
+	if active, _ := ActiveSessions(DB(), 2); len(active) != 1 {
+		t.Errorf("unexpected active sessions: %d", len(active))
+	}
+}
+
+func TestSessionLimitConcurrent(t *testing.T) {
+	defer useTestDB(t, &SignSecret{}, &SignHistory{}, &SignRefreshToken{}, &EventLog{})()
+	defer useTestConfig(`{"sessionLimit":{"policy":"reject"}}`)()
+	defer useTestMaxSessions(2)()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = WithSessionLimit(2, AdminSignType, func() error {
+				return DB().Create(&SignSecret{
+					UID:    2,
+					Secret: RandomHex(16),
+					Token:  RandomHex(16),
+					Method: SignMethodLogin,
+					Exp:    time.Now().Add(time.Hour).Unix(),
+					Type:   AdminSignType,
+				}).Error
+			})
+		}()
+	}
+	wg.Wait()
+	if active, _ := ActiveSessions(DB(), 2); len(active) != 2 {
+		t.Errorf("expected 2 active sessions, got %d", len(active))
+	}
+}
@@ -0,0 +1,281 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+const (
+	// SessionsAdminPermission 管理其他用户会话的权限编码
+	SessionsAdminPermission = "acc_sessions_admin"
+	// SignRevokedChannel 令牌吊销通知频道
+	SignRevokedChannel = "kuu_sign_revoked"
+)
+
+// SessionInfo
+type SessionInfo struct {
+	ID        uint
+	Type      string
+	Desc      string
+	IP        string
+	UserAgent string
+	Iat       int64
+	Exp       int64
+	Current   bool
+}
+
+// SignRevokedMessage 令牌吊销通知
+type SignRevokedMessage struct {
+	ID     uint
+	UID    uint
+	Method string
+	Jti    string `json:",omitempty"`
+	Exp    int64  `json:",omitempty"`
+}
+
+func signRevokedKey(secret string) string {
+	return fmt.Sprintf("sign_revoked_%s", secret)
+}
+
+// IsSignRevoked 通过缓存判断令牌是否已被吊销
+func IsSignRevoked(secret string) bool {
+	if secret == "" {
+		return false
+	}
+	return GetCacheString(signRevokedKey(secret)) != ""
+}
+
+// SignRevokedMethod 返回令牌的吊销方式（如LOGOUT、EVICTED），未吊销时返回空
+func SignRevokedMethod(secret *SignSecret) string {
+	if secret.Method != SignMethodLogin {
+		return secret.Method
+	}
+	if secret.Secret == "" {
+		return ""
+	}
+	return GetCacheString(signRevokedKey(secret.Secret))
+}
+
+// RevokeSignSecrets 吊销令牌，同时吊销关联的刷新令牌并通过缓存通知所有实例
+func RevokeSignSecrets(tx *gorm.DB, secrets []SignSecret, method ...string) error {
+	m := SignMethodLogout
+	if len(method) > 0 && method[0] != "" {
+		m = method[0]
+	}
+	for _, secret := range secrets {
+		if err := RevokeRefreshTokensBySecret(tx, secret.ID); err != nil {
+			return err
+		}
+	}
+	return revokeSignSecrets(tx, secrets, m)
+}
+
+func revokeSignSecrets(tx *gorm.DB, secrets []SignSecret, method string) error {
+	now := time.Now()
+	for _, secret := range secrets {
+		if secret.ID == 0 || secret.Method != SignMethodLogin {
+			continue
+		}
+		// 带条件更新，避免重复吊销
+		db := tx.Model(&SignSecret{}).
+			Where(map[string]interface{}{"id": secret.ID, "method": SignMethodLogin}).
+			UpdateColumn("method", method)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected < 1 {
+			continue
+		}
+		secret.Method = method
+		// 保存登出历史
+		saveHistory(tx, &secret)
+		// 提交后再写入吊销标记和通知，避免事务回滚后令牌仍被视为已吊销
+		secret := secret
+		AfterCommit(tx, func() {
+			publishSignRevoked(&secret, method, now)
+		})
+	}
+	return nil
+}
+
+func publishSignRevoked(secret *SignSecret, method string, now time.Time) {
+	// 写入吊销标记，有效期与令牌一致
+	if ttl := time.Unix(secret.Exp, 0).Sub(now); ttl > 0 {
+		SetCacheString(signRevokedKey(secret.Secret), method, ttl)
+	}
+	// 通知中不含令牌，避免订阅方获取可用的凭据
+	msg := SignRevokedMessage{ID: secret.ID, UID: secret.UID, Method: method}
+	// 非对称签名的令牌以jti标识，供离线校验方维护吊销列表
+	if secret.Kid != "" {
+		msg.Jti = secret.Secret
+		msg.Exp = secret.Exp
+	}
+	if err := PublishCache(SignRevokedChannel, JSONStringify(msg)); err != nil {
+		ERROR(err)
+	}
+}
+
+// ActiveSessions 查询用户的有效会话（不含API Key）
+func ActiveSessions(db *gorm.DB, uid uint) (secrets []SignSecret, err error) {
+	err = db.Model(&SignSecret{}).
+		Where(&SignSecret{UID: uid, Method: SignMethodLogin}).
+		Where(fmt.Sprintf("%s > ?", db.Dialect().Quote("exp")), time.Now().Unix()).
+		Where(fmt.Sprintf("%s IS NULL OR %s = ?", db.Dialect().Quote("is_api_key"), db.Dialect().Quote("is_api_key")), false).
+		Order("created_at desc").
+		Find(&secrets).Error
+	return
+}
+
+func sessionInfoList(c *Context, secrets []SignSecret) []SessionInfo {
+	list := make([]SessionInfo, 0, len(secrets))
+	for _, item := range secrets {
+		list = append(list, SessionInfo{
+			ID:        item.ID,
+			Type:      item.Type,
+			Desc:      item.Desc,
+			IP:        item.IP,
+			UserAgent: item.UserAgent,
+			Iat:       item.Iat,
+			Exp:       item.Exp,
+			Current:   c.SignInfo != nil && item.Token == c.SignInfo.Token,
+		})
+	}
+	return list
+}
+
+type revokeSessionsBody struct {
+	IDs         []uint
+	All         bool
+	KeepCurrent bool
+}
+
+func revokeSessions(c *Context, uid uint, body revokeSessionsBody) (count int, err error) {
+	if !body.All && len(body.IDs) == 0 {
+		return 0, errors.New("IDs is required")
+	}
+	err = c.WithTransaction(func(tx *gorm.DB) error {
+		secrets, err := ActiveSessions(tx, uid)
+		if err != nil {
+			return err
+		}
+		idMap := make(map[uint]bool)
+		for _, id := range body.IDs {
+			idMap[id] = true
+		}
+		var targets []SignSecret
+		for _, item := range secrets {
+			if !body.All && !idMap[item.ID] {
+				continue
+			}
+			if body.KeepCurrent && c.SignInfo != nil && item.Token == c.SignInfo.Token {
+				continue
+			}
+			targets = append(targets, item)
+		}
+		count = len(targets)
+		return RevokeSignSecrets(tx, targets)
+	})
+	return
+}
+
+func canManageSessions(c *Context) bool {
+	if c.SignInfo == nil {
+		return false
+	}
+	return c.HasPermission(SessionsAdminPermission)
+}
+
+// SessionsRoute
+var SessionsRoute = RouteInfo{
+	Name:   "查询当前用户的有效会话",
+	Method: "GET",
+	Path:   "/sessions",
+	IntlMessages: map[string]string{
+		"acc_sessions_failed": "Sessions query failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		secrets, err := ActiveSessions(c.DB(), c.SignInfo.UID)
+		if err != nil {
+			return c.STDErr(err, "acc_sessions_failed")
+		}
+		return c.STD(sessionInfoList(c, secrets))
+	},
+}
+
+// SessionsRevokeRoute
+var SessionsRevokeRoute = RouteInfo{
+	Name:   "吊销当前用户的会话",
+	Method: "POST",
+	Path:   "/sessions/revoke",
+	IntlMessages: map[string]string{
+		"acc_sessions_revoke_failed": "Revoke sessions failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body revokeSessionsBody
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_sessions_revoke_failed")
+		}
+		count, err := revokeSessions(c, c.SignInfo.UID, body)
+		if err != nil {
+			return c.STDErr(err, "acc_sessions_revoke_failed")
+		}
+		return c.STD(count)
+	},
+}
+
+// UserSessionsRoute
+var UserSessionsRoute = RouteInfo{
+	Name:   "查询指定用户的有效会话",
+	Method: "GET",
+	Path:   "/user/sessions/:uid",
+	IntlMessages: map[string]string{
+		"acc_sessions_unauthorized": "Unauthorized operation",
+		"acc_sessions_failed":       "Sessions query failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !canManageSessions(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "acc_sessions_unauthorized")
+		}
+		uid := ParseID(c.Param("uid"))
+		if uid == 0 {
+			return c.STDErr(errors.New("UID is required"), "acc_sessions_failed")
+		}
+		secrets, err := ActiveSessions(c.DB(), uid)
+		if err != nil {
+			return c.STDErr(err, "acc_sessions_failed")
+		}
+		return c.STD(sessionInfoList(c, secrets))
+	},
+}
+
+// UserSessionsRevokeRoute
+var UserSessionsRevokeRoute = RouteInfo{
+	Name:   "吊销指定用户的会话",
+	Method: "POST",
+	Path:   "/user/sessions/:uid/revoke",
+	IntlMessages: map[string]string{
+		"acc_sessions_unauthorized":  "Unauthorized operation",
+		"acc_sessions_revoke_failed": "Revoke sessions failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !canManageSessions(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "acc_sessions_unauthorized")
+		}
+		uid := ParseID(c.Param("uid"))
+		if uid == 0 {
+			return c.STDErr(errors.New("UID is required"), "acc_sessions_revoke_failed")
+		}
+		var body revokeSessionsBody
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_sessions_revoke_failed")
+		}
+		count, err := revokeSessions(c, uid, body)
+		if err != nil {
+			return c.STDErr(err, "acc_sessions_revoke_failed")
+		}
+		return c.STD(count)
+	},
+}
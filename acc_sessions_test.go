@@ -0,0 +1,114 @@
+package kuu
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createTestSessions(t *testing.T, uid uint, n int) []SignSecret {
+	secrets := make([]SignSecret, n)
+	for i := range secrets {
+		secrets[i] = SignSecret{
+			UID:    uid,
+			Secret: RandomHex(16),
+			Token:  RandomHex(16),
+			Method: SignMethodLogin,
+			Iat:    time.Now().Unix(),
+			Exp:    time.Now().Add(time.Hour).Unix(),
+			Type:   AdminSignType,
+		}
+		if err := DB().Create(&secrets[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return secrets
+}
+
+func TestRevokeSignSecretsAfterCommit(t *testing.T) {
+	defer useTestDB(t, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+
+	messages := make(chan string, 1)
+	if err := SubscribeCache([]string{SignRevokedChannel}, func(_, msg string) {
+		messages <- msg
+	}); err != nil {
+		t.Fatal(err)
+	}
+	secrets := createTestSessions(t, 2, 1)
+
+	// 事务回滚时不写入吊销标记
+	rollback := errors.New("rollback")
+	err := WithTransaction(func(tx *gorm.DB) error {
+		if err := RevokeSignSecrets(tx, secrets); err != nil {
+			return err
+		}
+		if IsSignRevoked(secrets[0].Secret) {
+			t.Error("revoked before commit")
+		}
+		return rollback
+	})
+	if err != rollback || IsSignRevoked(secrets[0].Secret) {
+		t.Fatalf("revoked after rollback: %v", err)
+	}
+	var saved SignSecret
+	DB().First(&saved, secrets[0].ID)
+	if saved.Method != SignMethodLogin {
+		t.Fatalf("unexpected method after rollback: %s", saved.Method)
+	}
+
+	if err := WithTransaction(func(tx *gorm.DB) error {
+		return RevokeSignSecrets(tx, secrets)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if SignRevokedMethod(&secrets[0]) != SignMethodLogout {
+		t.Error("expected the secret to be revoked after commit")
+	}
+	select {
+	case msg := <-messages:
+		var data SignRevokedMessage
+		if err := JSONParse(msg, &data); err != nil || data.ID != secrets[0].ID || data.Method != SignMethodLogout {
+			t.Errorf("unexpected message: %s", msg)
+		}
+		if strings.Contains(msg, secrets[0].Token) || strings.Contains(msg, secrets[0].Secret) {
+			t.Errorf("message leaks the token: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("revocation not published")
+	}
+}
+
+func TestRevokeSessionsKeepCurrent(t *testing.T) {
+	defer useTestDB(t, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+
+	secrets := createTestSessions(t, 2, 3)
+	createTestSessions(t, 3, 1)
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest("POST", "/sessions/revoke", nil)
+	c := &Context{Context: ginCtx, SignInfo: &SignContext{UID: 2, Token: secrets[0].Token}}
+
+	if _, err := revokeSessions(c, 2, revokeSessionsBody{}); err == nil {
+		t.Error("expected IDs to be required")
+	}
+	count, err := revokeSessions(c, 2, revokeSessionsBody{All: true, KeepCurrent: true})
+	if err != nil || count != 2 {
+		t.Fatalf("unexpected result: %d %v", count, err)
+	}
+	active, err := ActiveSessions(DB(), 2)
+	if err != nil || len(active) != 1 || active[0].ID != secrets[0].ID {
+		t.Errorf("unexpected active sessions: %+v %v", active, err)
+	}
+	for _, item := range secrets[1:] {
+		if !IsSignRevoked(item.Secret) {
+			t.Errorf("session %d not revoked", item.ID)
+		}
+	}
+	// 其他用户的会话不受影响
+	if active, _ := ActiveSessions(DB(), 3); len(active) != 1 {
+		t.Errorf("unexpected sessions of other user: %+v", active)
+	}
+}
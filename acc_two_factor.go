@@ -0,0 +1,479 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/guregu/null.v3"
+	"strings"
+	"time"
+)
+
+var (
+	// TwoFactorChallengeExpires 登录二次验证的有效期（秒）
+	TwoFactorChallengeExpires = 300
+	// TwoFactorMaxAttempts 单次登录允许的二次验证尝试次数
+	TwoFactorMaxAttempts = 5
+	// RecoveryCodesCount 恢复码数量
+	RecoveryCodesCount = 10
+)
+
+// TwoFactorRequired 判断用户是否被要求启用双因素认证，可覆盖
+var TwoFactorRequired = func(uid uint) (bool, error) {
+	if C().GetBool("twoFactor.required") {
+		return true, nil
+	}
+	user, err := GetUserWithRoles(uid)
+	if err != nil {
+		return false, err
+	}
+	for _, assign := range user.RoleAssigns {
+		if assign.Role != nil && assign.Role.RequireTwoFactor.Bool {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TwoFactorState
+type TwoFactorState struct {
+	Enabled            bool
+	Required           bool
+	RecoveryCodesCount int
+}
+
+// GetTwoFactorState
+func GetTwoFactorState(uid uint) (state TwoFactorState, err error) {
+	record, err := getUserTOTP(DB(), uid)
+	if err != nil {
+		return
+	}
+	state.Enabled = record.Enabled.Bool
+	if state.Required, err = TwoFactorRequired(uid); err != nil {
+		return
+	}
+	if state.Enabled {
+		err = DB().Model(&UserRecoveryCode{}).Where(map[string]interface{}{"uid": uid, "used_at": 0}).Count(&state.RecoveryCodesCount).Error
+	}
+	return
+}
+
+func twoFactorIssuer() string {
+	return C().DefaultGetString("twoFactor.issuer", C().DefaultGetString("name", "Kuu"))
+}
+
+func getUserTOTP(db *gorm.DB, uid uint) (*UserTOTP, error) {
+	var record UserTOTP
+	if err := db.Where(&UserTOTP{UID: uid}).First(&record).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// 生成待激活的动态口令密钥
+func enrollTOTP(db *gorm.DB, uid uint, username string) (D, error) {
+	record, err := getUserTOTP(db, uid)
+	if err != nil {
+		return nil, err
+	}
+	if record.Enabled.Bool {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	secret := GenTOTPSecret()
+	if record.ID == 0 {
+		record = &UserTOTP{UID: uid, Secret: secret, Enabled: null.NewBool(false, true)}
+		err = db.Create(record).Error
+	} else {
+		err = db.Model(record).Updates(map[string]interface{}{"secret": secret, "last_counter": 0}).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+	return D{
+		"Secret": secret,
+		"URI":    TOTPURI(twoFactorIssuer(), username, secret),
+	}, nil
+}
+
+// 校验动态口令或恢复码
+func verifyTwoFactor(db *gorm.DB, record *UserTOTP, code, recoveryCode string) error {
+	if record.ID == 0 || record.Secret == "" {
+		return ErrTwoFactorNotEnrolled
+	}
+	if code != "" {
+		counter, ok := ValidateTOTP(record.Secret, code, time.Now(), record.LastCounter)
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		// 带条件更新，防止同一口令被重复使用
+		ret := db.Model(&UserTOTP{}).
+			Where("id = ? AND last_counter < ?", record.ID, counter).
+			UpdateColumn("last_counter", counter)
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if ret.RowsAffected < 1 {
+			return ErrInvalidTwoFactorCode
+		}
+		record.LastCounter = counter
+		return nil
+	}
+	if recoveryCode != "" && record.Enabled.Bool {
+		ret := db.Model(&UserRecoveryCode{}).
+			Where(map[string]interface{}{"uid": record.UID, "code_hash": Sha256(normalizeRecoveryCode(recoveryCode)), "used_at": 0}).
+			UpdateColumn("used_at", time.Now().Unix())
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if ret.RowsAffected < 1 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+	return ErrInvalidTwoFactorCode
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// 重新生成恢复码，旧恢复码全部失效
+func genRecoveryCodes(db *gorm.DB, uid uint) ([]string, error) {
+	if err := db.Unscoped().Where(&UserRecoveryCode{UID: uid}).Delete(&UserRecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, RecoveryCodesCount)
+	for i := 0; i < RecoveryCodesCount; i++ {
+		raw := RandomHex(5)
+		if err := db.Create(&UserRecoveryCode{UID: uid, CodeHash: Sha256(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, fmt.Sprintf("%s-%s", raw[:5], raw[5:]))
+	}
+	return codes, nil
+}
+
+// 激活动态口令并生成恢复码
+func activateTOTP(db *gorm.DB, record *UserTOTP, code string) ([]string, error) {
+	if record.ID == 0 {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if record.Enabled.Bool {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if err := verifyTwoFactor(db, record, code, ""); err != nil {
+		return nil, err
+	}
+	if err := db.Model(record).Updates(map[string]interface{}{"enabled": true, "enabled_at": time.Now().Unix()}).Error; err != nil {
+		return nil, err
+	}
+	return genRecoveryCodes(db, record.UID)
+}
+
+type twoFactorChallenge struct {
+	UID      uint
+	Username string
+	Lang     string
+	SignType string
+	Payload  jwt.MapClaims
+}
+
+func twoFactorChallengeKey(challenge string) string {
+	return fmt.Sprintf("two_factor_challenge_%s", challenge)
+}
+
+// 需要双因素认证时创建挑战，返回挑战码及是否已绑定，无需认证时挑战码为空
+func beginTwoFactor(resp *LoginHandlerResponse) (challenge string, enrolled bool, err error) {
+	state, err := GetTwoFactorState(resp.UID)
+	if err != nil || (!state.Enabled && !state.Required) {
+		return
+	}
+	// 拒绝策略下提前校验，避免完成双因素认证后才被拒绝
+	signType := resp.SignType
+	if signType == "" {
+		signType = AdminSignType
+	}
+	if err = EnforceSessionLimit(resp.UID, signType, true); err != nil {
+		return
+	}
+	return newTwoFactorChallenge(resp), state.Enabled, nil
+}
+
+func newTwoFactorChallenge(resp *LoginHandlerResponse) string {
+	challenge := RandomHex(32)
+	data := twoFactorChallenge{
+		UID:      resp.UID,
+		Username: resp.Username,
+		Lang:     resp.Lang,
+		SignType: resp.SignType,
+		Payload:  resp.Payload,
+	}
+	SetCacheString(twoFactorChallengeKey(challenge), JSONStringify(data), time.Second*time.Duration(TwoFactorChallengeExpires))
+	return challenge
+}
+
+func loadTwoFactorChallenge(challenge string) (*LoginHandlerResponse, error) {
+	if challenge == "" {
+		return nil, ErrTwoFactorChallengeExpired
+	}
+	raw := GetCacheString(twoFactorChallengeKey(challenge))
+	if raw == "" {
+		return nil, ErrTwoFactorChallengeExpired
+	}
+	var data twoFactorChallenge
+	if err := JSONParse(raw, &data); err != nil {
+		return nil, err
+	}
+	return &LoginHandlerResponse{
+		UID:      data.UID,
+		Username: data.Username,
+		Lang:     data.Lang,
+		SignType: data.SignType,
+		Payload:  data.Payload,
+	}, nil
+}
+
+// 累计尝试次数，超出限制后作废本次登录
+func countTwoFactorAttempt(challenge string) bool {
+	key := fmt.Sprintf("%s_attempts", twoFactorChallengeKey(challenge))
+	times := incrCacheWithExpiration(key, time.Second*time.Duration(TwoFactorChallengeExpires))
+	if times > TwoFactorMaxAttempts {
+		DelCache(twoFactorChallengeKey(challenge), key)
+		return false
+	}
+	return true
+}
+
+// TwoFactorLoginRoute
+var TwoFactorLoginRoute = RouteInfo{
+	Name:   "登录二次验证接口",
+	Method: "POST",
+	Path:   "/login/2fa",
+	IntlMessages: map[string]string{
+		"acc_2fa_challenge_expired": "Two-factor verification expired, please login again",
+		"acc_2fa_invalid_code":      "Invalid verification code",
+		"acc_login_failed":          "Login failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Challenge    string
+			Code         string
+			RecoveryCode string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_login_failed")
+		}
+		resp, err := loadTwoFactorChallenge(body.Challenge)
+		if err != nil {
+			return c.STDErrWithCode(err, 555, "acc_2fa_challenge_expired")
+		}
+		if !countTwoFactorAttempt(body.Challenge) {
+			return c.STDErrWithCode(ErrTwoFactorChallengeExpired, 555, "acc_2fa_challenge_expired")
+		}
+		var codes []string
+		err = c.WithTransaction(func(tx *gorm.DB) error {
+			record, err := getUserTOTP(tx, resp.UID)
+			if err != nil {
+				return err
+			}
+			// 首次登录时完成绑定
+			if !record.Enabled.Bool {
+				codes, err = activateTOTP(tx, record, body.Code)
+				return err
+			}
+			return verifyTwoFactor(tx, record, body.Code, body.RecoveryCode)
+		})
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_invalid_code")
+		}
+		DelCache(twoFactorChallengeKey(body.Challenge), fmt.Sprintf("%s_attempts", twoFactorChallengeKey(body.Challenge)))
+		if _, err := signIn(c, resp); err != nil {
+			return c.STDErr(err, "acc_login_failed")
+		}
+		if len(codes) == 0 {
+			return c.STD(resp.Payload)
+		}
+		data := make(D)
+		for k, v := range resp.Payload {
+			data[k] = v
+		}
+		data["RecoveryCodes"] = codes
+		return c.STD(data)
+	},
+}
+
+// TwoFactorLoginEnrollRoute
+var TwoFactorLoginEnrollRoute = RouteInfo{
+	Name:   "登录时绑定动态口令",
+	Method: "POST",
+	Path:   "/login/2fa/enroll",
+	IntlMessages: map[string]string{
+		"acc_2fa_challenge_expired": "Two-factor verification expired, please login again",
+		"acc_2fa_enroll_failed":     "Two-factor enrollment failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Challenge string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_2fa_enroll_failed")
+		}
+		resp, err := loadTwoFactorChallenge(body.Challenge)
+		if err != nil {
+			return c.STDErrWithCode(err, 555, "acc_2fa_challenge_expired")
+		}
+		data, err := enrollTOTP(DB(), resp.UID, resp.Username)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_enroll_failed")
+		}
+		return c.STD(data)
+	},
+}
+
+// TwoFactorRoute
+var TwoFactorRoute = RouteInfo{
+	Name:   "查询双因素认证状态",
+	Method: "GET",
+	Path:   "/2fa",
+	IntlMessages: map[string]string{
+		"acc_2fa_query_failed": "Two-factor status query failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		state, err := GetTwoFactorState(c.SignInfo.UID)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_query_failed")
+		}
+		return c.STD(state)
+	},
+}
+
+// TwoFactorEnrollRoute
+var TwoFactorEnrollRoute = RouteInfo{
+	Name:   "绑定动态口令",
+	Method: "POST",
+	Path:   "/2fa/enroll",
+	IntlMessages: map[string]string{
+		"acc_2fa_enroll_failed": "Two-factor enrollment failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		data, err := enrollTOTP(DB(), c.SignInfo.UID, c.SignInfo.Username)
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_enroll_failed")
+		}
+		return c.STD(data)
+	},
+}
+
+// TwoFactorActivateRoute
+var TwoFactorActivateRoute = RouteInfo{
+	Name:   "激活双因素认证",
+	Method: "POST",
+	Path:   "/2fa/activate",
+	IntlMessages: map[string]string{
+		"acc_2fa_activate_failed": "Two-factor activation failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Code string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_2fa_activate_failed")
+		}
+		var codes []string
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			record, err := getUserTOTP(tx, c.SignInfo.UID)
+			if err != nil {
+				return err
+			}
+			codes, err = activateTOTP(tx, record, body.Code)
+			return err
+		})
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_activate_failed")
+		}
+		return c.STD(codes)
+	},
+}
+
+// TwoFactorDisableRoute
+var TwoFactorDisableRoute = RouteInfo{
+	Name:   "停用双因素认证",
+	Method: "POST",
+	Path:   "/2fa/disable",
+	IntlMessages: map[string]string{
+		"acc_2fa_disable_failed": "Two-factor deactivation failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Code         string
+			RecoveryCode string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_2fa_disable_failed")
+		}
+		uid := c.SignInfo.UID
+		if required, err := TwoFactorRequired(uid); err != nil {
+			return c.STDErr(err, "acc_2fa_disable_failed")
+		} else if required {
+			return c.STDErr(ErrTwoFactorRequired, "acc_2fa_disable_failed")
+		}
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			record, err := getUserTOTP(tx, uid)
+			if err != nil {
+				return err
+			}
+			if !record.Enabled.Bool {
+				return ErrTwoFactorNotEnrolled
+			}
+			if err := verifyTwoFactor(tx, record, body.Code, body.RecoveryCode); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where(&UserRecoveryCode{UID: uid}).Delete(&UserRecoveryCode{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(record).Error
+		})
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_disable_failed")
+		}
+		return c.STDOK()
+	},
+}
+
+// TwoFactorRecoveryCodesRoute
+var TwoFactorRecoveryCodesRoute = RouteInfo{
+	Name:   "重新生成恢复码",
+	Method: "POST",
+	Path:   "/2fa/recovery_codes",
+	IntlMessages: map[string]string{
+		"acc_2fa_recovery_codes_failed": "Generate recovery codes failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Code string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_2fa_recovery_codes_failed")
+		}
+		var codes []string
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			record, err := getUserTOTP(tx, c.SignInfo.UID)
+			if err != nil {
+				return err
+			}
+			if !record.Enabled.Bool {
+				return ErrTwoFactorNotEnrolled
+			}
+			if err := verifyTwoFactor(tx, record, body.Code, ""); err != nil {
+				return err
+			}
+			codes, err = genRecoveryCodes(tx, record.UID)
+			return err
+		})
+		if err != nil {
+			return c.STDErr(err, "acc_2fa_recovery_codes_failed")
+		}
+		return c.STD(codes)
+	},
+}
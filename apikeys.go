@@ -0,0 +1,347 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// IsRouteScope 判断授权范围是否为路由规则（如“GET /user/*”），否则视为权限编码
+func IsRouteScope(scope string) bool {
+	return strings.Contains(scope, " ") || strings.HasPrefix(scope, "/")
+}
+
+// ParseCIDRs 解析IP白名单，支持CIDR和单个IP
+func ParseCIDRs(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP: %s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// IPAllowed 校验客户端IP是否在令牌的IP白名单内，未设置白名单时不限制
+func (s *SignSecret) IPAllowed(ip string) bool {
+	if s.AllowedCIDRs == "" {
+		return true
+	}
+	nets, err := ParseCIDRs(ParseScopes(s.AllowedCIDRs))
+	if err != nil {
+		ERROR(err)
+		return false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, item := range nets {
+		if item.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteScopes 返回令牌授权范围中的路由规则
+func (s *SignSecret) RouteScopes() (scopes []string) {
+	for _, item := range SplitScopes(s.Scopes) {
+		if IsRouteScope(item) {
+			scopes = append(scopes, item)
+		}
+	}
+	return
+}
+
+// PermissionScopes 返回令牌授权范围中的权限编码
+func (s *SignSecret) PermissionScopes() (scopes []string) {
+	for _, item := range SplitScopes(s.Scopes) {
+		if !IsRouteScope(item) {
+			scopes = append(scopes, item)
+		}
+	}
+	return
+}
+
+// Scoped 判断令牌是否限定了授权范围
+func (s *SignSecret) Scoped() bool {
+	return s != nil && strings.TrimSpace(s.Scopes) != ""
+}
+
+// PermissionAllowed 校验权限编码是否在令牌的授权范围内，未限定授权范围时不限制
+func (s *SignSecret) PermissionAllowed(code string) bool {
+	if !s.Scoped() {
+		return true
+	}
+	for _, item := range s.PermissionScopes() {
+		if item == code {
+			return true
+		}
+	}
+	return false
+}
+
+// RouteAllowed 校验请求是否在令牌的路由范围内，包含路由规则但无匹配时拒绝；仅有权限编码时由各路由按权限编码校验
+func (s *SignSecret) RouteAllowed(method, urlPath string) bool {
+	if !s.Scoped() {
+		return true
+	}
+	scopes := s.RouteScopes()
+	if len(scopes) == 0 {
+		return true
+	}
+	prefix := C().GetString("prefix")
+	for _, scope := range scopes {
+		if matchRouteScope(scope, method, urlPath) {
+			return true
+		}
+		if prefix != "" && strings.HasPrefix(urlPath, prefix) && matchRouteScope(scope, method, strings.TrimPrefix(urlPath, prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// JoinScopes 合并授权范围，包含路由规则时以换行分隔
+func JoinScopes(scopes []string) string {
+	for _, item := range scopes {
+		if strings.Contains(item, " ") {
+			return strings.Join(scopes, "\n")
+		}
+	}
+	return strings.Join(scopes, " ")
+}
+
+// SplitScopes 拆分JoinScopes合并的授权范围
+func SplitScopes(s string) (scopes []string) {
+	if !strings.ContainsAny(s, ",\n") {
+		return strings.Fields(s)
+	}
+	for _, item := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			scopes = append(scopes, item)
+		}
+	}
+	return
+}
+
+// 规则格式为“METHOD /path”，METHOD可省略或为“*”，路径以“/*”结尾时匹配所有子路径
+func matchRouteScope(scope, method, urlPath string) bool {
+	scopeMethod, scopePath := "*", scope
+	if i := strings.Index(scope, " "); i >= 0 {
+		scopeMethod, scopePath = scope[:i], strings.TrimSpace(scope[i+1:])
+	}
+	if scopeMethod != "*" && !strings.EqualFold(scopeMethod, method) {
+		return false
+	}
+	if strings.HasSuffix(scopePath, "/*") {
+		base := strings.TrimSuffix(scopePath, "/*")
+		return urlPath == base || strings.HasPrefix(urlPath, base+"/")
+	}
+	matched, err := path.Match(scopePath, urlPath)
+	return err == nil && matched
+}
+
+// 记录API Key的最后使用时间和使用次数
+func touchAPIKey(secret *SignSecret) {
+	now := time.Now().Unix()
+	err := DB().Model(&SignSecret{}).Where("id = ?", secret.ID).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"used_count":   gorm.Expr("used_count + ?", 1),
+	}).Error
+	if err != nil {
+		ERROR(err)
+		return
+	}
+	secret.LastUsedAt = now
+	secret.UsedCount++
+}
+
+// 判断当前请求是否使用用户本人的登录令牌（非API Key、OAuth或模拟登录令牌）
+func isUserSign(c *Context) bool {
+	return c.SignInfo != nil && c.SignInfo.Secret != nil && c.SignInfo.Secret.ClientID == "" &&
+		!c.SignInfo.Secret.IsAPIKey.Bool && c.SignInfo.Secret.ImpersonatorID == 0
+}
+
+// APIKeyInfo
+type APIKeyInfo struct {
+	ID           uint
+	UID          uint
+	Type         string
+	Desc         string
+	Scopes       []string
+	AllowedCIDRs []string
+	IP           string
+	Iat          int64
+	Exp          int64
+	LastUsedAt   int64
+	UsedCount    int64
+}
+
+func apiKeyInfoList(secrets []SignSecret) []APIKeyInfo {
+	list := make([]APIKeyInfo, 0, len(secrets))
+	for _, item := range secrets {
+		list = append(list, APIKeyInfo{
+			ID:           item.ID,
+			UID:          item.UID,
+			Type:         item.Type,
+			Desc:         item.Desc,
+			Scopes:       SplitScopes(item.Scopes),
+			AllowedCIDRs: strings.Fields(item.AllowedCIDRs),
+			IP:           item.IP,
+			Iat:          item.Iat,
+			Exp:          item.Exp,
+			LastUsedAt:   item.LastUsedAt,
+			UsedCount:    item.UsedCount,
+		})
+	}
+	return list
+}
+
+// ActiveAPIKeys 查询用户未过期且未吊销的API Key
+func ActiveAPIKeys(db *gorm.DB, uid uint) (secrets []SignSecret, err error) {
+	err = db.Where(&SignSecret{UID: uid, Method: SignMethodLogin}).
+		Where("is_api_key = ? AND exp > ?", true, time.Now().Unix()).
+		Order("id desc").
+		Find(&secrets).Error
+	return
+}
+
+// 查询可由当前用户管理的API Key
+func findAPIKey(c *Context, tx *gorm.DB) (*SignSecret, error) {
+	if !isUserSign(c) {
+		return nil, errors.New("API Keys can only be managed with a user token")
+	}
+	var secret SignSecret
+	if err := tx.Where("id = ? AND is_api_key = ?", ParseID(c.Param("id")), true).First(&secret).Error; err != nil {
+		return nil, err
+	}
+	if secret.UID != c.SignInfo.UID && !canManageSessions(c) {
+		return nil, fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID)
+	}
+	if secret.Method != SignMethodLogin {
+		return nil, ErrInvalidToken
+	}
+	return &secret, nil
+}
+
+// RotateAPIKey 以相同的配置签发新的API Key并吊销旧的
+func RotateAPIKey(secret *SignSecret) (*SignSecret, error) {
+	payload := DecodedToken(secret.Token, secret.Secret)
+	if payload == nil {
+		return nil, ErrInvalidToken
+	}
+	user := GetUserFromCache(secret.UID)
+	newSecret, err := GenToken(GenTokenDesc{
+		UID:          secret.UID,
+		Username:     user.Username,
+		Exp:          secret.Exp,
+		Type:         secret.Type,
+		Desc:         secret.Desc,
+		Payload:      payload,
+		IsAPIKey:     true,
+		Scopes:       SplitScopes(secret.Scopes),
+		AllowedCIDRs: strings.Fields(secret.AllowedCIDRs),
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = WithTransaction(func(tx *gorm.DB) error {
+		return RevokeSignSecrets(tx, []SignSecret{*secret})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newSecret, nil
+}
+
+// APIKeysRoute
+var APIKeysRoute = RouteInfo{
+	Name:   "查询API Key列表",
+	Method: "GET",
+	Path:   "/apikeys",
+	IntlMessages: map[string]string{
+		"apikeys_unauthorized": "Unauthorized operation",
+		"apikeys_query_failed": "API Keys query failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		uid := c.SignInfo.UID
+		if v := ParseID(c.Query("uid")); v != 0 && v != uid {
+			if !canManageSessions(c) {
+				return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "apikeys_unauthorized")
+			}
+			uid = v
+		}
+		secrets, err := ActiveAPIKeys(c.DB(), uid)
+		if err != nil {
+			return c.STDErr(err, "apikeys_query_failed")
+		}
+		return c.STD(apiKeyInfoList(secrets))
+	},
+}
+
+// APIKeyRotateRoute
+var APIKeyRotateRoute = RouteInfo{
+	Name:   "轮换API Key",
+	Method: "POST",
+	Path:   "/apikeys/:id/rotate",
+	IntlMessages: map[string]string{
+		"apikeys_rotate_failed": "Rotate API Key failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		secret, err := findAPIKey(c, c.DB())
+		if err != nil {
+			return c.STDErr(err, "apikeys_rotate_failed")
+		}
+		newSecret, err := RotateAPIKey(secret)
+		if err != nil {
+			return c.STDErr(err, "apikeys_rotate_failed")
+		}
+		return c.STD(newSecret.Token)
+	},
+}
+
+// APIKeyRevokeRoute
+var APIKeyRevokeRoute = RouteInfo{
+	Name:   "吊销API Key",
+	Method: "POST",
+	Path:   "/apikeys/:id/revoke",
+	IntlMessages: map[string]string{
+		"apikeys_revoke_failed": "Revoke API Key failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			secret, err := findAPIKey(c, tx)
+			if err != nil {
+				return err
+			}
+			return RevokeSignSecrets(tx, []SignSecret{*secret})
+		})
+		if err != nil {
+			return c.STDErr(err, "apikeys_revoke_failed")
+		}
+		return c.STDOK()
+	},
+}
@@ -0,0 +1,110 @@
+package kuu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchRouteScope(t *testing.T) {
+	tests := []struct {
+		scope  string
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET /user", "GET", "/user", true},
+		{"GET /user", "POST", "/user", false},
+		{"get /user/*", "GET", "/user/1", true},
+		{"GET /user/*", "GET", "/user", true},
+		{"GET /user/*", "GET", "/username", false},
+		{"* /org/*/members", "DELETE", "/org/1/members", true},
+		{"/meta", "GET", "/meta", true},
+	}
+	for _, tt := range tests {
+		if got := matchRouteScope(tt.scope, tt.method, tt.path); got != tt.want {
+			t.Errorf("matchRouteScope(%q, %q, %q) = %v, want %v", tt.scope, tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestJoinScopes(t *testing.T) {
+	for _, scopes := range [][]string{
+		{"user_read", "user_write"},
+		{"user_read", "GET /user/*", "/meta"},
+	} {
+		if got := SplitScopes(JoinScopes(scopes)); !reflect.DeepEqual(got, scopes) {
+			t.Errorf("SplitScopes(JoinScopes(%v)) = %v", scopes, got)
+		}
+	}
+	secret := &SignSecret{Scopes: JoinScopes([]string{"user_read", "GET /user/*"})}
+	if got := secret.PermissionScopes(); !reflect.DeepEqual(got, []string{"user_read"}) {
+		t.Errorf("PermissionScopes() = %v", got)
+	}
+	if got := secret.RouteScopes(); !reflect.DeepEqual(got, []string{"GET /user/*"}) {
+		t.Errorf("RouteScopes() = %v", got)
+	}
+}
+
+func TestSignSecretIPAllowed(t *testing.T) {
+	secret := &SignSecret{AllowedCIDRs: "10.0.0.0/8 192.168.1.10 ::1"}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":     true,
+		"192.168.1.10": true,
+		"192.168.1.11": false,
+		"::1":          true,
+		"invalid":      false,
+	} {
+		if got := secret.IPAllowed(ip); got != want {
+			t.Errorf("IPAllowed(%q) = %v, want %v", ip, got, want)
+		}
+	}
+	if _, err := ParseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("ParseCIDRs() should reject invalid CIDR")
+	}
+}
+
+func TestSignSecretScopesDenyByDefault(t *testing.T) {
+	if !(&SignSecret{}).RouteAllowed("DELETE", "/api/user") {
+		t.Error("unscoped tokens should not be restricted")
+	}
+	secret := &SignSecret{Scopes: JoinScopes([]string{"user_read", "GET /api/user"})}
+	for _, item := range []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/api/user", true},
+		{"POST", "/api/user", false},
+		{"DELETE", "/api/user", false},
+		{"GET", "/api/cache/keys", false},
+	} {
+		if got := secret.RouteAllowed(item.method, item.path); got != item.want {
+			t.Errorf("RouteAllowed(%q, %q) = %v, want %v", item.method, item.path, got, item.want)
+		}
+	}
+	// 仅有权限编码的令牌由各路由按权限编码校验
+	secret = &SignSecret{Scopes: "user_read"}
+	if !secret.RouteAllowed("GET", "/api/user") {
+		t.Error("expected routes to be limited by permission codes only")
+	}
+	if !secret.PermissionAllowed("user_read") || secret.PermissionAllowed(CacheAdminPermission) {
+		t.Error("unexpected permission scope result")
+	}
+}
+
+func TestContextHasPermissionChecksScopesBeforeRoot(t *testing.T) {
+	c := &Context{SignInfo: &SignContext{UID: RootUID(), Secret: &SignSecret{UID: RootUID()}}}
+	if !c.HasPermission(CacheAdminPermission) {
+		t.Error("expected root to be allowed")
+	}
+	c.SignInfo.Secret.Scopes = JoinScopes([]string{"user_read", "GET /cache/*"})
+	if c.HasPermission(CacheAdminPermission) {
+		t.Error("expected scoped root key to be denied")
+	}
+	c.SignInfo.Secret.Scopes = JoinScopes([]string{CacheAdminPermission, "GET /cache/*"})
+	if !c.HasPermission(CacheAdminPermission) {
+		t.Error("expected scoped root key with the permission to be allowed")
+	}
+	if (&Context{}).HasPermission(CacheAdminPermission) {
+		t.Error("expected anonymous request to be denied")
+	}
+}
@@ -1,5 +1,13 @@
 package kuu
 
+import (
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"sort"
+	"strings"
+	"time"
+)
+
 type EventLog struct {
 	Model     `rest:"*" displayName:"事件日志"`
 	EventID   string `name:"事件ID（UUID）" gorm:"NOT NULL"`
@@ -16,6 +24,8 @@ type EventLog struct {
 	EventSummary string          `name:"事件摘要" gorm:"NOT NULL"`
 	EventLabels  []EventLogLabel `name:"关联事件标签"`
 	EventData    string          `name:"事件详情(JSON-String)"`
+	PrevHash     string          `name:"前一条记录哈希"`
+	Hash         string          `name:"记录哈希" gorm:"INDEX"`
 }
 
 func (log *EventLog) BindData(dst interface{}) error {
@@ -31,3 +41,106 @@ type EventLogLabel struct {
 	LabelKey   string `name:"标签名" gorm:"NOT NULL;INDEX:event_log_label"`
 	LabelValue string `name:"标签值" gorm:"NOT NULL;INDEX:event_log_label"`
 }
+
+const (
+	// EventLogClassSecurity 安全事件
+	EventLogClassSecurity = "SECURITY"
+	// EventLogClassImpersonation 模拟登录
+	EventLogClassImpersonation = "IMPERSONATION"
+)
+
+func init() {
+	Enum("EventLogClass", "事件分类").
+		Add(EventLogClassSecurity, "安全事件").
+		Add(EventLogClassImpersonation, "模拟登录").
+		Add(EventLogClassAudit, "请求审计")
+}
+
+// EventLogDesc
+type EventLogDesc struct {
+	Class    string
+	Subject  string
+	Summary  string
+	Data     interface{}
+	Labels   map[string]string
+	UID      uint
+	Username string
+	SourceIP string
+}
+
+// AddEventLog 写入事件日志，未指定操作人和来源IP时从当前请求上下文获取
+func AddEventLog(desc EventLogDesc) (*EventLog, error) {
+	if c := GetRoutineRequestContext(); c != nil {
+		if desc.SourceIP == "" {
+			desc.SourceIP = c.ClientIP()
+		}
+		if desc.UID == 0 && c.SignInfo != nil {
+			desc.UID = c.SignInfo.UID
+			desc.Username = c.SignInfo.Username
+		}
+	}
+	var log *EventLog
+	err := withEventLogChain(func(tx *gorm.DB) (err error) {
+		log, err = createEventLog(tx, desc)
+		return
+	})
+	return log, err
+}
+
+func createEventLog(tx *gorm.DB, desc EventLogDesc) (*EventLog, error) {
+	log := EventLog{
+		EventID:      strings.ReplaceAll(uuid.NewV4().String(), "-", ""),
+		EventTime:    time.Now().Unix(),
+		SourceIP:     desc.SourceIP,
+		UserID:       desc.UID,
+		UserName:     desc.Username,
+		EventClass:   desc.Class,
+		EventSubject: desc.Subject,
+		EventSummary: desc.Summary,
+	}
+	if desc.UID != 0 {
+		user := GetUserFromCache(desc.UID)
+		if log.UserName == "" {
+			log.UserName = user.Username
+		}
+		log.UserEmailAddress = user.Email
+		log.UserPhoneNumber = user.Mobile
+	}
+	if v, ok := desc.Data.(string); ok {
+		log.EventData = v
+	} else if desc.Data != nil {
+		log.EventData = JSONStringify(desc.Data)
+	}
+	keys := make([]string, 0, len(desc.Labels))
+	for key := range desc.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	labels := make([]EventLogLabel, 0, len(keys))
+	for _, key := range keys {
+		labels = append(labels, EventLogLabel{
+			EventClass: log.EventClass,
+			LabelKey:   key,
+			LabelValue: desc.Labels[key],
+		})
+	}
+	prevHash, err := lastEventLogHash(tx, log.EventClass)
+	if err != nil {
+		return nil, err
+	}
+	log.PrevHash = prevHash
+	log.EventLabels = labels
+	log.Hash = log.ComputeHash()
+	log.EventLabels = nil
+	if err := tx.Create(&log).Error; err != nil {
+		return nil, err
+	}
+	for _, label := range labels {
+		label.EventLogID = log.ID
+		if err := tx.Create(&label).Error; err != nil {
+			return nil, err
+		}
+		log.EventLabels = append(log.EventLabels, label)
+	}
+	return &log, nil
+}
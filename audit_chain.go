@@ -0,0 +1,344 @@
+package kuu
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EventLogVerifyPermission 校验事件日志完整性的权限编码
+const EventLogVerifyPermission = "sys_eventlog_verify"
+
+// EventLogVerifyBatchSize 校验时每批读取的记录数
+var EventLogVerifyBatchSize = 500
+
+// EventLogChainLockTTL 写入事件日志时哈希链锁的有效期
+var EventLogChainLockTTL = 30 * time.Second
+
+// 同一进程内先排队，减少对分布式锁的争用
+var eventLogChainMu sync.Mutex
+
+// 多个实例写入同一条哈希链，持有分布式锁串行追加，保证链头不分叉
+func withEventLogChain(fn func(*gorm.DB) error) error {
+	eventLogChainMu.Lock()
+	defer eventLogChainMu.Unlock()
+	return WithLockedTransaction("eventlog_chain", EventLogChainLockTTL, func(tx *gorm.DB, _ *CacheLock) error {
+		return fn(tx)
+	})
+}
+
+// EventLogCheckpoint 事件日志签名检查点
+type EventLogCheckpoint struct {
+	Model      `rest:"R" displayName:"事件日志检查点"`
+	EventClass string `name:"事件分类" gorm:"NOT NULL;INDEX" enum:"EventLogClass"`
+	EventLogID uint   `name:"事件日志ID" gorm:"NOT NULL"`
+	Hash       string `name:"事件日志哈希" gorm:"NOT NULL"`
+	SignedAt   int64  `name:"签名时间" gorm:"NOT NULL"`
+	Signature  string `name:"签名（Base64）" gorm:"NOT NULL;size:1024"`
+	PublicKey  string `name:"签名公钥（PEM）" gorm:"size:1024"`
+}
+
+// SignedContent 参与签名的内容
+func (cp *EventLogCheckpoint) SignedContent() []byte {
+	return []byte(fmt.Sprintf("%s|%d|%s|%d", cp.EventClass, cp.EventLogID, cp.Hash, cp.SignedAt))
+}
+
+// EventLogChainBreak 哈希链断裂的位置
+type EventLogChainBreak struct {
+	EventLogID uint
+	Reason     string
+	Expected   string `json:",omitempty"`
+	Actual     string `json:",omitempty"`
+}
+
+// EventLogChainReport 某一事件分类的校验结果
+type EventLogChainReport struct {
+	EventClass  string
+	Checked     int
+	Checkpoints int
+	Valid       bool
+	Broken      *EventLogChainBreak `json:",omitempty"`
+}
+
+// ComputeHash 计算事件日志内容及前一条记录哈希的摘要
+func (log *EventLog) ComputeHash() string {
+	fields := []string{
+		log.PrevHash,
+		log.EventID,
+		strconv.FormatInt(log.EventTime, 10),
+		log.SourceIP,
+		strconv.FormatUint(uint64(log.UserID), 10),
+		log.UserName,
+		log.UserEmailAddress,
+		log.UserPhoneNumber,
+		log.EventClass,
+		log.EventSubject,
+		log.EventSummary,
+		log.EventData,
+	}
+	labels := make([]EventLogLabel, len(log.EventLabels))
+	copy(labels, log.EventLabels)
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].LabelKey < labels[j].LabelKey
+	})
+	for _, label := range labels {
+		fields = append(fields, label.LabelKey, label.LabelValue)
+	}
+	h := sha256.New()
+	for _, field := range fields {
+		// 带长度前缀，避免字段拼接产生歧义
+		_, _ = fmt.Fprintf(h, "%d:%s;", len(field), field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// 按事件分类分别成链，清理某一分类的过期日志时只会截断链头
+func lastEventLogHash(tx *gorm.DB, class string) (string, error) {
+	var last EventLog
+	err := tx.Unscoped().Select("id, hash").Where("event_class = ?", class).Order("id desc").First(&last).Error
+	if err != nil && !gorm.IsRecordNotFoundError(err) {
+		return "", err
+	}
+	return last.Hash, nil
+}
+
+// VerifyEventLogChain 校验事件日志哈希链及检查点，class为空时校验全部分类
+func VerifyEventLogChain(class string) ([]EventLogChainReport, error) {
+	db := DB().Unscoped()
+	var classes []string
+	if class != "" {
+		classes = []string{class}
+	} else if err := db.Model(&EventLog{}).Pluck("DISTINCT(event_class)", &classes).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(classes)
+	reports := make([]EventLogChainReport, 0, len(classes))
+	for _, item := range classes {
+		report, err := verifyEventLogClass(db, item)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, nil
+}
+
+func verifyEventLogClass(db *gorm.DB, class string) (*EventLogChainReport, error) {
+	report := &EventLogChainReport{EventClass: class, Valid: true}
+	fail := func(b EventLogChainBreak) (*EventLogChainReport, error) {
+		report.Valid = false
+		report.Broken = &b
+		return report, nil
+	}
+	var (
+		prevHash        string
+		firstID, lastID uint
+		cursor          uint
+		started         bool
+		hashes          = make(map[uint]string)
+	)
+	for {
+		var list []EventLog
+		if err := db.Where("event_class = ? AND id > ?", class, cursor).Order("id asc").Limit(EventLogVerifyBatchSize).Find(&list).Error; err != nil {
+			return nil, err
+		}
+		if len(list) == 0 {
+			break
+		}
+		if err := loadEventLogLabels(db, list); err != nil {
+			return nil, err
+		}
+		for i := range list {
+			log := &list[i]
+			cursor = log.ID
+			// 启用哈希链之前的历史记录不参与校验
+			if !started && log.Hash == "" {
+				continue
+			}
+			if log.Hash == "" {
+				return fail(EventLogChainBreak{EventLogID: log.ID, Reason: "missing hash"})
+			}
+			if log.DeletedAt != nil {
+				return fail(EventLogChainBreak{EventLogID: log.ID, Reason: "record deleted"})
+			}
+			if actual := log.ComputeHash(); actual != log.Hash {
+				return fail(EventLogChainBreak{EventLogID: log.ID, Reason: "content modified", Expected: log.Hash, Actual: actual})
+			}
+			// 首条记录的前序哈希作为锚点，此前的记录可能已按保留策略清理
+			if started && log.PrevHash != prevHash {
+				return fail(EventLogChainBreak{EventLogID: log.ID, Reason: "previous record missing", Expected: prevHash, Actual: log.PrevHash})
+			}
+			if !started {
+				firstID = log.ID
+			}
+			started = true
+			prevHash = log.Hash
+			lastID = log.ID
+			hashes[log.ID] = log.Hash
+			report.Checked++
+		}
+	}
+	var checkpoints []EventLogCheckpoint
+	if err := db.Where("event_class = ?", class).Order("event_log_id asc").Find(&checkpoints).Error; err != nil {
+		return nil, err
+	}
+	pubKey := checkpointPublicKey()
+	for _, cp := range checkpoints {
+		key := pubKey
+		if len(key) == 0 {
+			key = []byte(cp.PublicKey)
+		}
+		signature, err := base64.StdEncoding.DecodeString(cp.Signature)
+		if err == nil {
+			err = RSAVerySignWithSha256(cp.SignedContent(), signature, key)
+		}
+		if err != nil {
+			return fail(EventLogChainBreak{EventLogID: cp.EventLogID, Reason: fmt.Sprintf("invalid checkpoint signature: %v", err)})
+		}
+		// 以最早的检查点为锚点，清理日志时会同时删除更早的检查点，其记录缺失说明日志被截断
+		if !started || cp.EventLogID < firstID {
+			return fail(EventLogChainBreak{EventLogID: cp.EventLogID, Reason: "records before first verified record missing", Expected: cp.Hash})
+		}
+		if cp.EventLogID > lastID {
+			return fail(EventLogChainBreak{EventLogID: cp.EventLogID, Reason: "records after last verified record missing", Expected: cp.Hash})
+		}
+		if hash, has := hashes[cp.EventLogID]; !has {
+			return fail(EventLogChainBreak{EventLogID: cp.EventLogID, Reason: "checkpoint record missing", Expected: cp.Hash})
+		} else if hash != cp.Hash {
+			return fail(EventLogChainBreak{EventLogID: cp.EventLogID, Reason: "checkpoint hash mismatch", Expected: cp.Hash, Actual: hash})
+		}
+		report.Checkpoints++
+	}
+	return report, nil
+}
+
+func loadEventLogLabels(db *gorm.DB, list []EventLog) error {
+	ids := make([]uint, len(list))
+	index := make(map[uint]*EventLog, len(list))
+	for i := range list {
+		ids[i] = list[i].ID
+		index[list[i].ID] = &list[i]
+	}
+	var labels []EventLogLabel
+	if err := db.Where("event_log_id IN (?)", ids).Find(&labels).Error; err != nil {
+		return err
+	}
+	for _, label := range labels {
+		if log := index[label.EventLogID]; log != nil {
+			log.EventLabels = append(log.EventLabels, label)
+		}
+	}
+	return nil
+}
+
+func checkpointPrivateKey() []byte {
+	return []byte(C().GetString("audit.checkpointKey"))
+}
+
+// 优先使用配置的公钥校验，避免检查点表被整体替换
+func checkpointPublicKey() []byte {
+	if v := C().GetString("audit.checkpointPublicKey"); v != "" {
+		return []byte(v)
+	}
+	if key := checkpointPrivateKey(); len(key) > 0 {
+		if pub, err := rsaPublicKeyPEM(key); err == nil {
+			return pub
+		}
+	}
+	return nil
+}
+
+func rsaPublicKeyPEM(privateKey []byte) ([]byte, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("private key error")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// CreateEventLogCheckpoints 为每个事件分类的最新记录生成签名检查点，未配置audit.checkpointKey时不生成
+func CreateEventLogCheckpoints() ([]EventLogCheckpoint, error) {
+	privateKey := checkpointPrivateKey()
+	if len(privateKey) == 0 {
+		return nil, nil
+	}
+	publicKey, err := rsaPublicKeyPEM(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	db := DB().Unscoped()
+	var classes []string
+	if err := db.Model(&EventLog{}).Where("hash <> ''").Pluck("DISTINCT(event_class)", &classes).Error; err != nil {
+		return nil, err
+	}
+	sort.Strings(classes)
+	var checkpoints []EventLogCheckpoint
+	for _, class := range classes {
+		var last EventLog
+		if err := db.Select("id, hash").Where("event_class = ? AND hash <> ''", class).Order("id desc").First(&last).Error; err != nil {
+			return nil, err
+		}
+		var prev EventLogCheckpoint
+		if err := db.Where("event_class = ?", class).Order("event_log_id desc").First(&prev).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return nil, err
+		}
+		if prev.EventLogID == last.ID {
+			continue
+		}
+		cp := EventLogCheckpoint{
+			EventClass: class,
+			EventLogID: last.ID,
+			Hash:       last.Hash,
+			SignedAt:   time.Now().Unix(),
+			PublicKey:  string(publicKey),
+		}
+		signature, err := RSASignWithSha256(cp.SignedContent(), privateKey)
+		if err != nil {
+			return nil, err
+		}
+		cp.Signature = base64.StdEncoding.EncodeToString(signature)
+		if err := DB().Create(&cp).Error; err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	return checkpoints, nil
+}
+
+// EventLogVerifyRoute
+var EventLogVerifyRoute = RouteInfo{
+	Name:   "校验事件日志完整性",
+	Method: "GET",
+	Path:   "/eventlogs/verify",
+	IntlMessages: map[string]string{
+		"eventlog_verify_unauthorized": "Unauthorized operation",
+		"eventlog_verify_failed":       "Verify event logs failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !c.HasPermission(EventLogVerifyPermission) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "eventlog_verify_unauthorized")
+		}
+		reports, err := VerifyEventLogChain(c.Query("class"))
+		if err != nil {
+			return c.STDErr(err, "eventlog_verify_failed")
+		}
+		return c.STD(reports)
+	},
+}
@@ -0,0 +1,116 @@
+package kuu
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventLogComputeHash(t *testing.T) {
+	log := EventLog{
+		EventID:    "e1",
+		EventTime:  1600000000,
+		EventClass: EventLogClassAudit,
+		EventLabels: []EventLogLabel{
+			{LabelKey: "Status", LabelValue: "200"},
+			{LabelKey: "Method", LabelValue: "POST"},
+		},
+	}
+	hash := log.ComputeHash()
+	reordered := log
+	reordered.EventLabels = []EventLogLabel{log.EventLabels[1], log.EventLabels[0]}
+	if reordered.ComputeHash() != hash {
+		t.Error("hash depends on label order")
+	}
+	modified := log
+	modified.EventSummary = "x"
+	if modified.ComputeHash() == hash {
+		t.Error("hash not changed after modification")
+	}
+	chained := log
+	chained.PrevHash = hash
+	if chained.ComputeHash() == hash {
+		t.Error("hash not changed with previous hash")
+	}
+	// 字段拼接不应产生相同摘要
+	a := EventLog{EventSubject: "ab", EventSummary: "c"}
+	b := EventLog{EventSubject: "a", EventSummary: "bc"}
+	if a.ComputeHash() == b.ComputeHash() {
+		t.Error("ambiguous field concatenation")
+	}
+}
+
+func TestEventLogCheckpointSignature(t *testing.T) {
+	prvKey, _ := GenRSAKey()
+	pubKey, err := rsaPublicKeyPEM(prvKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp := EventLogCheckpoint{EventClass: EventLogClassAudit, EventLogID: 10, Hash: "abc", SignedAt: 1600000000}
+	signature, err := RSASignWithSha256(cp.SignedContent(), prvKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RSAVerySignWithSha256(cp.SignedContent(), signature, pubKey); err != nil {
+		t.Error(err)
+	}
+	cp.Hash = "abd"
+	if err := RSAVerySignWithSha256(cp.SignedContent(), signature, pubKey); err == nil {
+		t.Error("tampered checkpoint verified")
+	}
+}
+
+func TestEventLogChainPrune(t *testing.T) {
+	defer useTestDB(t, &EventLog{}, &EventLogLabel{}, &EventLogCheckpoint{})()
+	prvKey, _ := GenRSAKey()
+	defer useTestConfig(`{"audit":{"checkpointKey":` + strconv.Quote(string(prvKey)) + `}}`)()
+
+	addLogs := func(n int) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := AddEventLog(EventLogDesc{Class: EventLogClassAudit, Summary: "test"}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	verify := func() EventLogChainReport {
+		reports, err := VerifyEventLogChain(EventLogClassAudit)
+		if err != nil || len(reports) != 1 {
+			t.Fatalf("unexpected verify result: %v %v", reports, err)
+		}
+		return reports[0]
+	}
+	// 并发写入不会使哈希链分叉
+	addLogs(5)
+	if _, err := CreateEventLogCheckpoints(); err != nil {
+		t.Fatal(err)
+	}
+	addLogs(5)
+	if _, err := CreateEventLogCheckpoints(); err != nil {
+		t.Fatal(err)
+	}
+	addLogs(2)
+	if report := verify(); !report.Valid || report.Checked != 12 || report.Checkpoints != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	// 只删除到过期的最新检查点之前
+	if n, err := pruneAuditLogsBefore(time.Now().Unix() + 1); err != nil || n != 9 {
+		t.Fatalf("unexpected prune result: %d %v", n, err)
+	}
+	if report := verify(); !report.Valid || report.Checked != 3 || report.Checkpoints != 1 {
+		t.Fatalf("unexpected report after prune: %+v", report)
+	}
+
+	// 删除锚点记录可被发现
+	DB().Unscoped().Where("id = ?", 10).Delete(&EventLog{})
+	if report := verify(); report.Valid {
+		t.Error("expected prefix deletion to be detected")
+	}
+}
@@ -0,0 +1,355 @@
+package kuu
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// EventLogClassAudit 请求审计
+	EventLogClassAudit = "AUDIT"
+
+	prisDescContextKey = "__kuu_privileges_desc__"
+)
+
+var (
+	// AuditRedactFields 审计时需要脱敏的字段（匹配字段名中包含的关键字，忽略大小写）
+	AuditRedactFields = []string{"password", "passwd", "secret", "token", "captcha", "code", "challenge", "recovery"}
+	// AuditMethods 默认审计的请求方法
+	AuditMethods = []string{"POST", "PUT", "PATCH", "DELETE"}
+	// AuditBodyLimit 请求体摘录的最大长度
+	AuditBodyLimit = 2048
+	// AuditRetentionDays 审计日志的默认保留天数
+	AuditRetentionDays = 180
+	// AuditBatchSize 每批写入的最大条数
+	AuditBatchSize = 100
+	// AuditFlushInterval 批量写入的间隔
+	AuditFlushInterval = 2 * time.Second
+	// AuditQueueSize 待写入队列长度，队列满时丢弃并输出错误日志
+	AuditQueueSize = 10000
+
+	auditQueue     chan *EventLogDesc
+	auditStartOnce sync.Once
+	auditFlushDone chan struct{}
+	auditMu        sync.RWMutex
+	auditClosed    bool
+)
+
+// AuditData 请求审计详情
+type AuditData struct {
+	Route     string
+	Method    string
+	Path      string
+	Query     string `json:",omitempty"`
+	Status    int
+	Duration  int64
+	RequestID string
+	OrgID     uint   `json:",omitempty"`
+	ActorUID  uint   `json:",omitempty"`
+	UserAgent string `json:",omitempty"`
+	Body      string `json:",omitempty"`
+}
+
+// AuditEnabled 判断请求是否需要审计：路由显式设置优先，否则按全局开关和请求方法判断
+func AuditEnabled(info *RouteInfo, method string) bool {
+	if info != nil && info.Audit.Valid {
+		return info.Audit.Bool
+	}
+	if !C().GetBool("audit.enabled") {
+		return false
+	}
+	methods := AuditMethods
+	C().GetInterface("audit.methods", &methods)
+	for _, item := range methods {
+		if item == "*" || strings.EqualFold(item, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditMiddleware 记录请求审计日志
+func AuditMiddleware(c *Context) *STDReply {
+	info := matchRouteInfo(c.Request.Method, c.Request.URL.Path)
+	if !AuditEnabled(info, c.Request.Method) {
+		c.Next()
+		return nil
+	}
+	start := time.Now()
+	body := readAuditBody(c)
+	c.Next()
+
+	data := AuditData{
+		Method:    c.Request.Method,
+		Path:      c.Request.URL.Path,
+		Query:     RedactAuditBody("application/x-www-form-urlencoded", c.Request.URL.RawQuery),
+		Status:    c.Writer.Status(),
+		Duration:  time.Since(start).Milliseconds(),
+		RequestID: c.RequestID(),
+		UserAgent: c.Request.UserAgent(),
+		Body:      body,
+	}
+	if info != nil {
+		data.Route = info.Name
+	}
+	desc := &EventLogDesc{
+		Class:    EventLogClassAudit,
+		Subject:  data.Route,
+		Summary:  fmt.Sprintf("%s %s %d %dms", data.Method, data.Path, data.Status, data.Duration),
+		SourceIP: c.ClientIP(),
+		Labels: map[string]string{
+			"Method": data.Method,
+			"Status": fmt.Sprintf("%d", data.Status),
+		},
+	}
+	if desc.Subject == "" {
+		desc.Subject = fmt.Sprintf("%s %s", data.Method, data.Path)
+	}
+	if sign, err := c.DecodedContext(); err == nil && sign != nil {
+		desc.UID = sign.UID
+		desc.Username = sign.Username
+		if sign.ActorUID != sign.UID {
+			data.ActorUID = sign.ActorUID
+		}
+	}
+	if v, has := c.Get(prisDescContextKey); has {
+		if prisDesc, ok := v.(*PrivilegesDesc); ok && prisDesc != nil {
+			data.OrgID = prisDesc.ActOrgID
+		}
+	}
+	desc.Data = data
+	EnqueueEventLog(desc)
+	return nil
+}
+
+func readAuditBody(c *Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	contentType := c.ContentType()
+	if strings.HasPrefix(contentType, "multipart/") {
+		return "[multipart]"
+	}
+	// 只读取摘录所需的长度，避免大请求体全部进入内存
+	var reader io.Reader = c.Request.Body
+	if limit := C().DefaultGetInt("audit.bodyLimit", AuditBodyLimit); limit > 0 {
+		reader = io.LimitReader(reader, int64(limit)+1)
+	}
+	raw, err := ioutil.ReadAll(reader)
+	if err != nil {
+		ERROR(err)
+	}
+	// 回写请求体，不影响后续处理
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(raw), c.Request.Body), c.Request.Body}
+	if len(raw) == 0 {
+		return ""
+	}
+	return RedactAuditBody(contentType, string(raw))
+}
+
+var auditJSONFieldRegexp = regexp.MustCompile(`"([^"\\]*)"(\s*:\s*)("(?:[^"\\]|\\.)*"|[^,{}\[\]\s]+)`)
+
+func auditSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, item := range AuditRedactFields {
+		if strings.Contains(key, strings.ToLower(item)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactAuditBody 对请求体中的敏感字段脱敏并截断
+func RedactAuditBody(contentType, body string) string {
+	if body == "" {
+		return ""
+	}
+	if strings.Contains(contentType, "x-www-form-urlencoded") {
+		if values, err := url.ParseQuery(body); err == nil {
+			for key := range values {
+				if auditSensitive(key) {
+					values.Set(key, "***")
+				}
+			}
+			body = values.Encode()
+		}
+	} else {
+		body = auditJSONFieldRegexp.ReplaceAllStringFunc(body, func(s string) string {
+			m := auditJSONFieldRegexp.FindStringSubmatch(s)
+			if !auditSensitive(m[1]) {
+				return s
+			}
+			return fmt.Sprintf(`"%s"%s"***"`, m[1], m[2])
+		})
+	}
+	limit := C().DefaultGetInt("audit.bodyLimit", AuditBodyLimit)
+	if limit > 0 && len(body) > limit {
+		// 在字符边界截断，避免产生非法的UTF-8
+		for limit > 0 && !utf8.RuneStart(body[limit]) {
+			limit--
+		}
+		body = body[:limit] + "...(truncated)"
+	}
+	return body
+}
+
+// EnqueueEventLog 异步批量写入事件日志，调用方不等待写入结果
+func EnqueueEventLog(desc *EventLogDesc) {
+	auditMu.RLock()
+	defer auditMu.RUnlock()
+	// 关闭后不再接收，避免向已关闭的队列发送
+	if auditClosed {
+		ERROR("event log queue is closed, dropped: %s", desc.Summary)
+		return
+	}
+	auditStartOnce.Do(startAuditWriter)
+	select {
+	case auditQueue <- desc:
+	default:
+		ERROR("event log queue is full, dropped: %s", desc.Summary)
+	}
+}
+
+func startAuditWriter() {
+	auditQueue = make(chan *EventLogDesc, AuditQueueSize)
+	auditFlushDone = make(chan struct{})
+	go func() {
+		defer close(auditFlushDone)
+		ticker := time.NewTicker(AuditFlushInterval)
+		defer ticker.Stop()
+		batch := make([]*EventLogDesc, 0, AuditBatchSize)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			if err := saveEventLogs(batch); err != nil {
+				ERROR(err)
+			}
+			batch = batch[:0]
+		}
+		for {
+			select {
+			case desc, ok := <-auditQueue:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, desc)
+				if len(batch) >= AuditBatchSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+func saveEventLogs(list []*EventLogDesc) error {
+	return withEventLogChain(func(tx *gorm.DB) error {
+		for _, desc := range list {
+			if _, err := createEventLog(tx, *desc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 关闭时写入队列中剩余的日志
+func flushEventLogs() {
+	auditMu.Lock()
+	if auditClosed || auditQueue == nil {
+		auditClosed = true
+		auditMu.Unlock()
+		return
+	}
+	auditClosed = true
+	close(auditQueue)
+	auditMu.Unlock()
+	select {
+	case <-auditFlushDone:
+	case <-time.After(5 * time.Second):
+		ERROR("flush event logs timeout")
+	}
+}
+
+// PruneAuditLogs 删除超过保留天数的审计日志，存在检查点时只删除到过期的最新检查点之前，并删除更早的检查点
+func PruneAuditLogs(days int) (int64, error) {
+	if days <= 0 {
+		return 0, nil
+	}
+	return pruneAuditLogsBefore(time.Now().AddDate(0, 0, -days).Unix())
+}
+
+func pruneAuditLogsBefore(before int64) (int64, error) {
+	var affected int64
+	err := WithTransaction(func(tx *gorm.DB) error {
+		var count int
+		if err := tx.Unscoped().Model(&EventLogCheckpoint{}).Where("event_class = ?", EventLogClassAudit).Count(&count).Error; err != nil {
+			return err
+		}
+		cond := tx.Unscoped().Where("event_class = ? AND event_time < ?", EventLogClassAudit, before)
+		if count > 0 {
+			// 保留检查点指向的记录作为哈希链的锚点
+			var anchor EventLogCheckpoint
+			err := tx.Unscoped().Where("event_class = ? AND event_log_id IN (?)", EventLogClassAudit,
+				tx.Unscoped().Model(&EventLog{}).Select("id").Where("event_class = ? AND event_time < ?", EventLogClassAudit, before).QueryExpr()).
+				Order("event_log_id desc").First(&anchor).Error
+			if gorm.IsRecordNotFoundError(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where("event_class = ? AND event_log_id < ?", EventLogClassAudit, anchor.EventLogID).Delete(&EventLogCheckpoint{}).Error; err != nil {
+				return err
+			}
+			cond = tx.Unscoped().Where("event_class = ? AND id < ?", EventLogClassAudit, anchor.EventLogID)
+		}
+		sub := cond.Model(&EventLog{}).Select("id").SubQuery()
+		if err := tx.Unscoped().Where("event_log_id IN ?", sub).Delete(&EventLogLabel{}).Error; err != nil {
+			return err
+		}
+		ret := cond.Delete(&EventLog{})
+		affected = ret.RowsAffected
+		return ret.Error
+	})
+	return affected, err
+}
+
+func initAuditJobs() error {
+	_, err := AddJob("@daily", "Prune audit logs", func(c *JobContext) {
+		days := C().DefaultGetInt("audit.retentionDays", AuditRetentionDays)
+		if n, err := PruneAuditLogs(days); err != nil {
+			c.Error(err)
+		} else if n > 0 {
+			INFO("Pruned %d audit logs older than %d days", n, days)
+		}
+	})
+	if err != nil || len(checkpointPrivateKey()) == 0 {
+		return err
+	}
+	spec := C().GetString("audit.checkpointSpec")
+	if spec == "" {
+		spec = "@hourly"
+	}
+	_, err = AddJob(spec, "Sign event log checkpoints", func(c *JobContext) {
+		if _, err := CreateEventLogCheckpoints(); err != nil {
+			c.Error(err)
+		}
+	})
+	return err
+}
@@ -0,0 +1,111 @@
+package kuu
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRedactAuditBody(t *testing.T) {
+	body := RedactAuditBody("application/json", `{"Username":"admin","Password":"abc\"123","Token": "t", "Nested":{"client_secret":42}}`)
+	if strings.Contains(body, "abc") || strings.Contains(body, `"t"`) || strings.Contains(body, "42") {
+		t.Errorf("sensitive fields not redacted: %s", body)
+	}
+	if !strings.Contains(body, `"Username":"admin"`) {
+		t.Errorf("unexpected redaction: %s", body)
+	}
+	form := RedactAuditBody("application/x-www-form-urlencoded", "username=admin&password=123")
+	if form != "password=%2A%2A%2A&username=admin" {
+		t.Errorf("unexpected form redaction: %s", form)
+	}
+	long := RedactAuditBody("text/plain", strings.Repeat("a", AuditBodyLimit+10))
+	if !strings.HasSuffix(long, "...(truncated)") {
+		t.Error("body not truncated")
+	}
+	oauth2 := RedactAuditBody("application/x-www-form-urlencoded", "code=abc&code_challenge=xyz&state=1")
+	if oauth2 != "code=%2A%2A%2A&code_challenge=%2A%2A%2A&state=1" {
+		t.Errorf("unexpected oauth2 redaction: %s", oauth2)
+	}
+	// 在多字节字符中间截断时回退到字符边界
+	cut := RedactAuditBody("text/plain", "a"+strings.Repeat("中", AuditBodyLimit))
+	if !utf8.ValidString(cut) || !strings.HasSuffix(cut, "...(truncated)") {
+		t.Errorf("invalid truncation: %q", cut[len(cut)-20:])
+	}
+}
+
+func TestMatchRoutePath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/api/apikeys/:id/rotate", "/api/apikeys/12/rotate", true},
+		{"/api/apikeys/:id/rotate", "/api/apikeys/12", false},
+		{"/api/files/*path", "/api/files/a/b.txt", true},
+		{"/api/login", "/api/login", true},
+		{"/api/login", "/api/logout", false},
+	}
+	for _, item := range cases {
+		if got := matchRoutePath(item.pattern, item.path); got != item.want {
+			t.Errorf("matchRoutePath(%q, %q) = %v", item.pattern, item.path, got)
+		}
+	}
+}
+
+func TestMatchRouteInfoOrder(t *testing.T) {
+	prevMap, prevMatchers := routesMap, routeMatchers
+	defer func() { routesMap, routeMatchers = prevMap, prevMatchers }()
+	routesMap, routeMatchers = make(map[string]RouteInfo), nil
+
+	for _, item := range []RouteInfo{
+		{Method: "GET", Path: "/api/files/*path", Name: "wildcard"},
+		{Method: "GET", Path: "/api/:model/:id", Name: "params"},
+		{Method: "GET", Path: "/api/users/:id", Name: "user"},
+		{Method: "GET", Path: "/api/users/me", Name: "me"},
+	} {
+		routesMap[item.Method+" "+item.Path] = item
+		addRouteMatcher(item.Method, item.Path, item)
+	}
+	cases := map[string]string{
+		"/api/users/me":    "me",
+		"/api/users/12":    "user",
+		"/api/orders/12":   "params",
+		"/api/files/a/b":   "wildcard",
+		"/api/files/a":     "params",
+		"/api/unknown/a/b": "",
+	}
+	for i := 0; i < 10; i++ {
+		for path, want := range cases {
+			var got string
+			if info := matchRouteInfo("GET", path); info != nil {
+				got = info.Name
+			}
+			if got != want {
+				t.Errorf("matchRouteInfo(%q) = %q, want %q", path, got, want)
+			}
+		}
+	}
+	if matchRouteInfo("POST", "/api/users/12") != nil {
+		t.Error("expected method to be matched")
+	}
+}
+
+func TestReadAuditBodyRestoresBody(t *testing.T) {
+	body := `{"Name":"` + strings.Repeat("a", AuditBodyLimit*2) + `"}`
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest("POST", "/user", strings.NewReader(body))
+	ginCtx.Request.Header.Set("Content-Type", "application/json")
+	c := &Context{Context: ginCtx}
+
+	if excerpt := readAuditBody(c); !strings.HasSuffix(excerpt, "...(truncated)") {
+		t.Errorf("expected truncated excerpt, got %d bytes", len(excerpt))
+	}
+	raw, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil || string(raw) != body {
+		t.Errorf("expected the full body to be restored, got %d bytes: %v", len(raw), err)
+	}
+}
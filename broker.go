@@ -0,0 +1,222 @@
+package kuu
+
+import (
+	"encoding"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BrokerQueueSize 每个订阅的待处理消息数，超出时丢弃并输出错误日志
+var BrokerQueueSize = 1024
+
+type brokerMessage struct {
+	channel string
+	payload string
+}
+
+type brokerSubscription struct {
+	channels map[string]bool
+	patterns []string
+	handler  func(string, string)
+	queue    chan brokerMessage
+}
+
+func (s *brokerSubscription) match(channel string) bool {
+	if s.channels[channel] {
+		return true
+	}
+	for _, pattern := range s.patterns {
+		if GlobMatch(pattern, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// 进程内的发布订阅，用于未配置Redis的单机部署
+type memoryBroker struct {
+	mu     sync.RWMutex
+	subs   []*brokerSubscription
+	closed bool
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{}
+}
+
+// 与Redis客户端一致，仅支持基础类型及encoding.BinaryMarshaler
+func brokerPayload(message interface{}) (string, error) {
+	switch v := message.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case encoding.BinaryMarshaler:
+		data, err := v.MarshalBinary()
+		return string(data), err
+	}
+	return "", fmt.Errorf("can't marshal %T (implement encoding.BinaryMarshaler)", message)
+}
+
+func (b *memoryBroker) publish(channel string, message interface{}) error {
+	payload, err := brokerPayload(message)
+	if err != nil {
+		return err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return nil
+	}
+	for _, sub := range b.subs {
+		if !sub.match(channel) {
+			continue
+		}
+		select {
+		case sub.queue <- brokerMessage{channel, payload}:
+		default:
+			ERROR("broker queue is full, dropped message on channel: %s", channel)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) subscribe(channels, patterns []string, handler func(string, string)) error {
+	if handler == nil {
+		return fmt.Errorf("subscribe handler is required")
+	}
+	sub := &brokerSubscription{
+		channels: make(map[string]bool, len(channels)),
+		patterns: patterns,
+		handler:  handler,
+		queue:    make(chan brokerMessage, BrokerQueueSize),
+	}
+	for _, channel := range channels {
+		sub.channels[channel] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return fmt.Errorf("broker closed")
+	}
+	b.subs = append(b.subs, sub)
+	// 与Redis一致，每个订阅按顺序异步处理消息
+	go func() {
+		for msg := range sub.queue {
+			sub.handler(msg.channel, msg.payload)
+		}
+	}()
+	return nil
+}
+
+func (b *memoryBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, sub := range b.subs {
+		close(sub.queue)
+	}
+	b.subs = nil
+}
+
+// GlobMatch 按Redis PSUBSCRIBE的规则匹配：支持*、?、[abc]、[^a]、[a-z]及反斜杠转义
+func GlobMatch(pattern, str string) bool {
+	// 双指针匹配，失配时回溯到最近一个*并让其多匹配一个字符，避免递归导致的指数级耗时
+	var (
+		pi, si    int
+		star      = -1
+		starMatch int
+	)
+	for si < len(str) {
+		if pi < len(pattern) && pattern[pi] == '*' {
+			for pi < len(pattern) && pattern[pi] == '*' {
+				pi++
+			}
+			star, starMatch = pi, si
+			continue
+		}
+		if pi < len(pattern) {
+			if next, ok := globMatchOne(pattern, pi, str[si]); ok {
+				pi, si = next, si+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starMatch++
+		pi, si = star, starMatch
+	}
+	for pi < len(pattern) && pattern[pi] == '*' {
+		pi++
+	}
+	return pi == len(pattern)
+}
+
+// 用pattern[pi:]开头的单个元素匹配字符c，返回下一个元素的位置
+func globMatchOne(pattern string, pi int, c byte) (int, bool) {
+	switch pattern[pi] {
+	case '?':
+		return pi + 1, true
+	case '[':
+		pi++
+		not := pi < len(pattern) && pattern[pi] == '^'
+		if not {
+			pi++
+		}
+		matched := false
+		for pi < len(pattern) && pattern[pi] != ']' {
+			if pattern[pi] == '\\' && pi+1 < len(pattern) {
+				pi++
+				if pattern[pi] == c {
+					matched = true
+				}
+			} else if pi+2 < len(pattern) && pattern[pi+1] == '-' {
+				start, end := pattern[pi], pattern[pi+2]
+				if start > end {
+					start, end = end, start
+				}
+				if c >= start && c <= end {
+					matched = true
+				}
+				pi += 2
+			} else if pattern[pi] == c {
+				matched = true
+			}
+			pi++
+		}
+		if not {
+			matched = !matched
+		}
+		// 缺少右括号时视为已到模式末尾
+		if pi < len(pattern) {
+			pi++
+		}
+		return pi, matched
+	case '\\':
+		if pi+1 < len(pattern) {
+			pi++
+		}
+	}
+	return pi + 1, pattern[pi] == c
+}
@@ -0,0 +1,106 @@
+package kuu
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"*", "anything", true},
+		{"news.*", "news.sport", true},
+		{"news.*", "news.sport.football", true},
+		{"news.*", "weather", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "aXXbYYc", true},
+		{"a*b*c", "aXXbYY", false},
+		{"", "", true},
+		{"*[0-9]", "user_42", true},
+		{"*.[^x]", "a.b.x", false},
+		{"a*b", "ab", true},
+		{"h[ae", "ha", true},
+		{`a\\`, `a\`, true},
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 60), false},
+	}
+	for _, item := range cases {
+		if got := GlobMatch(item.pattern, item.str); got != item.want {
+			t.Errorf("GlobMatch(%q, %q) = %v", item.pattern, item.str, got)
+		}
+	}
+}
+
+func TestMemoryBroker(t *testing.T) {
+	b := newMemoryBroker()
+	defer b.close()
+
+	var (
+		mu       sync.Mutex
+		received []string
+		wg       sync.WaitGroup
+	)
+	handler := func(channel, payload string) {
+		mu.Lock()
+		received = append(received, channel+":"+payload)
+		mu.Unlock()
+		wg.Done()
+	}
+	if err := b.subscribe([]string{"intl"}, nil, handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.subscribe(nil, []string{"sign_*"}, handler); err != nil {
+		t.Fatal(err)
+	}
+	wg.Add(3)
+	for _, item := range []struct {
+		channel string
+		message interface{}
+	}{
+		{"intl", "en"},
+		{"sign_revoked", 42},
+		{"other", "ignored"},
+		{"sign_x", true},
+	} {
+		if err := b.publish(item.channel, item.message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("messages not delivered")
+	}
+	want := map[string]bool{"intl:en": true, "sign_revoked:42": true, "sign_x:1": true}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != len(want) {
+		t.Fatalf("unexpected messages: %v", received)
+	}
+	for _, item := range received {
+		if !want[item] {
+			t.Errorf("unexpected message: %s", item)
+		}
+	}
+	if err := b.publish("intl", map[string]string{}); err == nil {
+		t.Error("expected marshal error")
+	}
+}
@@ -2,6 +2,8 @@ package kuu
 
 import (
 	"encoding/binary"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -26,14 +28,47 @@ type Cache interface {
 	PSubscribe(patterns []string, handler func(string, string)) error
 }
 
-func init() {
-	if C().Has("redis") {
-		// 初始化redis
-		DefaultCache = NewCacheRedis()
-	} else {
-		// 初始化bolt
-		DefaultCache = NewCacheBolt()
+const (
+	CacheDriverRedis  = "redis"
+	CacheDriverBolt   = "bolt"
+	CacheDriverMemory = "memory"
+)
+
+// NewCacheFromConfig 按配置cache.driver创建缓存，未配置时存在redis配置则使用Redis，否则使用Bolt
+func NewCacheFromConfig() Cache {
+	driver := strings.ToLower(C().GetString("cache.driver"))
+	if driver == "" {
+		if C().Has("redis") {
+			driver = CacheDriverRedis
+		} else {
+			driver = CacheDriverBolt
+		}
+	}
+	var cache Cache
+	switch driver {
+	case CacheDriverRedis:
+		cache = NewCacheRedis()
+	case CacheDriverMemory:
+		return NewCacheMemory(C().GetInt("cache.maxEntries"))
+	case CacheDriverBolt:
+		cache = NewCacheBolt(C().GetString("cache.path"))
+	default:
+		PANIC("unsupported cache driver: %s", driver)
 	}
+	// 配置cache.l1时在本地内存中缓存热点键
+	if C().Has("cache.l1") {
+		var l1 struct {
+			TTL        int `json:"ttl"`
+			MaxEntries int `json:"maxEntries"`
+		}
+		C().GetInterface("cache.l1", &l1)
+		cache = NewCacheTiered(cache, time.Duration(l1.TTL)*time.Second, l1.MaxEntries)
+	}
+	return cache
+}
+
+func init() {
+	DefaultCache = NewCacheFromConfig()
 	_ = DefaultCache.Subscribe([]string{intlMessagesChangedChannel}, func(c string, d string) {
 		ReloadIntlMessages()
 	})
@@ -96,6 +131,67 @@ func IncrCache(key string) (val int) {
 	return
 }
 
+// CacheExpirer 支持为已存在的键设置过期时间的缓存
+type CacheExpirer interface {
+	Expire(string, time.Duration) bool
+}
+
+// ExpireCache 设置已存在的键的过期时间，缓存不支持时返回false
+func ExpireCache(key string, expiration time.Duration) bool {
+	if v, ok := DefaultCache.(CacheExpirer); ok {
+		return v.Expire(key, expiration)
+	}
+	return false
+}
+
+// 递增计数，首次创建时设置过期时间（不会覆盖并发递增的结果）
+func incrCacheWithExpiration(key string, expiration time.Duration) (val int) {
+	if c, ok := DefaultCache.(StructuredCache); ok {
+		return c.IncrBy(key, 1, expiration)
+	}
+	val = IncrCache(key)
+	if val == 1 && !ExpireCache(key, expiration) {
+		SetCacheInt(key, val, expiration)
+	}
+	return
+}
+
+// 按固定时间窗口计数（键名包含窗口序号，不依赖缓存过期）
+func incrCacheWindow(key string, window time.Duration) (val int) {
+	key = fmt.Sprintf("%s_%d", key, time.Now().UnixNano()/int64(window))
+	return incrCacheWithExpiration(key, window)
+}
+
+// 按滑动窗口计数：当前窗口计数加上前一窗口按剩余时间比例折算的计数
+func incrSlidingWindow(key string, window time.Duration) int {
+	now := time.Now().UnixNano()
+	curKey := fmt.Sprintf("%s_%d", key, now/int64(window))
+	// 保留两个窗口，以便下一窗口读取
+	cur := incrCacheWithExpiration(curKey, window*2)
+	return slidingWindowCount(key, window, now, cur)
+}
+
+// 查询滑动窗口计数，不增加计数
+func peekSlidingWindow(key string, window time.Duration) int {
+	now := time.Now().UnixNano()
+	return slidingWindowCount(key, window, now, GetCacheInt(fmt.Sprintf("%s_%d", key, now/int64(window))))
+}
+
+// 清除滑动窗口计数
+func clearSlidingWindow(key string, window time.Duration) {
+	idx := time.Now().UnixNano() / int64(window)
+	DelCache(fmt.Sprintf("%s_%d", key, idx), fmt.Sprintf("%s_%d", key, idx-1))
+}
+
+func slidingWindowCount(key string, window time.Duration, now int64, cur int) int {
+	prev := GetCacheInt(fmt.Sprintf("%s_%d", key, now/int64(window)-1))
+	return slidingWindowEstimate(prev, cur, float64(now%int64(window))/float64(window))
+}
+
+func slidingWindowEstimate(prev, cur int, elapsed float64) int {
+	return cur + int(float64(prev)*(1-elapsed))
+}
+
 // HasPrefixCache
 func HasPrefixCache(key string, limit int) (val map[string]string) {
 	if DefaultCache != nil {
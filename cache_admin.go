@@ -0,0 +1,295 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// CacheAdminPermission 缓存管理接口的权限编码
+	CacheAdminPermission = "sys_cache_admin"
+	// CacheAdminKeysLimit 列出键时的最大数量
+	CacheAdminKeysLimit = 1000
+	// CacheDelBatchSize 按模式删除时每批删除的键数
+	CacheDelBatchSize = 500
+)
+
+var (
+	// CacheSecretKeyPrefixes 键名中含有一次性凭据的前缀，列出时掩码键名，查看时隐藏值
+	CacheSecretKeyPrefixes = []string{"two_factor_challenge_", "oidc_state_", "sign_revoked_", "oauth2_code_"}
+	// CacheRedactFields 查看缓存值时需要脱敏的JSON字段（匹配字段名中包含的关键字，忽略大小写）
+	CacheRedactFields = []string{"password", "passwd", "secret", "token", "hash", "challenge", "nonce", "verifier", "recovery"}
+)
+
+// CacheEntry 缓存键的详情
+type CacheEntry struct {
+	Key  string
+	Type string
+	// TTL 剩余秒数，-1表示未设置过期时间
+	TTL   int64
+	Value interface{} `json:",omitempty"`
+}
+
+// CacheInfo 缓存统计
+type CacheInfo struct {
+	Driver string
+	Keys   int64
+	// Bytes 占用空间：Redis为used_memory，Bolt为文件大小，内存缓存为键和值的长度之和
+	Bytes int64
+	// L1 多级缓存的本地缓存命中统计
+	L1 *CacheStats `json:",omitempty"`
+}
+
+// CacheInspector 支持按模式列出键、查看详情及统计的缓存
+type CacheInspector interface {
+	// Keys 按Redis通配符规则列出键，limit为0时不限制
+	Keys(pattern string, limit int) []string
+	// Inspect 返回键的详情，键不存在时返回nil
+	Inspect(key string) *CacheEntry
+	Info() CacheInfo
+}
+
+func cacheInspector() (CacheInspector, error) {
+	if v, ok := DefaultCache.(CacheInspector); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("cache does not support inspection: %T", DefaultCache)
+}
+
+// KeysCache 按Redis通配符规则列出键
+func KeysCache(pattern string, limit int) []string {
+	c, err := cacheInspector()
+	if err != nil {
+		ERROR(err)
+		return nil
+	}
+	return c.Keys(pattern, limit)
+}
+
+// InspectCache 返回键的详情，键不存在时返回nil
+func InspectCache(key string) *CacheEntry {
+	c, err := cacheInspector()
+	if err != nil {
+		ERROR(err)
+		return nil
+	}
+	return c.Inspect(key)
+}
+
+// GetCacheInfo
+func GetCacheInfo() (info CacheInfo) {
+	c, err := cacheInspector()
+	if err != nil {
+		ERROR(err)
+		return
+	}
+	return c.Info()
+}
+
+// DelCacheByPattern 删除匹配的键，返回删除数量
+func DelCacheByPattern(pattern string) int {
+	keys := KeysCache(pattern, 0)
+	for start := 0; start < len(keys); start += CacheDelBatchSize {
+		end := start + CacheDelBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		DelCache(keys[start:end]...)
+	}
+	return len(keys)
+}
+
+// 通配符前不含特殊字符的部分，用于按前缀查找
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, `*?[\`); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+func cacheTTLSeconds(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return int64(ttl)
+	}
+	return int64(math.Ceil(ttl.Seconds()))
+}
+
+// Bolt和内存缓存中的值，Bolt的计数以8字节二进制保存
+func cacheValue(raw []byte, binaryInt bool) interface{} {
+	if binaryInt && len(raw) == 8 && (!utf8.Valid(raw) || strings.ContainsRune(string(raw), 0)) {
+		return btoi(raw)
+	}
+	return string(raw)
+}
+
+func cacheSecretKeyPrefix(key string) string {
+	for _, prefix := range CacheSecretKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// 掩码键名中的凭据部分
+func maskCacheKey(key string) string {
+	if prefix := cacheSecretKeyPrefix(key); prefix != "" {
+		return prefix + "***"
+	}
+	return key
+}
+
+func cacheSensitive(field string) bool {
+	field = strings.ToLower(field)
+	for _, item := range CacheRedactFields {
+		if strings.Contains(field, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// 对JSON字符串中的敏感字段脱敏，如缓存的用户信息中的密码
+func redactCacheString(value string) string {
+	return auditJSONFieldRegexp.ReplaceAllStringFunc(value, func(s string) string {
+		m := auditJSONFieldRegexp.FindStringSubmatch(s)
+		if !cacheSensitive(m[1]) {
+			return s
+		}
+		return fmt.Sprintf(`"%s"%s"***"`, m[1], m[2])
+	})
+}
+
+// 脱敏缓存值，凭据类的键隐藏全部值
+func redactCacheEntry(entry *CacheEntry) *CacheEntry {
+	if cacheSecretKeyPrefix(entry.Key) != "" {
+		entry.Key = maskCacheKey(entry.Key)
+		entry.Value = "***"
+		return entry
+	}
+	switch v := entry.Value.(type) {
+	case string:
+		entry.Value = redactCacheString(v)
+	case map[string]string:
+		values := make(map[string]string, len(v))
+		for field, item := range v {
+			if cacheSensitive(field) {
+				item = "***"
+			}
+			values[field] = redactCacheString(item)
+		}
+		entry.Value = values
+	case []string:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = redactCacheString(item)
+		}
+		entry.Value = values
+	}
+	return entry
+}
+
+func cacheAdminAuthorized(c *Context) bool {
+	return c.HasPermission(CacheAdminPermission)
+}
+
+// 查询参数ns指定命名空间时，模式限定在命名空间内
+func cacheAdminPattern(c *Context) string {
+	pattern := c.DefaultQuery("pattern", "*")
+	if ns := c.Query("ns"); ns != "" {
+		pattern = NewCacheNamespace(ns).Key(pattern)
+	}
+	return pattern
+}
+
+// CacheKeysRoute
+var CacheKeysRoute = RouteInfo{
+	Name:   "查询缓存键",
+	Method: "GET",
+	Path:   "/cache/keys",
+	IntlMessages: map[string]string{
+		"cache_admin_unauthorized": "Unauthorized operation",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !cacheAdminAuthorized(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "cache_admin_unauthorized")
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+		if limit <= 0 || limit > CacheAdminKeysLimit {
+			limit = CacheAdminKeysLimit
+		}
+		entries := make([]*CacheEntry, 0)
+		for _, key := range KeysCache(cacheAdminPattern(c), limit) {
+			if entry := InspectCache(key); entry != nil {
+				entry.Key = maskCacheKey(entry.Key)
+				entry.Value = nil
+				entries = append(entries, entry)
+			}
+		}
+		return c.STD(entries)
+	},
+}
+
+// CacheValueRoute
+var CacheValueRoute = RouteInfo{
+	Name:   "查看缓存值",
+	Method: "GET",
+	Path:   "/cache/value",
+	IntlMessages: map[string]string{
+		"cache_admin_not_found": "Cache key not found",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !cacheAdminAuthorized(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "cache_admin_unauthorized")
+		}
+		key := c.Query("key")
+		if ns := c.Query("ns"); ns != "" {
+			key = NewCacheNamespace(ns).Key(key)
+		}
+		entry := InspectCache(key)
+		if entry == nil {
+			return c.STDErr(fmt.Errorf("cache key not found: %s", maskCacheKey(key)), "cache_admin_not_found")
+		}
+		return c.STD(redactCacheEntry(entry))
+	},
+}
+
+// CacheDeleteRoute
+var CacheDeleteRoute = RouteInfo{
+	Name:   "删除缓存键",
+	Method: "DELETE",
+	Path:   "/cache/keys",
+	IntlMessages: map[string]string{
+		"cache_admin_pattern_required": "Pattern is required",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !cacheAdminAuthorized(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "cache_admin_unauthorized")
+		}
+		// 不提供默认值，避免误删全部缓存
+		if c.Query("pattern") == "" {
+			return c.STDErr(errors.New("pattern is required"), "cache_admin_pattern_required")
+		}
+		count := DelCacheByPattern(cacheAdminPattern(c))
+		INFO("cache keys deleted by uid=%v: pattern=%s, count=%d", c.SignInfo.UID, cacheAdminPattern(c), count)
+		return c.STD(count)
+	},
+}
+
+// CacheStatsRoute
+var CacheStatsRoute = RouteInfo{
+	Name:   "查询缓存统计",
+	Method: "GET",
+	Path:   "/cache/stats",
+	HandlerFunc: func(c *Context) *STDReply {
+		if !cacheAdminAuthorized(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "cache_admin_unauthorized")
+		}
+		return c.STD(GetCacheInfo())
+	},
+}
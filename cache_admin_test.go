@@ -0,0 +1,111 @@
+package kuu
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testCacheInspector(t *testing.T, c interface {
+	Cache
+	CacheInspector
+}) {
+	c.SetString("user_1", "a", time.Minute)
+	c.SetString("user_2", "b")
+	c.SetInt("user_count", 2)
+	c.SetString("role_1", "c")
+
+	if keys := c.Keys("user_?", 0); !reflect.DeepEqual(keys, []string{"user_1", "user_2"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if keys := c.Keys("*_1", 0); !reflect.DeepEqual(keys, []string{"role_1", "user_1"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if keys := c.Keys("*", 2); len(keys) != 2 {
+		t.Errorf("limit not applied: %v", keys)
+	}
+
+	if entry := c.Inspect("user_1"); entry == nil || entry.Value != "a" || entry.TTL <= 0 || entry.TTL > 60 {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry := c.Inspect("user_2"); entry == nil || entry.TTL != -1 {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	// Bolt以二进制保存计数，内存缓存以字符串保存
+	if entry := c.Inspect("user_count"); entry == nil || fmt.Sprint(entry.Value) != "2" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry := c.Inspect("user_3"); entry != nil {
+		t.Errorf("expected nil, got %+v", entry)
+	}
+	if info := c.Info(); info.Keys != 4 || info.Bytes <= 0 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
+
+func TestCacheMemoryInspector(t *testing.T) {
+	c := NewCacheMemory()
+	defer c.Close()
+	testCacheInspector(t, c)
+}
+
+func TestCacheBoltInspector(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	testCacheInspector(t, c)
+}
+
+func TestCacheNamespace(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	acc, sys := (&Mod{Code: "Acc"}).Cache(), NewCacheNamespace("sys")
+	acc.SetString("user_1", "a")
+	acc.SetString("user_2", "b")
+	sys.SetString("user_1", "c")
+
+	if acc.GetString("user_1") != "a" || sys.GetString("user_1") != "c" || GetCacheString("acc:user_1") != "a" {
+		t.Fatal("namespaces are not isolated")
+	}
+	if keys := acc.Keys("*", 0); !reflect.DeepEqual(keys, []string{"user_1", "user_2"}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+	if n := acc.Flush(); n != 2 {
+		t.Errorf("expected 2 deleted keys, got %d", n)
+	}
+	if acc.GetString("user_1") != "" || sys.GetString("user_1") != "c" {
+		t.Error("flush removed keys of other namespaces")
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	cases := map[string]string{
+		"user_*":   "user_",
+		"user_?_a": "user_",
+		"[ab]*":    "",
+		"acc:key":  "acc:key",
+	}
+	for pattern, want := range cases {
+		if got := globPrefix(pattern); got != want {
+			t.Errorf("%s: expected %q, got %q", pattern, want, got)
+		}
+	}
+}
+
+func TestRedactCacheEntry(t *testing.T) {
+	entry := redactCacheEntry(&CacheEntry{Key: "user_1", Value: `{"ID":1,"Username":"admin","Password":"$2a$10$abc","Name":"Admin"}`})
+	if v := entry.Value.(string); v != `{"ID":1,"Username":"admin","Password":"***","Name":"Admin"}` {
+		t.Errorf("unexpected value: %s", v)
+	}
+	entry = redactCacheEntry(&CacheEntry{Key: "two_factor_challenge_abc123", Value: `{"UID":1}`})
+	if entry.Key != "two_factor_challenge_***" || entry.Value != "***" {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	entry = redactCacheEntry(&CacheEntry{Key: "session", Value: map[string]string{"Token": "t", "UID": "1"}})
+	if v := entry.Value.(map[string]string); v["Token"] != "***" || v["UID"] != "1" {
+		t.Errorf("unexpected value: %v", v)
+	}
+	if key := maskCacheKey("user_1"); key != "user_1" {
+		t.Errorf("unexpected key: %s", key)
+	}
+}
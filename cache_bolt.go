@@ -2,60 +2,191 @@ package kuu
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/boltdb/bolt"
+	"sort"
+	"sync"
 	"time"
 )
 
+var (
+	// CacheBoltSweepInterval 清理过期键的间隔
+	CacheBoltSweepInterval = time.Minute
+	// CacheBoltSweepBatch 每次事务清理的最大键数
+	CacheBoltSweepBatch = 10000
+)
+
 // CacheBolt
 type CacheBolt struct {
 	db                *bolt.DB
 	generalBucketName []byte
+	expiresBucketName []byte
+	expiryIndexName   []byte
+	done              chan struct{}
+	closeOnce         sync.Once
+	broker            *memoryBroker
 }
 
 // NewCacheBolt
-func NewCacheBolt() *CacheBolt {
-	db, err := bolt.Open("cache.db", 0600, nil)
+func NewCacheBolt(path ...string) *CacheBolt {
+	file := "cache.db"
+	if len(path) > 0 && path[0] != "" {
+		file = path[0]
+	}
+	db, err := bolt.Open(file, 0600, nil)
 	if err != nil {
 		FATAL(err)
 	}
-	return &CacheBolt{db, []byte("general")}
+	c := &CacheBolt{
+		db:                db,
+		generalBucketName: []byte("general"),
+		expiresBucketName: []byte("expires"),
+		expiryIndexName:   []byte("expiry_index"),
+		done:              make(chan struct{}),
+		broker:            newMemoryBroker(),
+	}
+	if err := c.ensureExpiryIndex(); err != nil {
+		FATAL(err)
+	}
+	go c.sweepLoop()
+	return c
 }
 
-// SetString
-func (c *CacheBolt) SetString(key, val string, expiration ...time.Duration) {
+func int64tob(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	return b
+}
+
+func btoint64(b []byte) int64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (c *CacheBolt) expired(tx *bolt.Tx, key []byte, now int64) bool {
+	bucket := tx.Bucket(c.expiresBucketName)
+	if bucket == nil {
+		return false
+	}
+	deadline := btoint64(bucket.Get(key))
+	return deadline > 0 && deadline <= now
+}
+
+// 过期索引的键为过期时间（大端序）加原键名，清理时按时间顺序遍历到当前时间即可停止
+func expiryIndexKey(deadline []byte, key []byte) []byte {
+	return append(append(make([]byte, 0, len(deadline)+len(key)), deadline...), key...)
+}
+
+// 设置键的过期时间，同时维护过期索引
+func (c *CacheBolt) setExpiry(tx *bolt.Tx, key []byte, deadline int64) error {
+	expires, err := tx.CreateBucketIfNotExists(c.expiresBucketName)
+	if err != nil {
+		return err
+	}
+	index, err := tx.CreateBucketIfNotExists(c.expiryIndexName)
+	if err != nil {
+		return err
+	}
+	if old := expires.Get(key); old != nil {
+		if err := index.Delete(expiryIndexKey(old, key)); err != nil {
+			return err
+		}
+	}
+	value := int64tob(deadline)
+	if err := expires.Put(key, value); err != nil {
+		return err
+	}
+	return index.Put(expiryIndexKey(value, key), []byte{})
+}
+
+// 清除键的过期时间及其索引
+func (c *CacheBolt) clearExpiry(tx *bolt.Tx, key []byte) error {
+	expires := tx.Bucket(c.expiresBucketName)
+	if expires == nil {
+		return nil
+	}
+	old := expires.Get(key)
+	if old == nil {
+		return nil
+	}
+	if index := tx.Bucket(c.expiryIndexName); index != nil {
+		if err := index.Delete(expiryIndexKey(old, key)); err != nil {
+			return err
+		}
+	}
+	return expires.Delete(key)
+}
+
+// 写入值及过期时间，未指定过期时间时清除原有的过期时间
+func (c *CacheBolt) put(key, val []byte, expiration ...time.Duration) {
 	ERROR(c.db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(c.generalBucketName)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(key), []byte(val))
+		if err := bucket.Put(key, val); err != nil {
+			return err
+		}
+		if len(expiration) > 0 && expiration[0] > 0 {
+			return c.setExpiry(tx, key, time.Now().Add(expiration[0]).UnixNano())
+		}
+		return c.clearExpiry(tx, key)
 	}))
 }
 
-// GetString
-func (c *CacheBolt) GetString(key string) (val string) {
+func (c *CacheBolt) get(key []byte) (val []byte) {
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			val = string(bucket.Get([]byte(key)))
+		if bucket == nil || c.expired(tx, key, time.Now().UnixNano()) {
+			return nil
+		}
+		if v := bucket.Get(key); v != nil {
+			val = append([]byte(nil), v...)
 		}
 		return nil
 	}))
 	return
 }
 
-func (c *CacheBolt) seek(seek []byte, limit int, f func(k, v []byte) bool) (values map[string]string) {
+// SetString
+func (c *CacheBolt) SetString(key, val string, expiration ...time.Duration) {
+	c.put([]byte(key), []byte(val), expiration...)
+}
+
+// GetString
+func (c *CacheBolt) GetString(key string) (val string) {
+	return string(c.get([]byte(key)))
+}
+
+// 遍历未过期的键，prefix不为空时从前缀处开始并在前缀不匹配时结束
+func (c *CacheBolt) seek(prefix []byte, limit int, match func(k []byte) bool) (values map[string]string) {
 	values = make(map[string]string)
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			c := bucket.Cursor()
-			for k, v := c.Seek(seek); k != nil && f(k, v); k, v = c.Next() {
-				values[string(k)] = string(v)
-				if limit > 0 && len(values) >= limit {
-					break
-				}
+		if bucket == nil {
+			return nil
+		}
+		now := time.Now().UnixNano()
+		cursor := bucket.Cursor()
+		var k, v []byte
+		if len(prefix) > 0 {
+			k, v = cursor.Seek(prefix)
+		} else {
+			k, v = cursor.First()
+		}
+		for ; k != nil; k, v = cursor.Next() {
+			if len(prefix) > 0 && !bytes.HasPrefix(k, prefix) {
+				break
+			}
+			if !match(k) || c.expired(tx, k, now) {
+				continue
+			}
+			values[string(k)] = string(v)
+			if limit > 0 && len(values) >= limit {
+				break
 			}
 		}
 		return nil
@@ -68,9 +199,8 @@ func (c *CacheBolt) HasPrefix(prefix string, limit int) (values map[string]strin
 	if len(prefix) == 0 {
 		return
 	}
-	seek := []byte(prefix)
-	return c.seek(seek, limit, func(k, v []byte) bool {
-		return bytes.HasPrefix(k, seek)
+	return c.seek([]byte(prefix), limit, func(k []byte) bool {
+		return true
 	})
 }
 
@@ -80,7 +210,7 @@ func (c *CacheBolt) HasSuffix(suffix string, limit int) (values map[string]strin
 		return
 	}
 	seek := []byte(suffix)
-	return c.seek(seek, limit, func(k, v []byte) bool {
+	return c.seek(nil, limit, func(k []byte) bool {
 		return bytes.HasSuffix(k, seek)
 	})
 }
@@ -91,51 +221,243 @@ func (c *CacheBolt) Contains(pattern string, limit int) (values map[string]strin
 		return
 	}
 	seek := []byte(pattern)
-	return c.seek(seek, limit, func(k, v []byte) bool {
+	return c.seek(nil, limit, func(k []byte) bool {
 		return bytes.Contains(k, seek)
 	})
 }
 
 // SetInt
 func (c *CacheBolt) SetInt(key string, val int, expiration ...time.Duration) {
+	c.put([]byte(key), itob(val), expiration...)
+}
+
+// GetInt
+func (c *CacheBolt) GetInt(key string) (val int) {
+	return btoi(c.get([]byte(key)))
+}
+
+// Incr 与Redis一致，递增时保留原有的过期时间，已过期的键从0开始计数
+func (c *CacheBolt) Incr(key string) int {
+	return c.IncrBy(key, 1)
+}
+
+// IncrBy 递增指定值，键新建时设置过期时间
+func (c *CacheBolt) IncrBy(key string, n int, expiration ...time.Duration) (val int) {
+	c.update(key, func(old []byte, ok bool) ([]byte, bool, time.Duration) {
+		val = btoi(old) + n
+		var ttl time.Duration
+		if !ok && len(expiration) > 0 {
+			ttl = expiration[0]
+		}
+		return itob(val), true, ttl
+	})
+	return
+}
+
+func (c *CacheBolt) update(key string, fn func(old []byte, ok bool) ([]byte, bool, time.Duration)) {
 	ERROR(c.db.Update(func(tx *bolt.Tx) error {
 		bucket, err := tx.CreateBucketIfNotExists(c.generalBucketName)
 		if err != nil {
 			return err
 		}
-		return bucket.Put([]byte(key), itob(val))
+		k := []byte(key)
+		old := bucket.Get(k)
+		if old != nil && c.expired(tx, k, time.Now().UnixNano()) {
+			old = nil
+			if err := c.clearExpiry(tx, k); err != nil {
+				return err
+			}
+		}
+		if old == nil {
+			// 兼容旧版本按键名单独创建的计数桶
+			legacy := []byte(fmt.Sprintf("incr_%s", key))
+			if b := tx.Bucket(legacy); b != nil {
+				old = itob(int(b.Sequence()))
+				if err := tx.DeleteBucket(legacy); err != nil {
+					return err
+				}
+			}
+		} else {
+			old = append([]byte(nil), old...)
+		}
+		val, write, ttl := fn(old, old != nil)
+		if !write {
+			return nil
+		}
+		if val == nil {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+			return c.clearExpiry(tx, k)
+		}
+		if err := bucket.Put(k, val); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			return c.setExpiry(tx, k, time.Now().Add(ttl).UnixNano())
+		}
+		return nil
 	}))
 }
 
-// GetInt
-func (c *CacheBolt) GetInt(key string) (val int) {
+// Exists
+func (c *CacheBolt) Exists(key string) bool {
+	return c.get([]byte(key)) != nil
+}
+
+// TTL
+func (c *CacheBolt) TTL(key string) (ttl time.Duration) {
+	ttl = CacheTTLMissing
 	ERROR(c.db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			val = btoi(bucket.Get([]byte(key)))
+		k := []byte(key)
+		if bucket == nil || bucket.Get(k) == nil {
+			return nil
+		}
+		now := time.Now().UnixNano()
+		if c.expired(tx, k, now) {
+			return nil
+		}
+		ttl = CacheTTLPersistent
+		if expires := tx.Bucket(c.expiresBucketName); expires != nil {
+			if deadline := btoint64(expires.Get(k)); deadline > 0 {
+				ttl = time.Duration(deadline - now)
+			}
 		}
 		return nil
 	}))
 	return
 }
 
-// Incr
-func (c *CacheBolt) Incr(key string) (val int) {
-	ERROR(c.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte(fmt.Sprintf("incr_%s", key)))
-		if err != nil {
-			return err
+// SetNX
+func (c *CacheBolt) SetNX(key, val string, expiration ...time.Duration) (ok bool) {
+	c.update(key, func(old []byte, exists bool) ([]byte, bool, time.Duration) {
+		if exists {
+			return nil, false, 0
 		}
-		v, err := bucket.NextSequence()
-		if err != nil {
-			return err
+		ok = true
+		var ttl time.Duration
+		if len(expiration) > 0 {
+			ttl = expiration[0]
+		}
+		return []byte(val), true, ttl
+	})
+	return
+}
+
+// HSet
+func (c *CacheBolt) HSet(key, field, val string) {
+	cacheHSet(c, key, field, val)
+}
+
+// HGet
+func (c *CacheBolt) HGet(key, field string) string {
+	return c.HGetAll(key)[field]
+}
+
+// HGetAll
+func (c *CacheBolt) HGetAll(key string) map[string]string {
+	return cacheHashGetAll(c.get([]byte(key)), key)
+}
+
+// HDel
+func (c *CacheBolt) HDel(key string, fields ...string) {
+	cacheHDel(c, key, fields...)
+}
+
+// LPush
+func (c *CacheBolt) LPush(key string, vals ...string) int {
+	return cachePush(c, key, true, vals...)
+}
+
+// RPush
+func (c *CacheBolt) RPush(key string, vals ...string) int {
+	return cachePush(c, key, false, vals...)
+}
+
+// LPop
+func (c *CacheBolt) LPop(key string) string {
+	return cachePop(c, key, true)
+}
+
+// RPop
+func (c *CacheBolt) RPop(key string) string {
+	return cachePop(c, key, false)
+}
+
+// LRange
+func (c *CacheBolt) LRange(key string, start, stop int) []string {
+	return cacheListRange(cacheListValues(c.get([]byte(key)), key), start, stop)
+}
+
+// LLen
+func (c *CacheBolt) LLen(key string) int {
+	return len(cacheListValues(c.get([]byte(key)), key))
+}
+
+// CompareAndDel 值相等时删除
+func (c *CacheBolt) CompareAndDel(key, val string) bool {
+	return cacheCompareAndDel(c, key, val)
+}
+
+// CompareAndExpire 值相等时设置过期时间
+func (c *CacheBolt) CompareAndExpire(key, val string, expiration time.Duration) bool {
+	return cacheCompareAndExpire(c, key, val, expiration)
+}
+
+// Keys
+func (c *CacheBolt) Keys(pattern string, limit int) []string {
+	values := c.seek([]byte(globPrefix(pattern)), limit, func(k []byte) bool {
+		return GlobMatch(pattern, string(k))
+	})
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Inspect
+func (c *CacheBolt) Inspect(key string) *CacheEntry {
+	raw := c.get([]byte(key))
+	if raw == nil {
+		return nil
+	}
+	ttl := c.TTL(key)
+	if ttl == CacheTTLMissing {
+		return nil
+	}
+	return &CacheEntry{Key: key, Type: "string", TTL: cacheTTLSeconds(ttl), Value: cacheValue(raw, true)}
+}
+
+// Info Keys包含尚未清理的过期键
+func (c *CacheBolt) Info() (info CacheInfo) {
+	info.Driver = CacheDriverBolt
+	ERROR(c.db.View(func(tx *bolt.Tx) error {
+		info.Bytes = tx.Size()
+		if bucket := tx.Bucket(c.generalBucketName); bucket != nil {
+			info.Keys = int64(bucket.Stats().KeyN)
 		}
-		val = int(v)
 		return nil
 	}))
 	return
 }
 
+// Expire 设置已存在的键的过期时间
+func (c *CacheBolt) Expire(key string, expiration time.Duration) (ok bool) {
+	ERROR(c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.generalBucketName)
+		k := []byte(key)
+		if bucket == nil || bucket.Get(k) == nil || c.expired(tx, k, time.Now().UnixNano()) {
+			return nil
+		}
+		ok = true
+		return c.setExpiry(tx, k, time.Now().Add(expiration).UnixNano())
+	}))
+	return
+}
+
 // Del
 func (c *CacheBolt) Del(keys ...string) {
 	if len(keys) == 0 {
@@ -143,33 +465,135 @@ func (c *CacheBolt) Del(keys ...string) {
 	}
 	ERROR(c.db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(c.generalBucketName)
-		if bucket != nil {
-			for _, key := range keys {
+		for _, key := range keys {
+			if bucket != nil {
 				if err := bucket.Delete([]byte(key)); err != nil {
 					return err
 				}
 			}
+			if err := c.clearExpiry(tx, []byte(key)); err != nil {
+				return err
+			}
+		}
+		for _, key := range keys {
+			legacy := []byte(fmt.Sprintf("incr_%s", key))
+			if tx.Bucket(legacy) != nil {
+				if err := tx.DeleteBucket(legacy); err != nil {
+					return err
+				}
+			}
 		}
 		return nil
 	}))
 	return
 }
 
+func (c *CacheBolt) sweepLoop() {
+	ticker := time.NewTicker(CacheBoltSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 删除已过期的键，返回删除数量
+func (c *CacheBolt) sweep() (count int) {
+	for {
+		var n int
+		err := c.db.Update(func(tx *bolt.Tx) error {
+			expires := tx.Bucket(c.expiresBucketName)
+			if expires == nil {
+				return nil
+			}
+			index := tx.Bucket(c.expiryIndexName)
+			if index == nil {
+				return nil
+			}
+			general := tx.Bucket(c.generalBucketName)
+			now := time.Now().UnixNano()
+			var keys [][]byte
+			cursor := index.Cursor()
+			for k, _ := cursor.First(); k != nil && len(keys) < CacheBoltSweepBatch; k, _ = cursor.Next() {
+				if len(k) < 8 || btoint64(k[:8]) > now {
+					break
+				}
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				key := k[8:]
+				if general != nil {
+					if err := general.Delete(key); err != nil {
+						return err
+					}
+				}
+				if err := expires.Delete(key); err != nil {
+					return err
+				}
+				if err := index.Delete(k); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		if err != nil {
+			ERROR(err)
+			return
+		}
+		count += n
+		if n < CacheBoltSweepBatch {
+			return
+		}
+	}
+}
+
+// 兼容没有过期索引的旧数据文件，打开时根据expires桶重建索引
+func (c *CacheBolt) ensureExpiryIndex() error {
+	var missing bool
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		missing = tx.Bucket(c.expiresBucketName) != nil && tx.Bucket(c.expiryIndexName) == nil
+		return nil
+	}); err != nil || !missing {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		index, err := tx.CreateBucketIfNotExists(c.expiryIndexName)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(c.expiresBucketName).ForEach(func(k, v []byte) error {
+			return index.Put(expiryIndexKey(v, k), []byte{})
+		})
+	})
+}
+
 // Close
 func (c *CacheBolt) Close() {
-	if c.db != nil {
-		ERROR(c.db.Close())
-	}
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.broker.close()
+		if c.db != nil {
+			ERROR(c.db.Close())
+		}
+	})
 }
 
+// Publish 发布到进程内的订阅者
 func (c *CacheBolt) Publish(channel string, message interface{}) error {
-	return nil
+	return c.broker.publish(channel, message)
 }
 
+// Subscribe
 func (c *CacheBolt) Subscribe(channels []string, handler func(string, string)) error {
-	return nil
+	return c.broker.subscribe(channels, nil, handler)
 }
 
+// PSubscribe
 func (c *CacheBolt) PSubscribe(patterns []string, handler func(string, string)) error {
-	return nil
+	return c.broker.subscribe(nil, patterns, handler)
 }
@@ -0,0 +1,127 @@
+package kuu
+
+import (
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCacheBolt(t *testing.T) (*CacheBolt, func()) {
+	dir, err := ioutil.TempDir("", "kuu_bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCacheBolt(filepath.Join(dir, "cache.db"))
+	return c, func() {
+		c.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestCacheBoltExpiration(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+
+	c.SetString("captcha_a", "1", 20*time.Millisecond)
+	c.SetString("captcha_b", "2")
+	c.SetInt("counter", 5, 20*time.Millisecond)
+	if c.GetString("captcha_a") != "1" || c.GetInt("counter") != 5 {
+		t.Fatal("value not stored")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v := c.GetString("captcha_a"); v != "" {
+		t.Errorf("expired value returned: %s", v)
+	}
+	if v := c.GetInt("counter"); v != 0 {
+		t.Errorf("expired counter returned: %d", v)
+	}
+	if values := c.HasPrefix("captcha_", 0); len(values) != 1 || values["captcha_b"] != "2" {
+		t.Errorf("unexpected prefix values: %v", values)
+	}
+	if values := c.Contains("ptcha", 0); len(values) != 1 {
+		t.Errorf("unexpected contains values: %v", values)
+	}
+	if values := c.HasSuffix("_b", 0); len(values) != 1 {
+		t.Errorf("unexpected suffix values: %v", values)
+	}
+	if n := c.sweep(); n != 2 {
+		t.Errorf("expected 2 swept keys, got %d", n)
+	}
+	// 重新写入不带过期时间的值时清除原有的过期时间
+	c.SetString("captcha_c", "3", 20*time.Millisecond)
+	c.SetString("captcha_c", "3")
+	time.Sleep(30 * time.Millisecond)
+	if c.GetString("captcha_c") != "3" {
+		t.Error("persistent value expired")
+	}
+}
+
+func TestCacheBoltSweepIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuu_bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cache.db")
+	c := NewCacheBolt(file)
+
+	c.SetString("a", "1", 20*time.Millisecond)
+	c.SetString("b", "2", 20*time.Millisecond)
+	c.SetString("c", "3", time.Hour)
+	// 延长过期时间后不应被旧的索引项清理
+	c.Expire("b", time.Hour)
+	time.Sleep(30 * time.Millisecond)
+	if n := c.sweep(); n != 1 {
+		t.Errorf("expected 1 swept key, got %d", n)
+	}
+	if c.GetString("b") != "2" || c.GetString("c") != "3" {
+		t.Error("unexpired keys swept")
+	}
+
+	// 模拟没有过期索引的旧数据文件，重新打开时重建索引
+	c.SetString("d", "4", 20*time.Millisecond)
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(c.expiryIndexName)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c = NewCacheBolt(file)
+	defer c.Close()
+	time.Sleep(30 * time.Millisecond)
+	if n := c.sweep(); n != 1 {
+		t.Errorf("expected 1 swept key after reopening, got %d", n)
+	}
+	if c.GetString("b") != "2" || c.TTL("d") != CacheTTLMissing {
+		t.Error("unexpected values after sweep")
+	}
+}
+
+func TestCacheBoltIncr(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+
+	if v := c.Incr("sn"); v != 1 {
+		t.Fatalf("expected 1, got %d", v)
+	}
+	if !c.Expire("sn", 20*time.Millisecond) {
+		t.Fatal("expire failed")
+	}
+	if v := c.Incr("sn"); v != 2 || c.GetInt("sn") != 2 {
+		t.Fatalf("expected 2, got %d", v)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if v := c.Incr("sn"); v != 1 {
+		t.Errorf("expired counter not reset: %d", v)
+	}
+	c.Del("sn")
+	if v := c.Incr("sn"); v != 1 {
+		t.Errorf("deleted counter not reset: %d", v)
+	}
+	if c.Expire("missing", time.Second) {
+		t.Error("expire should fail for missing key")
+	}
+}
@@ -0,0 +1,497 @@
+package kuu
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// CacheMemoryMaxEntries 内存缓存默认的最大键数
+	CacheMemoryMaxEntries = 100000
+	// CacheMemorySweepInterval 清理过期键的间隔
+	CacheMemorySweepInterval = time.Minute
+	// CacheMemoryPinnedPrefixes 超出容量时不淘汰的键前缀（锁、吊销标记、登录限流等），计数器同样不淘汰
+	CacheMemoryPinnedPrefixes = []string{"lock_", "sign_revoked_", "login_", "password_reset_", "two_factor_"}
+)
+
+type memoryEntry struct {
+	key       string
+	value     string
+	expiresAt int64
+	pinned    bool
+}
+
+func (e *memoryEntry) expired(now int64) bool {
+	return e.expiresAt > 0 && e.expiresAt <= now
+}
+
+// CacheMemory 纯内存缓存，超出容量时按LRU淘汰，固定的键和计数器不淘汰（此时键数可能超出容量）
+type CacheMemory struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List
+	pinned     *list.List
+	items      map[string]*list.Element
+	broker     *memoryBroker
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewCacheMemory
+func NewCacheMemory(maxEntries ...int) *CacheMemory {
+	size := CacheMemoryMaxEntries
+	if len(maxEntries) > 0 && maxEntries[0] > 0 {
+		size = maxEntries[0]
+	}
+	c := &CacheMemory{
+		maxEntries: size,
+		ll:         list.New(),
+		pinned:     list.New(),
+		items:      make(map[string]*list.Element),
+		broker:     newMemoryBroker(),
+		done:       make(chan struct{}),
+	}
+	go c.sweepLoop()
+	return c
+}
+
+func expiresAt(expiration ...time.Duration) int64 {
+	if len(expiration) > 0 && expiration[0] > 0 {
+		return time.Now().Add(expiration[0]).UnixNano()
+	}
+	return 0
+}
+
+// 调用方需持有锁
+func (c *CacheMemory) lookup(key string, now int64) *memoryEntry {
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*memoryEntry)
+	if entry.expired(now) {
+		c.removeElement(el)
+		return nil
+	}
+	c.listOf(entry).MoveToFront(el)
+	return entry
+}
+
+func (c *CacheMemory) listOf(entry *memoryEntry) *list.List {
+	if entry.pinned {
+		return c.pinned
+	}
+	return c.ll
+}
+
+func memoryKeyPinned(key string) bool {
+	for _, prefix := range CacheMemoryPinnedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 调用方需持有锁，counter为true时表示计数器，不参与淘汰
+func (c *CacheMemory) set(key, value string, expiresAt int64, counter ...bool) {
+	pinned := (len(counter) > 0 && counter[0]) || memoryKeyPinned(key)
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*memoryEntry)
+		entry.value = value
+		entry.expiresAt = expiresAt
+		if pinned && !entry.pinned {
+			c.ll.Remove(el)
+			entry.pinned = true
+			c.items[key] = c.pinned.PushFront(entry)
+			return
+		}
+		c.listOf(entry).MoveToFront(el)
+		return
+	}
+	entry := &memoryEntry{key: key, value: value, expiresAt: expiresAt, pinned: pinned}
+	c.items[key] = c.listOf(entry).PushFront(entry)
+	for c.maxEntries > 0 && c.ll.Len() > 0 && c.ll.Len()+c.pinned.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *CacheMemory) removeElement(el *list.Element) {
+	entry := el.Value.(*memoryEntry)
+	c.listOf(entry).Remove(el)
+	delete(c.items, entry.key)
+}
+
+// 依次遍历可淘汰和固定的键
+func (c *CacheMemory) each(fn func(el *list.Element) bool) {
+	for _, l := range []*list.List{c.ll, c.pinned} {
+		for el := l.Front(); el != nil; {
+			next := el.Next()
+			if !fn(el) {
+				return
+			}
+			el = next
+		}
+	}
+}
+
+// SetString
+func (c *CacheMemory) SetString(key, val string, expiration ...time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, val, expiresAt(expiration...))
+}
+
+// GetString
+func (c *CacheMemory) GetString(key string) (val string) {
+	val, _ = c.Get(key)
+	return
+}
+
+// Get 返回值及键是否存在
+func (c *CacheMemory) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry := c.lookup(key, time.Now().UnixNano()); entry != nil {
+		return entry.value, true
+	}
+	return "", false
+}
+
+func (c *CacheMemory) scan(limit int, match func(key string) bool) (values map[string]string) {
+	values = make(map[string]string)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UnixNano()
+	c.each(func(el *list.Element) bool {
+		entry := el.Value.(*memoryEntry)
+		if entry.expired(now) || !match(entry.key) {
+			return true
+		}
+		values[entry.key] = entry.value
+		return limit <= 0 || len(values) < limit
+	})
+	return
+}
+
+// HasPrefix
+func (c *CacheMemory) HasPrefix(prefix string, limit int) (values map[string]string) {
+	if len(prefix) == 0 {
+		return
+	}
+	return c.scan(limit, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// HasSuffix
+func (c *CacheMemory) HasSuffix(suffix string, limit int) (values map[string]string) {
+	if len(suffix) == 0 {
+		return
+	}
+	return c.scan(limit, func(key string) bool {
+		return strings.HasSuffix(key, suffix)
+	})
+}
+
+// Contains
+func (c *CacheMemory) Contains(pattern string, limit int) (values map[string]string) {
+	if len(pattern) == 0 {
+		return
+	}
+	return c.scan(limit, func(key string) bool {
+		return strings.Contains(key, pattern)
+	})
+}
+
+// SetInt
+func (c *CacheMemory) SetInt(key string, val int, expiration ...time.Duration) {
+	c.SetString(key, strconv.Itoa(val), expiration...)
+}
+
+// GetInt
+func (c *CacheMemory) GetInt(key string) (val int) {
+	if v, err := strconv.Atoi(c.GetString(key)); err == nil {
+		val = v
+	}
+	return
+}
+
+// Incr 递增时保留原有的过期时间
+func (c *CacheMemory) Incr(key string) int {
+	return c.IncrBy(key, 1)
+}
+
+// IncrBy 递增指定值，键新建时设置过期时间
+func (c *CacheMemory) IncrBy(key string, n int, expiration ...time.Duration) (val int) {
+	c.updateValue(key, true, func(old []byte, ok bool) ([]byte, bool, time.Duration) {
+		v, _ := strconv.Atoi(string(old))
+		val = v + n
+		var ttl time.Duration
+		if !ok && len(expiration) > 0 {
+			ttl = expiration[0]
+		}
+		return []byte(strconv.Itoa(val)), true, ttl
+	})
+	return
+}
+
+func (c *CacheMemory) update(key string, fn func(old []byte, ok bool) ([]byte, bool, time.Duration)) {
+	c.updateValue(key, false, fn)
+}
+
+func (c *CacheMemory) updateValue(key string, counter bool, fn func(old []byte, ok bool) ([]byte, bool, time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var (
+		old []byte
+		ts  int64
+	)
+	entry := c.lookup(key, time.Now().UnixNano())
+	if entry != nil {
+		old = []byte(entry.value)
+		ts = entry.expiresAt
+	}
+	val, write, ttl := fn(old, entry != nil)
+	if !write {
+		return
+	}
+	if val == nil {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+		return
+	}
+	if ttl > 0 {
+		ts = expiresAt(ttl)
+	}
+	c.set(key, string(val), ts, counter)
+}
+
+// Exists
+func (c *CacheMemory) Exists(key string) (ok bool) {
+	_, ok = c.Get(key)
+	return
+}
+
+// TTL
+func (c *CacheMemory) TTL(key string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UnixNano()
+	entry := c.lookup(key, now)
+	if entry == nil {
+		return CacheTTLMissing
+	}
+	if entry.expiresAt == 0 {
+		return CacheTTLPersistent
+	}
+	return time.Duration(entry.expiresAt - now)
+}
+
+// SetNX
+func (c *CacheMemory) SetNX(key, val string, expiration ...time.Duration) (ok bool) {
+	c.update(key, func(old []byte, exists bool) ([]byte, bool, time.Duration) {
+		if exists {
+			return nil, false, 0
+		}
+		ok = true
+		var ttl time.Duration
+		if len(expiration) > 0 {
+			ttl = expiration[0]
+		}
+		return []byte(val), true, ttl
+	})
+	return
+}
+
+// HSet
+func (c *CacheMemory) HSet(key, field, val string) {
+	cacheHSet(c, key, field, val)
+}
+
+// HGet
+func (c *CacheMemory) HGet(key, field string) string {
+	return c.HGetAll(key)[field]
+}
+
+// HGetAll
+func (c *CacheMemory) HGetAll(key string) map[string]string {
+	return cacheHashGetAll([]byte(c.GetString(key)), key)
+}
+
+// HDel
+func (c *CacheMemory) HDel(key string, fields ...string) {
+	cacheHDel(c, key, fields...)
+}
+
+// LPush
+func (c *CacheMemory) LPush(key string, vals ...string) int {
+	return cachePush(c, key, true, vals...)
+}
+
+// RPush
+func (c *CacheMemory) RPush(key string, vals ...string) int {
+	return cachePush(c, key, false, vals...)
+}
+
+// LPop
+func (c *CacheMemory) LPop(key string) string {
+	return cachePop(c, key, true)
+}
+
+// RPop
+func (c *CacheMemory) RPop(key string) string {
+	return cachePop(c, key, false)
+}
+
+// LRange
+func (c *CacheMemory) LRange(key string, start, stop int) []string {
+	return cacheListRange(cacheListValues([]byte(c.GetString(key)), key), start, stop)
+}
+
+// LLen
+func (c *CacheMemory) LLen(key string) int {
+	return len(cacheListValues([]byte(c.GetString(key)), key))
+}
+
+// CompareAndDel 值相等时删除
+func (c *CacheMemory) CompareAndDel(key, val string) bool {
+	return cacheCompareAndDel(c, key, val)
+}
+
+// CompareAndExpire 值相等时设置过期时间
+func (c *CacheMemory) CompareAndExpire(key, val string, expiration time.Duration) bool {
+	return cacheCompareAndExpire(c, key, val, expiration)
+}
+
+// Expire 设置已存在的键的过期时间
+func (c *CacheMemory) Expire(key string, expiration time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := c.lookup(key, time.Now().UnixNano())
+	if entry == nil {
+		return false
+	}
+	entry.expiresAt = expiresAt(expiration)
+	return true
+}
+
+// Del
+func (c *CacheMemory) Del(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// Keys
+func (c *CacheMemory) Keys(pattern string, limit int) []string {
+	values := c.scan(limit, func(key string) bool {
+		return GlobMatch(pattern, key)
+	})
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Inspect 查看时不改变LRU顺序
+func (c *CacheMemory) Inspect(key string) *CacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	entry := el.Value.(*memoryEntry)
+	if entry.expired(now) {
+		return nil
+	}
+	ttl := CacheTTLPersistent
+	if entry.expiresAt > 0 {
+		ttl = time.Duration(entry.expiresAt - now)
+	}
+	return &CacheEntry{Key: key, Type: "string", TTL: cacheTTLSeconds(ttl), Value: entry.value}
+}
+
+// Info Keys包含尚未清理的过期键
+func (c *CacheMemory) Info() CacheInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := CacheInfo{Driver: CacheDriverMemory, Keys: int64(len(c.items))}
+	c.each(func(el *list.Element) bool {
+		entry := el.Value.(*memoryEntry)
+		info.Bytes += int64(len(entry.key) + len(entry.value))
+		return true
+	})
+	return info
+}
+
+// Len 返回当前的键数（包含尚未清理的过期键）
+func (c *CacheMemory) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c *CacheMemory) sweepLoop() {
+	ticker := time.NewTicker(CacheMemorySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.sweep()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// 删除已过期的键，返回删除数量
+func (c *CacheMemory) sweep() (count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UnixNano()
+	c.each(func(el *list.Element) bool {
+		if el.Value.(*memoryEntry).expired(now) {
+			c.removeElement(el)
+			count++
+		}
+		return true
+	})
+	return
+}
+
+// Close
+func (c *CacheMemory) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.broker.close()
+	})
+}
+
+// Publish 发布到进程内的订阅者
+func (c *CacheMemory) Publish(channel string, message interface{}) error {
+	return c.broker.publish(channel, message)
+}
+
+// Subscribe
+func (c *CacheMemory) Subscribe(channels []string, handler func(string, string)) error {
+	return c.broker.subscribe(channels, nil, handler)
+}
+
+// PSubscribe
+func (c *CacheMemory) PSubscribe(patterns []string, handler func(string, string)) error {
+	return c.broker.subscribe(nil, patterns, handler)
+}
@@ -0,0 +1,108 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+// 临时替换DefaultCache，返回恢复函数
+func useTestCacheMemory() func() {
+	prev := DefaultCache
+	DefaultCache = NewCacheMemory()
+	return func() {
+		DefaultCache.Close()
+		DefaultCache = prev
+	}
+}
+
+func TestCacheMemoryLRU(t *testing.T) {
+	c := NewCacheMemory(3)
+	defer c.Close()
+
+	c.SetString("a", "1")
+	c.SetString("b", "2")
+	c.SetString("c", "3")
+	// 访问a后，b成为最久未使用的键
+	if c.GetString("a") != "1" {
+		t.Fatal("value not stored")
+	}
+	c.SetString("d", "4")
+	if c.GetString("b") != "" {
+		t.Error("least recently used key not evicted")
+	}
+	if c.Len() != 3 || c.GetString("a") != "1" || c.GetString("d") != "4" {
+		t.Errorf("unexpected entries: %d", c.Len())
+	}
+}
+
+func TestCacheMemoryPinned(t *testing.T) {
+	c := NewCacheMemory(3)
+	defer c.Close()
+
+	// 计数器和固定前缀的键不淘汰
+	c.IncrBy("sn_20260101", 1, time.Hour)
+	c.SetString("lock_job", "1", time.Hour)
+	c.SetString("a", "1")
+	c.SetString("b", "2")
+	c.SetString("c", "3")
+	if c.GetString("a") != "" || c.GetString("b") != "" || c.GetString("c") != "3" {
+		t.Error("expected plain keys to be evicted first")
+	}
+	if c.GetInt("sn_20260101") != 1 || c.GetString("lock_job") != "1" || c.Len() != 3 {
+		t.Errorf("pinned keys evicted: %d", c.Len())
+	}
+	c.SetString("lock_import", "1", time.Hour)
+	c.IncrBy("rate_limit_1", 1, time.Hour)
+	if c.GetString("c") != "" || c.Len() != 4 {
+		t.Errorf("expected the cache to exceed its capacity with pinned keys only: %d", c.Len())
+	}
+	if values := c.HasPrefix("lock_", 0); len(values) != 2 {
+		t.Errorf("unexpected prefix values: %v", values)
+	}
+	// 固定的键同样按过期时间清理
+	c.SetString("lock_tmp", "1", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if n := c.sweep(); n != 1 || c.Len() != 4 {
+		t.Errorf("unexpected sweep result: %d %d", n, c.Len())
+	}
+}
+
+func TestCacheMemoryExpiration(t *testing.T) {
+	c := NewCacheMemory()
+	defer c.Close()
+
+	c.SetString("captcha_a", "1", 20*time.Millisecond)
+	c.SetString("captcha_b", "2")
+	c.SetInt("counter", 5)
+	if c.Incr("counter") != 6 || c.GetInt("counter") != 6 {
+		t.Error("incr failed")
+	}
+	if !c.Expire("counter", 20*time.Millisecond) {
+		t.Error("expire failed")
+	}
+	if c.Incr("counter") != 7 {
+		t.Error("incr failed")
+	}
+	if values := c.Contains("ptcha", 0); len(values) != 2 {
+		t.Errorf("unexpected contains values: %v", values)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if c.GetString("captcha_a") != "" || c.GetInt("counter") != 0 {
+		t.Error("expired value returned")
+	}
+	if values := c.HasPrefix("captcha_", 0); len(values) != 1 || values["captcha_b"] != "2" {
+		t.Errorf("unexpected prefix values: %v", values)
+	}
+	if values := c.HasSuffix("_a", 0); len(values) != 0 {
+		t.Errorf("unexpected suffix values: %v", values)
+	}
+	c.SetString("session", "x", 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if n := c.sweep(); n != 1 {
+		t.Errorf("expected 1 swept key, got %d", n)
+	}
+	c.Del("captcha_b")
+	if c.Len() != 0 {
+		t.Errorf("unexpected entries: %d", c.Len())
+	}
+}
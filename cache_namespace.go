@@ -0,0 +1,95 @@
+package kuu
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CacheNamespace 按模块隔离的缓存键空间，实际键名为"<命名空间>:<键>"，适用于所有缓存驱动
+type CacheNamespace struct {
+	Name string
+}
+
+// NewCacheNamespace
+func NewCacheNamespace(name string) *CacheNamespace {
+	return &CacheNamespace{Name: strings.ToLower(name)}
+}
+
+// Cache 返回以模块编码命名的缓存空间
+func (m *Mod) Cache() *CacheNamespace {
+	return NewCacheNamespace(m.Code)
+}
+
+// Key 返回实际的键名
+func (ns *CacheNamespace) Key(key string) string {
+	return fmt.Sprintf("%s:%s", ns.Name, key)
+}
+
+func (ns *CacheNamespace) keys(keys []string) []string {
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = ns.Key(key)
+	}
+	return values
+}
+
+// SetString
+func (ns *CacheNamespace) SetString(key, val string, expiration ...time.Duration) {
+	SetCacheString(ns.Key(key), val, expiration...)
+}
+
+// GetString
+func (ns *CacheNamespace) GetString(key string) string {
+	return GetCacheString(ns.Key(key))
+}
+
+// SetInt
+func (ns *CacheNamespace) SetInt(key string, val int, expiration ...time.Duration) {
+	SetCacheInt(ns.Key(key), val, expiration...)
+}
+
+// GetInt
+func (ns *CacheNamespace) GetInt(key string) int {
+	return GetCacheInt(ns.Key(key))
+}
+
+// Incr
+func (ns *CacheNamespace) Incr(key string) int {
+	return IncrCache(ns.Key(key))
+}
+
+// SetJSON
+func (ns *CacheNamespace) SetJSON(key string, val interface{}, expiration ...time.Duration) error {
+	return SetCacheJSON(ns.Key(key), val, expiration...)
+}
+
+// GetJSON
+func (ns *CacheNamespace) GetJSON(key string, dst interface{}) (bool, error) {
+	return GetCacheJSON(ns.Key(key), dst)
+}
+
+// GetOrLoad
+func (ns *CacheNamespace) GetOrLoad(key string, ttl time.Duration, dst interface{}, loader func() (interface{}, error)) error {
+	return GetOrLoadCache(ns.Key(key), ttl, dst, loader)
+}
+
+// Del
+func (ns *CacheNamespace) Del(keys ...string) {
+	DelCache(ns.keys(keys)...)
+}
+
+// Keys 按通配符列出命名空间内的键，返回的键不含命名空间前缀
+func (ns *CacheNamespace) Keys(pattern string, limit int) []string {
+	prefix := ns.Key("")
+	keys := KeysCache(ns.Key(pattern), limit)
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, prefix)
+	}
+	return keys
+}
+
+// Flush 删除命名空间内的所有键，返回删除数量
+func (ns *CacheNamespace) Flush() int {
+	return DelCacheByPattern(ns.Key("*"))
+}
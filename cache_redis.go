@@ -180,6 +180,313 @@ func (c *CacheRedis) Del(keys ...string) {
 	}
 }
 
+// Expire
+func (c *CacheRedis) Expire(key string, expiration time.Duration) bool {
+	cmd := c.client.Expire(context.Background(), BuildKey(key), expiration)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return false
+	}
+	return cmd.Val()
+}
+
+// 键新建时设置过期时间，与Bolt和内存缓存保持一致
+var redisIncrByScript = redis.NewScript(`
+local created = redis.call("EXISTS", KEYS[1]) == 0
+local val = redis.call("INCRBY", KEYS[1], ARGV[1])
+if created and tonumber(ARGV[2]) > 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return val
+`)
+
+var redisCompareAndDelScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+var redisCompareAndExpireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Exists
+func (c *CacheRedis) Exists(key string) bool {
+	cmd := c.client.Exists(context.Background(), BuildKey(key))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return false
+	}
+	return cmd.Val() > 0
+}
+
+// TTL
+func (c *CacheRedis) TTL(key string) time.Duration {
+	cmd := c.client.PTTL(context.Background(), BuildKey(key))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return CacheTTLMissing
+	}
+	return cmd.Val()
+}
+
+// SetNX
+func (c *CacheRedis) SetNX(rawKey, val string, expiration ...time.Duration) bool {
+	key, exp := c.buildKeyAndExp(rawKey, expiration)
+	cmd := c.client.SetNX(context.Background(), key, val, exp)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return false
+	}
+	return cmd.Val()
+}
+
+// IncrBy
+func (c *CacheRedis) IncrBy(rawKey string, n int, expiration ...time.Duration) (val int) {
+	key, exp := c.buildKeyAndExp(rawKey, expiration)
+	cmd := redisIncrByScript.Run(context.Background(), c.client, []string{key}, n, int64(exp/time.Millisecond))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return
+	}
+	if v, ok := cmd.Val().(int64); ok {
+		val = int(v)
+	}
+	return
+}
+
+// HSet
+func (c *CacheRedis) HSet(key, field, val string) {
+	if err := c.client.HSet(context.Background(), BuildKey(key), field, val).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+// HGet
+func (c *CacheRedis) HGet(key, field string) string {
+	cmd := c.client.HGet(context.Background(), BuildKey(key), field)
+	if err := cmd.Err(); err != nil {
+		if err != redis.Nil {
+			ERROR(err)
+		}
+		return ""
+	}
+	return cmd.Val()
+}
+
+// HGetAll
+func (c *CacheRedis) HGetAll(key string) map[string]string {
+	cmd := c.client.HGetAll(context.Background(), BuildKey(key))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return make(map[string]string)
+	}
+	return cmd.Val()
+}
+
+// HDel
+func (c *CacheRedis) HDel(key string, fields ...string) {
+	if len(fields) == 0 {
+		return
+	}
+	if err := c.client.HDel(context.Background(), BuildKey(key), fields...).Err(); err != nil {
+		ERROR(err)
+	}
+}
+
+func redisValues(vals []string) []interface{} {
+	values := make([]interface{}, len(vals))
+	for i, v := range vals {
+		values[i] = v
+	}
+	return values
+}
+
+// LPush
+func (c *CacheRedis) LPush(key string, vals ...string) int {
+	if len(vals) == 0 {
+		return c.LLen(key)
+	}
+	cmd := c.client.LPush(context.Background(), BuildKey(key), redisValues(vals)...)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return 0
+	}
+	return int(cmd.Val())
+}
+
+// RPush
+func (c *CacheRedis) RPush(key string, vals ...string) int {
+	if len(vals) == 0 {
+		return c.LLen(key)
+	}
+	cmd := c.client.RPush(context.Background(), BuildKey(key), redisValues(vals)...)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return 0
+	}
+	return int(cmd.Val())
+}
+
+func (c *CacheRedis) pop(cmd *redis.StringCmd) string {
+	if err := cmd.Err(); err != nil {
+		if err != redis.Nil {
+			ERROR(err)
+		}
+		return ""
+	}
+	return cmd.Val()
+}
+
+// LPop
+func (c *CacheRedis) LPop(key string) string {
+	return c.pop(c.client.LPop(context.Background(), BuildKey(key)))
+}
+
+// RPop
+func (c *CacheRedis) RPop(key string) string {
+	return c.pop(c.client.RPop(context.Background(), BuildKey(key)))
+}
+
+// LRange
+func (c *CacheRedis) LRange(key string, start, stop int) []string {
+	cmd := c.client.LRange(context.Background(), BuildKey(key), int64(start), int64(stop))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return []string{}
+	}
+	return cmd.Val()
+}
+
+// LLen
+func (c *CacheRedis) LLen(key string) int {
+	cmd := c.client.LLen(context.Background(), BuildKey(key))
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return 0
+	}
+	return int(cmd.Val())
+}
+
+func (c *CacheRedis) runBoolScript(script *redis.Script, key string, args ...interface{}) bool {
+	cmd := script.Run(context.Background(), c.client, []string{BuildKey(key)}, args...)
+	if err := cmd.Err(); err != nil {
+		ERROR(err)
+		return false
+	}
+	v, ok := cmd.Val().(int64)
+	return ok && v > 0
+}
+
+// CompareAndDel 值相等时删除
+func (c *CacheRedis) CompareAndDel(key, val string) bool {
+	return c.runBoolScript(redisCompareAndDelScript, key, val)
+}
+
+// CompareAndExpire 值相等时设置过期时间
+func (c *CacheRedis) CompareAndExpire(key, val string, expiration time.Duration) bool {
+	if expiration <= 0 {
+		return false
+	}
+	return c.runBoolScript(redisCompareAndExpireScript, key, val, int64(expiration/time.Millisecond))
+}
+
+// CacheRedisInspectLimit 查看列表、集合等类型时返回的最大元素数
+var CacheRedisInspectLimit int64 = 100
+
+// Keys 返回的键不含应用名前缀
+func (c *CacheRedis) Keys(pattern string, limit int) (keys []string) {
+	var (
+		cursor uint64
+		prefix = BuildKey("")
+		seen   = make(map[string]bool)
+	)
+	for {
+		cmd := c.client.Scan(context.Background(), cursor, BuildKey(pattern), 1000)
+		if err := cmd.Err(); err != nil {
+			ERROR(err)
+			return
+		}
+		values, next := cmd.Val()
+		for _, key := range values {
+			key = strings.TrimPrefix(key, prefix)
+			// SCAN可能返回重复的键
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, key)
+			if limit > 0 && len(keys) >= limit {
+				return
+			}
+		}
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+// Inspect
+func (c *CacheRedis) Inspect(rawKey string) *CacheEntry {
+	var (
+		ctx = context.Background()
+		key = BuildKey(rawKey)
+	)
+	typ, err := c.client.Type(ctx, key).Result()
+	if err != nil {
+		ERROR(err)
+		return nil
+	}
+	if typ == "none" {
+		return nil
+	}
+	entry := &CacheEntry{Key: rawKey, Type: typ, TTL: cacheTTLSeconds(c.TTL(rawKey))}
+	switch typ {
+	case "string":
+		entry.Value, err = c.client.Get(ctx, key).Result()
+	case "hash":
+		entry.Value, err = c.client.HGetAll(ctx, key).Result()
+	case "list":
+		entry.Value, err = c.client.LRange(ctx, key, 0, CacheRedisInspectLimit-1).Result()
+	case "set":
+		entry.Value, err = c.client.SRandMemberN(ctx, key, CacheRedisInspectLimit).Result()
+	case "zset":
+		entry.Value, err = c.client.ZRangeWithScores(ctx, key, 0, CacheRedisInspectLimit-1).Result()
+	}
+	if err != nil && err != redis.Nil {
+		ERROR(err)
+	}
+	return entry
+}
+
+// Info Keys为当前数据库的键数（包含其他应用的键）
+func (c *CacheRedis) Info() (info CacheInfo) {
+	info.Driver = CacheDriverRedis
+	ctx := context.Background()
+	if n, err := c.client.DBSize(ctx).Result(); err != nil {
+		ERROR(err)
+	} else {
+		info.Keys = n
+	}
+	raw, err := c.client.Info(ctx, "memory").Result()
+	if err != nil {
+		ERROR(err)
+		return
+	}
+	for _, line := range strings.Split(raw, "\n") {
+		if strings.HasPrefix(line, "used_memory:") {
+			info.Bytes, _ = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(line, "used_memory:")), 10, 64)
+			break
+		}
+	}
+	return
+}
+
 // Close
 func (c *CacheRedis) Close() {
 	if c.client != nil {
@@ -0,0 +1,419 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// CacheTTLMissing 键不存在时TTL的返回值（与Redis一致）
+	CacheTTLMissing = time.Duration(-2)
+	// CacheTTLPersistent 键未设置过期时间时TTL的返回值
+	CacheTTLPersistent = time.Duration(-1)
+)
+
+// ErrCacheUnsupported 当前缓存不支持结构化操作
+var ErrCacheUnsupported = errors.New("cache does not support structured operations")
+
+// StructuredCache 支持TTL查询、原子写入、哈希和列表操作的缓存
+type StructuredCache interface {
+	Cache
+	Exists(string) bool
+	TTL(string) time.Duration
+	SetNX(string, string, ...time.Duration) bool
+	IncrBy(string, int, ...time.Duration) int
+	HSet(key, field, val string)
+	HGet(key, field string) string
+	HGetAll(key string) map[string]string
+	HDel(key string, fields ...string)
+	LPush(key string, vals ...string) int
+	RPush(key string, vals ...string) int
+	LPop(key string) string
+	RPop(key string) string
+	LRange(key string, start, stop int) []string
+	LLen(key string) int
+}
+
+func structuredCache() StructuredCache {
+	if v, ok := DefaultCache.(StructuredCache); ok {
+		return v
+	}
+	ERROR(ErrCacheUnsupported)
+	return nil
+}
+
+// ExistsCache
+func ExistsCache(key string) bool {
+	if c := structuredCache(); c != nil {
+		return c.Exists(key)
+	}
+	return false
+}
+
+// TTLCache 返回剩余过期时间，键不存在时返回CacheTTLMissing，未设置过期时间时返回CacheTTLPersistent
+func TTLCache(key string) time.Duration {
+	if c := structuredCache(); c != nil {
+		return c.TTL(key)
+	}
+	return CacheTTLMissing
+}
+
+// SetNXCache 键不存在时写入，返回是否写入成功
+func SetNXCache(key, val string, expiration ...time.Duration) bool {
+	if c := structuredCache(); c != nil {
+		return c.SetNX(key, val, expiration...)
+	}
+	return false
+}
+
+// IncrByCache 递增指定值，键新建时设置过期时间，已存在的键保留原有过期时间
+func IncrByCache(key string, n int, expiration ...time.Duration) int {
+	if c := structuredCache(); c != nil {
+		return c.IncrBy(key, n, expiration...)
+	}
+	return 0
+}
+
+// HSetCache
+func HSetCache(key, field, val string) {
+	if c := structuredCache(); c != nil {
+		c.HSet(key, field, val)
+	}
+}
+
+// HGetCache
+func HGetCache(key, field string) string {
+	if c := structuredCache(); c != nil {
+		return c.HGet(key, field)
+	}
+	return ""
+}
+
+// HGetAllCache
+func HGetAllCache(key string) map[string]string {
+	if c := structuredCache(); c != nil {
+		return c.HGetAll(key)
+	}
+	return nil
+}
+
+// HDelCache
+func HDelCache(key string, fields ...string) {
+	if c := structuredCache(); c != nil {
+		c.HDel(key, fields...)
+	}
+}
+
+// LPushCache
+func LPushCache(key string, vals ...string) int {
+	if c := structuredCache(); c != nil {
+		return c.LPush(key, vals...)
+	}
+	return 0
+}
+
+// RPushCache
+func RPushCache(key string, vals ...string) int {
+	if c := structuredCache(); c != nil {
+		return c.RPush(key, vals...)
+	}
+	return 0
+}
+
+// LPopCache
+func LPopCache(key string) string {
+	if c := structuredCache(); c != nil {
+		return c.LPop(key)
+	}
+	return ""
+}
+
+// RPopCache
+func RPopCache(key string) string {
+	if c := structuredCache(); c != nil {
+		return c.RPop(key)
+	}
+	return ""
+}
+
+// LRangeCache
+func LRangeCache(key string, start, stop int) []string {
+	if c := structuredCache(); c != nil {
+		return c.LRange(key, start, stop)
+	}
+	return nil
+}
+
+// LLenCache
+func LLenCache(key string) int {
+	if c := structuredCache(); c != nil {
+		return c.LLen(key)
+	}
+	return 0
+}
+
+// SetCacheJSON 序列化为JSON后写入
+func SetCacheJSON(key string, val interface{}, expiration ...time.Duration) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	SetCacheString(key, string(data), expiration...)
+	return nil
+}
+
+// GetCacheJSON 读取并反序列化，返回键是否存在
+func GetCacheJSON(key string, dst interface{}) (bool, error) {
+	raw := GetCacheString(key)
+	if raw == "" {
+		return false, nil
+	}
+	return true, JSONParse(raw, dst)
+}
+
+type cacheLoadCall struct {
+	wg  sync.WaitGroup
+	val string
+	err error
+}
+
+var (
+	cacheLoadsMu sync.Mutex
+	cacheLoads   = make(map[string]*cacheLoadCall)
+)
+
+// 同一进程内相同键的加载只执行一次，其他调用等待并共享结果；fn发生panic时等待者收到错误，调用者在释放等待者后重新panic
+func loadCacheOnce(key string, fn func() (string, error)) (val string, err error) {
+	cacheLoadsMu.Lock()
+	if call, ok := cacheLoads[key]; ok {
+		cacheLoadsMu.Unlock()
+		call.wg.Wait()
+		return call.val, call.err
+	}
+	call := new(cacheLoadCall)
+	call.wg.Add(1)
+	cacheLoads[key] = call
+	cacheLoadsMu.Unlock()
+
+	defer func() {
+		r := recover()
+		if r != nil {
+			call.err = fmt.Errorf("cache loader panic: %v", r)
+		}
+		call.wg.Done()
+		cacheLoadsMu.Lock()
+		delete(cacheLoads, key)
+		cacheLoadsMu.Unlock()
+		if r != nil {
+			panic(r)
+		}
+		val, err = call.val, call.err
+	}()
+	call.val, call.err = fn()
+	return
+}
+
+// GetOrLoadCache 读取缓存到dst，未命中时调用loader加载并写入缓存，并发加载同一键时只执行一次loader
+func GetOrLoadCache(key string, ttl time.Duration, dst interface{}, loader func() (interface{}, error)) error {
+	if ok, err := GetCacheJSON(key, dst); ok && err == nil {
+		return nil
+	}
+	raw, err := loadCacheOnce(key, func() (string, error) {
+		// 等待期间可能已被其他调用写入
+		if raw := GetCacheString(key); raw != "" {
+			return raw, nil
+		}
+		val, err := loader()
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(val)
+		if err != nil {
+			return "", err
+		}
+		SetCacheString(key, string(data), ttl)
+		return string(data), nil
+	})
+	if err != nil {
+		return err
+	}
+	return JSONParse(raw, dst)
+}
+
+// 以下为Bolt和内存缓存共用的哈希和列表实现，值以JSON保存
+
+// 原子读写：fn返回write为false时不修改；val为nil时删除键；ttl大于0时设置过期时间，否则保留原有过期时间
+type cacheUpdater interface {
+	update(key string, fn func(old []byte, ok bool) (val []byte, write bool, ttl time.Duration))
+}
+
+type cacheHashField struct {
+	K string
+	V string
+}
+
+func decodeCacheHash(raw []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	if len(raw) == 0 {
+		return values, nil
+	}
+	var fields []cacheHashField
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for _, item := range fields {
+		values[item.K] = item.V
+	}
+	return values, nil
+}
+
+func encodeCacheHash(values map[string]string) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	fields := make([]cacheHashField, 0, len(values))
+	for k, v := range values {
+		fields = append(fields, cacheHashField{k, v})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].K < fields[j].K
+	})
+	return json.Marshal(fields)
+}
+
+func decodeCacheList(raw []byte) ([]string, error) {
+	var list []string
+	if len(raw) == 0 {
+		return list, nil
+	}
+	err := json.Unmarshal(raw, &list)
+	return list, err
+}
+
+func encodeCacheList(list []string) ([]byte, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(list)
+}
+
+func updateCacheHash(u cacheUpdater, key string, fn func(values map[string]string)) {
+	u.update(key, func(old []byte, ok bool) ([]byte, bool, time.Duration) {
+		values, err := decodeCacheHash(old)
+		if err != nil {
+			ERROR("cache key %s is not a hash: %v", key, err)
+			return nil, false, 0
+		}
+		fn(values)
+		val, err := encodeCacheHash(values)
+		if err != nil {
+			ERROR(err)
+			return nil, false, 0
+		}
+		return val, ok || val != nil, 0
+	})
+}
+
+func updateCacheList(u cacheUpdater, key string, fn func(list []string) []string) (length int) {
+	u.update(key, func(old []byte, ok bool) ([]byte, bool, time.Duration) {
+		list, err := decodeCacheList(old)
+		if err != nil {
+			ERROR("cache key %s is not a list: %v", key, err)
+			return nil, false, 0
+		}
+		list = fn(list)
+		length = len(list)
+		val, err := encodeCacheList(list)
+		if err != nil {
+			ERROR(err)
+			return nil, false, 0
+		}
+		return val, ok || val != nil, 0
+	})
+	return
+}
+
+func cacheHashGetAll(raw []byte, key string) map[string]string {
+	values, err := decodeCacheHash(raw)
+	if err != nil {
+		ERROR("cache key %s is not a hash: %v", key, err)
+		return make(map[string]string)
+	}
+	return values
+}
+
+func cacheListValues(raw []byte, key string) []string {
+	list, err := decodeCacheList(raw)
+	if err != nil {
+		ERROR("cache key %s is not a list: %v", key, err)
+		return nil
+	}
+	return list
+}
+
+func cacheHSet(u cacheUpdater, key, field, val string) {
+	updateCacheHash(u, key, func(values map[string]string) {
+		values[field] = val
+	})
+}
+
+func cacheHDel(u cacheUpdater, key string, fields ...string) {
+	updateCacheHash(u, key, func(values map[string]string) {
+		for _, field := range fields {
+			delete(values, field)
+		}
+	})
+}
+
+// 与Redis一致，LPush按参数顺序依次插入到表头
+func cachePush(u cacheUpdater, key string, left bool, vals ...string) int {
+	return updateCacheList(u, key, func(list []string) []string {
+		if !left {
+			return append(list, vals...)
+		}
+		head := make([]string, 0, len(vals)+len(list))
+		for i := len(vals) - 1; i >= 0; i-- {
+			head = append(head, vals[i])
+		}
+		return append(head, list...)
+	})
+}
+
+func cachePop(u cacheUpdater, key string, left bool) (val string) {
+	updateCacheList(u, key, func(list []string) []string {
+		if len(list) == 0 {
+			return list
+		}
+		if left {
+			val = list[0]
+			return list[1:]
+		}
+		val = list[len(list)-1]
+		return list[:len(list)-1]
+	})
+	return
+}
+
+// 按Redis LRANGE的规则截取，支持负数下标
+func cacheListRange(list []string, start, stop int) []string {
+	n := len(list)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return []string{}
+	}
+	return append([]string(nil), list[start:stop+1]...)
+}
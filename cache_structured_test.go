@@ -0,0 +1,166 @@
+package kuu
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testStructuredCache(t *testing.T, c StructuredCache) {
+	if c.Exists("sc_a") || c.TTL("sc_a") != CacheTTLMissing {
+		t.Fatal("unexpected missing key state")
+	}
+	if !c.SetNX("sc_a", "1", time.Minute) || c.SetNX("sc_a", "2") {
+		t.Fatal("unexpected SetNX result")
+	}
+	if ttl := c.TTL("sc_a"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("unexpected ttl: %v", ttl)
+	}
+	c.SetString("sc_b", "x")
+	if !c.Exists("sc_b") || c.TTL("sc_b") != CacheTTLPersistent {
+		t.Error("unexpected persistent key state")
+	}
+
+	if v := c.IncrBy("sc_n", 5, time.Minute); v != 5 {
+		t.Errorf("expected 5, got %d", v)
+	}
+	if v := c.IncrBy("sc_n", -2); v != 3 || c.GetInt("sc_n") != 3 {
+		t.Errorf("expected 3, got %d", v)
+	}
+	if ttl := c.TTL("sc_n"); ttl <= 0 {
+		t.Errorf("expiration lost after IncrBy: %v", ttl)
+	}
+
+	c.HSet("sc_h", "f1", "v1")
+	c.HSet("sc_h", "f2", "v2")
+	if c.HGet("sc_h", "f1") != "v1" || !reflect.DeepEqual(c.HGetAll("sc_h"), map[string]string{"f1": "v1", "f2": "v2"}) {
+		t.Errorf("unexpected hash: %v", c.HGetAll("sc_h"))
+	}
+	c.HDel("sc_h", "f1", "f2")
+	if c.Exists("sc_h") {
+		t.Error("empty hash should be removed")
+	}
+
+	if n := c.RPush("sc_l", "b", "c"); n != 2 {
+		t.Errorf("expected length 2, got %d", n)
+	}
+	if n := c.LPush("sc_l", "x", "a"); n != 4 {
+		t.Errorf("expected length 4, got %d", n)
+	}
+	if v := c.LRange("sc_l", 0, -1); !reflect.DeepEqual(v, []string{"a", "x", "b", "c"}) {
+		t.Errorf("unexpected list: %v", v)
+	}
+	if c.LPop("sc_l") != "a" || c.RPop("sc_l") != "c" || c.LLen("sc_l") != 2 {
+		t.Error("unexpected pop result")
+	}
+	c.LPop("sc_l")
+	c.LPop("sc_l")
+	if c.LPop("sc_l") != "" || c.Exists("sc_l") {
+		t.Error("empty list should be removed")
+	}
+}
+
+func TestCacheMemoryStructured(t *testing.T) {
+	c := NewCacheMemory()
+	defer c.Close()
+	testStructuredCache(t, c)
+}
+
+func TestCacheBoltStructured(t *testing.T) {
+	c, cleanup := newTestCacheBolt(t)
+	defer cleanup()
+	testStructuredCache(t, c)
+}
+
+func TestCacheListRange(t *testing.T) {
+	list := []string{"a", "b", "c", "d"}
+	cases := []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, list},
+		{1, 2, []string{"b", "c"}},
+		{-2, -1, []string{"c", "d"}},
+		{-10, 1, []string{"a", "b"}},
+		{2, 100, []string{"c", "d"}},
+		{3, 1, []string{}},
+		{5, 6, []string{}},
+	}
+	for _, item := range cases {
+		if got := cacheListRange(list, item.start, item.stop); !reflect.DeepEqual(got, item.want) {
+			t.Errorf("range(%d, %d): expected %v, got %v", item.start, item.stop, item.want, got)
+		}
+	}
+}
+
+func TestGetOrLoadCache(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	type user struct {
+		ID   uint
+		Name string
+	}
+	var (
+		calls int32
+		wg    sync.WaitGroup
+	)
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return user{ID: 1, Name: "admin"}, nil
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var dst user
+			if err := GetOrLoadCache("sc_user_1", time.Minute, &dst, loader); err != nil || dst.Name != "admin" {
+				t.Errorf("unexpected result: %v %v", dst, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("loader called %d times", calls)
+	}
+
+	fail := errors.New("load failed")
+	var dst user
+	if err := GetOrLoadCache("sc_user_2", time.Minute, &dst, func() (interface{}, error) {
+		return nil, fail
+	}); err != fail || ExistsCache("sc_user_2") {
+		t.Errorf("expected loader error, got %v", err)
+	}
+}
+
+func TestLoadCacheOncePanic(t *testing.T) {
+	started := make(chan struct{})
+	waiter := make(chan error, 1)
+	go func() {
+		<-started
+		_, err := loadCacheOnce("sc_panic", func() (string, error) { return "unused", nil })
+		waiter <- err
+	}()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to propagate, got %v", r)
+			}
+		}()
+		_, _ = loadCacheOnce("sc_panic", func() (string, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	if err := <-waiter; err == nil {
+		t.Error("expected waiter to receive the loader panic as an error")
+	}
+	// 发生panic后不残留加载记录
+	if val, err := loadCacheOnce("sc_panic", func() (string, error) { return "ok", nil }); val != "ok" || err != nil {
+		t.Errorf("unexpected result after panic: %q %v", val, err)
+	}
+}
@@ -0,0 +1,381 @@
+package kuu
+
+import (
+	uuid "github.com/satori/go.uuid"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CacheInvalidateChannel 多级缓存失效通知的频道
+const CacheInvalidateChannel = "kuu_cache_invalidate"
+
+// CacheTieredL1TTL 本地缓存默认的过期时间
+var CacheTieredL1TTL = 5 * time.Second
+
+// CacheStats 缓存命中统计
+type CacheStats struct {
+	Hits    int64
+	Misses  int64
+	L1Size  int
+	HitRate float64
+}
+
+type cacheInvalidation struct {
+	Source string
+	Keys   []string
+}
+
+// CacheTiered 多级缓存：本地内存作为L1，其他缓存（一般为Redis）作为L2，写入和删除时通知其他实例清除本地副本；
+// 整数值（一般为计数器）不进入L1，始终读写L2
+type CacheTiered struct {
+	hits     int64
+	misses   int64
+	l1       *CacheMemory
+	l2       Cache
+	l1TTL    time.Duration
+	instance string
+}
+
+// NewCacheTiered
+func NewCacheTiered(l2 Cache, l1TTL time.Duration, maxEntries ...int) *CacheTiered {
+	if l1TTL <= 0 {
+		l1TTL = CacheTieredL1TTL
+	}
+	c := &CacheTiered{
+		l1:       NewCacheMemory(maxEntries...),
+		l2:       l2,
+		l1TTL:    l1TTL,
+		instance: strings.ReplaceAll(uuid.NewV4().String(), "-", ""),
+	}
+	if err := l2.Subscribe([]string{CacheInvalidateChannel}, c.onInvalidate); err != nil {
+		ERROR(err)
+	}
+	return c
+}
+
+func (c *CacheTiered) onInvalidate(_ string, payload string) {
+	var msg cacheInvalidation
+	if err := JSONParse(payload, &msg); err != nil {
+		ERROR(err)
+		return
+	}
+	if msg.Source != c.instance {
+		c.l1.Del(msg.Keys...)
+	}
+}
+
+// 清除本地副本并通知其他实例
+func (c *CacheTiered) invalidate(keys ...string) {
+	if len(keys) == 0 {
+		return
+	}
+	c.l1.Del(keys...)
+	msg := cacheInvalidation{Source: c.instance, Keys: keys}
+	if err := c.l2.Publish(CacheInvalidateChannel, JSONStringify(msg)); err != nil {
+		ERROR(err)
+	}
+}
+
+// L1的过期时间不超过L2
+func (c *CacheTiered) l1Expiration(expiration ...time.Duration) time.Duration {
+	if len(expiration) > 0 && expiration[0] > 0 && expiration[0] < c.l1TTL {
+		return expiration[0]
+	}
+	return c.l1TTL
+}
+
+// 从L2回填L1时，过期时间不超过键在L2中的剩余时间
+func (c *CacheTiered) l1FillExpiration(key string) time.Duration {
+	if v, ok := c.l2.(StructuredCache); ok {
+		if ttl := v.TTL(key); ttl > 0 && ttl < c.l1TTL {
+			return ttl
+		}
+	}
+	return c.l1TTL
+}
+
+// SetString
+func (c *CacheTiered) SetString(key, val string, expiration ...time.Duration) {
+	c.l2.SetString(key, val, expiration...)
+	c.invalidate(key)
+	c.l1.SetString(key, val, c.l1Expiration(expiration...))
+}
+
+// GetString
+func (c *CacheTiered) GetString(key string) string {
+	if val, ok := c.l1.Get(key); ok {
+		atomic.AddInt64(&c.hits, 1)
+		return val
+	}
+	atomic.AddInt64(&c.misses, 1)
+	val := c.l2.GetString(key)
+	// 空值不缓存，避免计数类的键在其他实例写入后读取不到
+	if val != "" {
+		c.l1.SetString(key, val, c.l1FillExpiration(key))
+	}
+	return val
+}
+
+// SetInt 整数值不进入L1，清除其他实例中可能以字符串读取的副本
+func (c *CacheTiered) SetInt(key string, val int, expiration ...time.Duration) {
+	c.l2.SetInt(key, val, expiration...)
+	c.invalidate(key)
+}
+
+// GetInt 直接读取L2，计数器在其他实例递增时不广播失效通知
+func (c *CacheTiered) GetInt(key string) int {
+	atomic.AddInt64(&c.misses, 1)
+	return c.l2.GetInt(key)
+}
+
+// Incr 计数直接写入L2以保证原子性，计数器不在L1中缓存，只清除本地副本而不广播
+func (c *CacheTiered) Incr(key string) int {
+	val := c.l2.Incr(key)
+	c.l1.Del(key)
+	return val
+}
+
+// Expire
+func (c *CacheTiered) Expire(key string, expiration time.Duration) bool {
+	v, ok := c.l2.(CacheExpirer)
+	if !ok {
+		return false
+	}
+	c.invalidate(key)
+	return v.Expire(key, expiration)
+}
+
+// Del
+func (c *CacheTiered) Del(keys ...string) {
+	c.l2.Del(keys...)
+	c.invalidate(keys...)
+}
+
+func (c *CacheTiered) structured() StructuredCache {
+	if v, ok := c.l2.(StructuredCache); ok {
+		return v
+	}
+	ERROR(ErrCacheUnsupported)
+	return nil
+}
+
+// Exists 结构化操作直接读写L2
+func (c *CacheTiered) Exists(key string) bool {
+	if v := c.structured(); v != nil {
+		return v.Exists(key)
+	}
+	return false
+}
+
+// TTL
+func (c *CacheTiered) TTL(key string) time.Duration {
+	if v := c.structured(); v != nil {
+		return v.TTL(key)
+	}
+	return CacheTTLMissing
+}
+
+// SetNX
+func (c *CacheTiered) SetNX(key, val string, expiration ...time.Duration) (ok bool) {
+	if v := c.structured(); v != nil {
+		if ok = v.SetNX(key, val, expiration...); ok {
+			c.invalidate(key)
+		}
+	}
+	return
+}
+
+// IncrBy
+func (c *CacheTiered) IncrBy(key string, n int, expiration ...time.Duration) (val int) {
+	if v := c.structured(); v != nil {
+		val = v.IncrBy(key, n, expiration...)
+		c.l1.Del(key)
+	}
+	return
+}
+
+// HSet
+func (c *CacheTiered) HSet(key, field, val string) {
+	if v := c.structured(); v != nil {
+		v.HSet(key, field, val)
+		c.invalidate(key)
+	}
+}
+
+// HGet
+func (c *CacheTiered) HGet(key, field string) string {
+	if v := c.structured(); v != nil {
+		return v.HGet(key, field)
+	}
+	return ""
+}
+
+// HGetAll
+func (c *CacheTiered) HGetAll(key string) map[string]string {
+	if v := c.structured(); v != nil {
+		return v.HGetAll(key)
+	}
+	return nil
+}
+
+// HDel
+func (c *CacheTiered) HDel(key string, fields ...string) {
+	if v := c.structured(); v != nil {
+		v.HDel(key, fields...)
+		c.invalidate(key)
+	}
+}
+
+// LPush
+func (c *CacheTiered) LPush(key string, vals ...string) (n int) {
+	if v := c.structured(); v != nil {
+		n = v.LPush(key, vals...)
+		c.invalidate(key)
+	}
+	return
+}
+
+// RPush
+func (c *CacheTiered) RPush(key string, vals ...string) (n int) {
+	if v := c.structured(); v != nil {
+		n = v.RPush(key, vals...)
+		c.invalidate(key)
+	}
+	return
+}
+
+// LPop
+func (c *CacheTiered) LPop(key string) (val string) {
+	if v := c.structured(); v != nil {
+		val = v.LPop(key)
+		c.invalidate(key)
+	}
+	return
+}
+
+// RPop
+func (c *CacheTiered) RPop(key string) (val string) {
+	if v := c.structured(); v != nil {
+		val = v.RPop(key)
+		c.invalidate(key)
+	}
+	return
+}
+
+// LRange
+func (c *CacheTiered) LRange(key string, start, stop int) []string {
+	if v := c.structured(); v != nil {
+		return v.LRange(key, start, stop)
+	}
+	return nil
+}
+
+// LLen
+func (c *CacheTiered) LLen(key string) int {
+	if v := c.structured(); v != nil {
+		return v.LLen(key)
+	}
+	return 0
+}
+
+// CompareAndDel
+func (c *CacheTiered) CompareAndDel(key, val string) (ok bool) {
+	if v, is := c.l2.(CacheLocker); is {
+		if ok = v.CompareAndDel(key, val); ok {
+			c.invalidate(key)
+		}
+	}
+	return
+}
+
+// CompareAndExpire
+func (c *CacheTiered) CompareAndExpire(key, val string, expiration time.Duration) (ok bool) {
+	if v, is := c.l2.(CacheLocker); is {
+		if ok = v.CompareAndExpire(key, val, expiration); ok {
+			c.invalidate(key)
+		}
+	}
+	return
+}
+
+// HasPrefix 扫描类操作直接查询L2
+func (c *CacheTiered) HasPrefix(prefix string, limit int) map[string]string {
+	return c.l2.HasPrefix(prefix, limit)
+}
+
+// HasSuffix
+func (c *CacheTiered) HasSuffix(suffix string, limit int) map[string]string {
+	return c.l2.HasSuffix(suffix, limit)
+}
+
+// Contains
+func (c *CacheTiered) Contains(pattern string, limit int) map[string]string {
+	return c.l2.Contains(pattern, limit)
+}
+
+// Keys
+func (c *CacheTiered) Keys(pattern string, limit int) []string {
+	if v, ok := c.l2.(CacheInspector); ok {
+		return v.Keys(pattern, limit)
+	}
+	return nil
+}
+
+// Inspect
+func (c *CacheTiered) Inspect(key string) *CacheEntry {
+	if v, ok := c.l2.(CacheInspector); ok {
+		return v.Inspect(key)
+	}
+	return nil
+}
+
+// Info 返回L2的统计及L1的命中统计
+func (c *CacheTiered) Info() (info CacheInfo) {
+	if v, ok := c.l2.(CacheInspector); ok {
+		info = v.Info()
+	}
+	stats := c.Stats()
+	info.L1 = &stats
+	return
+}
+
+// Stats 返回L1命中统计
+func (c *CacheTiered) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+		L1Size: c.l1.Len(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// ResetStats
+func (c *CacheTiered) ResetStats() {
+	atomic.StoreInt64(&c.hits, 0)
+	atomic.StoreInt64(&c.misses, 0)
+}
+
+// Close
+func (c *CacheTiered) Close() {
+	c.l1.Close()
+	c.l2.Close()
+}
+
+// Publish
+func (c *CacheTiered) Publish(channel string, message interface{}) error {
+	return c.l2.Publish(channel, message)
+}
+
+// Subscribe
+func (c *CacheTiered) Subscribe(channels []string, handler func(string, string)) error {
+	return c.l2.Subscribe(channels, handler)
+}
+
+// PSubscribe
+func (c *CacheTiered) PSubscribe(patterns []string, handler func(string, string)) error {
+	return c.l2.PSubscribe(patterns, handler)
+}
@@ -0,0 +1,106 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheTieredInvalidation(t *testing.T) {
+	l2 := NewCacheMemory()
+	a := NewCacheTiered(l2, time.Minute)
+	b := NewCacheTiered(l2, time.Minute)
+	defer a.Close()
+	defer b.Close()
+
+	a.SetString("user_1", "v1")
+	if b.GetString("user_1") != "v1" {
+		t.Fatal("value not read from L2")
+	}
+	if b.GetString("user_1") != "v1" {
+		t.Fatal("value not read from L1")
+	}
+	if stats := b.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.HitRate != 0.5 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	a.SetString("user_1", "v2")
+	waitFor(t, func() bool { return b.GetString("user_1") == "v2" })
+
+	a.Del("user_1")
+	waitFor(t, func() bool { return b.GetString("user_1") == "" })
+
+	b.SetInt("counter", 1)
+	if a.GetInt("counter") != 1 {
+		t.Fatal("int not read from L2")
+	}
+	b.Incr("counter")
+	waitFor(t, func() bool { return a.GetInt("counter") == 2 })
+
+	b.ResetStats()
+	if stats := b.Stats(); stats.Hits != 0 || stats.Misses != 0 {
+		t.Errorf("stats not reset: %+v", stats)
+	}
+}
+
+func TestCacheTieredL1Expiration(t *testing.T) {
+	l2 := NewCacheMemory()
+	c := NewCacheTiered(l2, time.Minute)
+	defer c.Close()
+
+	c.SetString("captcha", "1", 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	if c.GetString("captcha") != "" {
+		t.Error("L1 outlived L2 expiration")
+	}
+
+	// 其他实例写入的值回填L1时同样不超过L2的剩余时间
+	l2.SetString("token", "1", 20*time.Millisecond)
+	if c.GetString("token") != "1" {
+		t.Fatal("value not read from L2")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if c.GetString("token") != "" {
+		t.Error("L1 fill outlived L2 expiration")
+	}
+}
+
+func TestCacheTieredCounters(t *testing.T) {
+	l2 := NewCacheMemory()
+	a := NewCacheTiered(l2, time.Minute)
+	b := NewCacheTiered(l2, time.Minute)
+	defer a.Close()
+	defer b.Close()
+
+	var published int
+	if err := l2.Subscribe([]string{CacheInvalidateChannel}, func(string, string) { published++ }); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		a.Incr("login_failed")
+		b.IncrBy("login_failed", 1)
+		// 计数器不进入L1，其他实例无需等待失效通知即可读到最新值
+		if v := b.GetInt("login_failed"); v != i*2 {
+			t.Fatalf("expected %d, got %d", i*2, v)
+		}
+		if v := a.GetInt("login_failed"); v != i*2 {
+			t.Fatalf("expected %d, got %d", i*2, v)
+		}
+	}
+	if a.Stats().L1Size != 0 || b.Stats().L1Size != 0 {
+		t.Error("counter cached in L1")
+	}
+	if published != 0 {
+		t.Errorf("expected no invalidation broadcasts for counters, got %d", published)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
@@ -0,0 +1,418 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DataChangeActionCreate = "CREATE"
+	DataChangeActionUpdate = "UPDATE"
+	DataChangeActionDelete = "DELETE"
+
+	changeAuditSnapshotKey = "kuu:change_audit_snapshot"
+	changeAuditRedacted    = "***"
+)
+
+// ChangeAuditReloadBatchSize 重新加载变更记录时每条查询包含的主键数，避免超出数据库的参数个数限制
+var ChangeAuditReloadBatchSize = 500
+
+// ChangeAuditExcludes 不记录数据变更的模型，避免日志表自身、高频会话表或密钥表产生变更记录
+var ChangeAuditExcludes = []string{
+	"DataChangeLog",
+	"OutboxEvent",
+	"EventLog",
+	"EventLogLabel",
+	"EventLogCheckpoint",
+	"SignSecret",
+	"SignHistory",
+	"SignRefreshToken",
+	"SignKey",
+	"UserTOTP",
+	"UserRecoveryCode",
+	"PasswordHistory",
+	"PasswordResetToken",
+}
+
+// DataChangeField 字段变更前后的值
+type DataChangeField struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// DataChangeEvent 数据变更事件
+type DataChangeEvent struct {
+	Table      string
+	Model      string
+	PrimaryKey string
+	Action     string
+	Changes    []DataChangeField
+	UID        uint
+	ActorUID   uint
+	RequestID  string
+	Time       int64
+}
+
+// ChangeSink 数据变更事件的输出，tx为产生变更的事务
+type ChangeSink interface {
+	WriteChanges(tx *gorm.DB, events []DataChangeEvent) error
+}
+
+// DataChangeLog 数据变更日志
+type DataChangeLog struct {
+	Model      `rest:"R" displayName:"数据变更日志"`
+	Table      string `name:"表名" gorm:"NOT NULL;INDEX:data_change_log_record"`
+	ModelName  string `name:"模型名称" gorm:"NOT NULL"`
+	PrimaryKey string `name:"记录主键" gorm:"NOT NULL;INDEX:data_change_log_record"`
+	Action     string `name:"变更类型" gorm:"NOT NULL" enum:"DataChangeAction"`
+	Changes    string `name:"变更字段(JSON-String)" gorm:"type:text"`
+	UID        uint   `name:"操作人ID"`
+	ActorUID   uint   `name:"实际操作人ID"`
+	RequestID  string `name:"请求ID"`
+	ChangeTime int64  `name:"变更时间" gorm:"NOT NULL"`
+}
+
+func init() {
+	Enum("DataChangeAction", "数据变更类型").
+		Add(DataChangeActionCreate, "新增").
+		Add(DataChangeActionUpdate, "修改").
+		Add(DataChangeActionDelete, "删除")
+}
+
+// DBChangeSink 写入DataChangeLog表，与数据变更在同一事务中提交
+type DBChangeSink struct{}
+
+// WriteChanges
+func (DBChangeSink) WriteChanges(tx *gorm.DB, events []DataChangeEvent) error {
+	for _, event := range events {
+		log := DataChangeLog{
+			Table:      event.Table,
+			ModelName:  event.Model,
+			PrimaryKey: event.PrimaryKey,
+			Action:     event.Action,
+			Changes:    JSONStringify(event.Changes),
+			UID:        event.UID,
+			ActorUID:   event.ActorUID,
+			RequestID:  event.RequestID,
+			ChangeTime: event.Time,
+		}
+		if err := tx.Create(&log).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FileChangeSink 以JSON Lines格式追加写入文件，在事务提交后写入，回滚的变更不会留下记录
+type FileChangeSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// WriteChanges
+func (s *FileChangeSink) WriteChanges(tx *gorm.DB, events []DataChangeEvent) error {
+	var buf strings.Builder
+	for _, event := range events {
+		buf.WriteString(JSONStringify(event))
+		buf.WriteString("\n")
+	}
+	if tx == nil {
+		return s.write(buf.String())
+	}
+	AfterCommit(tx, func() {
+		if err := s.write(buf.String()); err != nil {
+			ERROR("write data changes to %s failed: %v", s.Path, err)
+		}
+	})
+	return nil
+}
+
+func (s *FileChangeSink) write(lines string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.Path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(lines)
+	return err
+}
+
+var (
+	customChangeSinks []ChangeSink
+	configChangeSinks []ChangeSink
+	changeSinksOnce   sync.Once
+)
+
+// AddChangeSink 注册自定义的数据变更输出
+func AddChangeSink(sinks ...ChangeSink) {
+	customChangeSinks = append(customChangeSinks, sinks...)
+}
+
+// GetChangeSinks 返回配置的输出（changeAudit.sinks，默认为db）及自定义输出
+func GetChangeSinks() []ChangeSink {
+	changeSinksOnce.Do(func() {
+		names := []string{"db"}
+		C().GetInterface("changeAudit.sinks", &names)
+		for _, name := range names {
+			switch strings.ToLower(name) {
+			case "db":
+				configChangeSinks = append(configChangeSinks, DBChangeSink{})
+			case "file":
+				file := C().GetString("changeAudit.file")
+				if file == "" {
+					file = filepath.Join("logs", "changes.log")
+				}
+				configChangeSinks = append(configChangeSinks, &FileChangeSink{Path: file})
+			case "bus":
+				configChangeSinks = append(configChangeSinks, EventBusChangeSink{Topic: C().GetString("changeAudit.topic")})
+			default:
+				WARN("unknown change audit sink: %s", name)
+			}
+		}
+	})
+	return append(configChangeSinks[:len(configChangeSinks):len(configChangeSinks)], customChangeSinks...)
+}
+
+// ChangeAuditEnabled 判断模型是否记录数据变更
+func ChangeAuditEnabled(modelName string) bool {
+	if modelName == "" || !C().GetBool("changeAudit.enabled") {
+		return false
+	}
+	excludes := ChangeAuditExcludes
+	var extra []string
+	C().GetInterface("changeAudit.exclude", &extra)
+	for _, item := range append(excludes[:len(excludes):len(excludes)], extra...) {
+		if item == modelName {
+			return false
+		}
+	}
+	return true
+}
+
+func changeAuditScope(scope *gorm.Scope) (*Metadata, bool) {
+	if scope.HasError() || scope.Value == nil {
+		return nil, false
+	}
+	meta := Meta(scope.Value)
+	if meta == nil || !ChangeAuditEnabled(meta.Name) {
+		return nil, false
+	}
+	return meta, true
+}
+
+// 修改和删除前记录受影响数据的快照
+func changeAuditBeforeCallback(scope *gorm.Scope) {
+	if _, ok := changeAuditScope(scope); !ok {
+		return
+	}
+	// 条件语句会写入SQLVars，查询后需还原，避免影响后续的更新语句
+	vars := scope.SQLVars
+	scope.SQLVars = nil
+	where := scope.CombinedConditionSql()
+	whereVars := scope.SQLVars
+	scope.SQLVars = vars
+
+	rows, err := loadChangeRows(scope, where, whereVars)
+	if err != nil {
+		_ = scope.Err(err)
+		return
+	}
+	scope.InstanceSet(changeAuditSnapshotKey, rows)
+}
+
+func changeAuditAfterCallback(action string) func(scope *gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		meta, ok := changeAuditScope(scope)
+		if !ok {
+			return
+		}
+		passwords := make(map[string]bool)
+		for _, field := range meta.Fields {
+			if field.IsPassword {
+				passwords[field.Code] = true
+			}
+		}
+		var events []DataChangeEvent
+		switch action {
+		case DataChangeActionCreate:
+			changes := diffChangeFields(scope, nil, scope.Value, passwords)
+			events = append(events, newDataChangeEvent(scope, meta, action, fmt.Sprint(scope.PrimaryKeyValue()), changes))
+		default:
+			v, has := scope.InstanceGet(changeAuditSnapshotKey)
+			if !has {
+				return
+			}
+			before, _ := v.([]interface{})
+			if len(before) == 0 {
+				return
+			}
+			after, err := reloadChangeRows(scope, before)
+			if err != nil {
+				_ = scope.Err(err)
+				return
+			}
+			for _, old := range before {
+				key := fmt.Sprint(scope.New(old).PrimaryKeyValue())
+				current := after[key]
+				if action == DataChangeActionDelete {
+					// 数据权限等条件可能使部分记录未被删除
+					if current != nil && !changeRowDeleted(scope, current) {
+						continue
+					}
+					events = append(events, newDataChangeEvent(scope, meta, action, key, diffChangeFields(scope, old, nil, passwords)))
+					continue
+				}
+				if current == nil {
+					continue
+				}
+				if changes := diffChangeFields(scope, old, current, passwords); len(changes) > 0 {
+					events = append(events, newDataChangeEvent(scope, meta, action, key, changes))
+				}
+			}
+		}
+		if len(events) == 0 {
+			return
+		}
+		tx := scope.NewDB()
+		for _, sink := range GetChangeSinks() {
+			if err := sink.WriteChanges(tx, events); err != nil {
+				// 无法留痕时回滚本次变更
+				_ = scope.Err(err)
+				return
+			}
+		}
+	}
+}
+
+func newDataChangeEvent(scope *gorm.Scope, meta *Metadata, action, key string, changes []DataChangeField) DataChangeEvent {
+	event := DataChangeEvent{
+		Table:      scope.TableName(),
+		Model:      meta.Name,
+		PrimaryKey: key,
+		Action:     action,
+		Changes:    changes,
+		RequestID:  GetRoutineRequestID(),
+		Time:       time.Now().Unix(),
+	}
+	if desc := GetRoutinePrivilegesDesc(); desc != nil {
+		event.UID = desc.UID
+		event.ActorUID = desc.Actor()
+	}
+	return event
+}
+
+func loadChangeRows(scope *gorm.Scope, where string, vars []interface{}) ([]interface{}, error) {
+	sql := fmt.Sprintf("SELECT * FROM %s%s", scope.QuotedTableName(), AddExtraSpaceIfExist(where))
+	// 与gorm的Raw一致，将通用占位符替换为?（MySQL、SQLite等方言的BindVar返回$$$）
+	sql = strings.Replace(sql, "$$$", "?", -1)
+	rows, err := scope.SQLDB().Query(sql, vars...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	modelType := scope.GetModelStruct().ModelType
+	var list []interface{}
+	for rows.Next() {
+		item := reflect.New(modelType).Interface()
+		if err := scope.NewDB().ScanRows(rows, item); err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+	return list, rows.Err()
+}
+
+func reloadChangeRows(scope *gorm.Scope, before []interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(before))
+	size := ChangeAuditReloadBatchSize
+	if size <= 0 {
+		size = len(before)
+	}
+	for start := 0; start < len(before); start += size {
+		end := start + size
+		if end > len(before) {
+			end = len(before)
+		}
+		var (
+			placeholders []string
+			vars         []interface{}
+		)
+		for i, item := range before[start:end] {
+			placeholders = append(placeholders, scope.Dialect().BindVar(i+1))
+			vars = append(vars, scope.New(item).PrimaryKeyValue())
+		}
+		where := fmt.Sprintf("WHERE %s IN (%s)", scope.Quote(scope.PrimaryKey()), strings.Join(placeholders, ","))
+		rows, err := loadChangeRows(scope, where, vars)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range rows {
+			result[fmt.Sprint(scope.New(item).PrimaryKeyValue())] = item
+		}
+	}
+	return result, nil
+}
+
+func changeRowDeleted(scope *gorm.Scope, row interface{}) bool {
+	field, has := scope.New(row).FieldByName("DeletedAt")
+	return has && !field.IsBlank
+}
+
+// 对比字段变化，old或current为nil时分别表示新增和删除
+func diffChangeFields(scope *gorm.Scope, old, current interface{}, passwords map[string]bool) []DataChangeField {
+	var oldFields, newFields map[string]*gorm.Field
+	collect := func(value interface{}) map[string]*gorm.Field {
+		if value == nil {
+			return nil
+		}
+		fields := make(map[string]*gorm.Field)
+		for _, field := range scope.New(value).Fields() {
+			if field.IsNormal && !field.IsIgnored {
+				fields[field.Name] = field
+			}
+		}
+		return fields
+	}
+	oldFields, newFields = collect(old), collect(current)
+	var changes []DataChangeField
+	for _, field := range scope.GetModelStruct().StructFields {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		item := DataChangeField{Field: field.Name}
+		if f := oldFields[field.Name]; f != nil && !f.IsBlank {
+			item.Old = f.Field.Interface()
+		}
+		if f := newFields[field.Name]; f != nil && !f.IsBlank {
+			item.New = f.Field.Interface()
+		}
+		if item.Old == nil && item.New == nil {
+			continue
+		}
+		if old != nil && current != nil && JSONStringify(item.Old) == JSONStringify(item.New) {
+			continue
+		}
+		if passwords[field.Name] {
+			if item.Old != nil {
+				item.Old = changeAuditRedacted
+			}
+			if item.New != nil {
+				item.New = changeAuditRedacted
+			}
+		}
+		changes = append(changes, item)
+	}
+	return changes
+}
@@ -0,0 +1,158 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+func TestFileChangeSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuu_changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sink := &FileChangeSink{Path: filepath.Join(dir, "logs", "changes.log")}
+	events := []DataChangeEvent{
+		{Table: "user", Model: "User", PrimaryKey: "1", Action: DataChangeActionUpdate, Changes: []DataChangeField{{Field: "Password", Old: changeAuditRedacted, New: changeAuditRedacted}}},
+		{Table: "user", Model: "User", PrimaryKey: "2", Action: DataChangeActionDelete},
+	}
+	for i := 0; i < 2; i++ {
+		if err := sink.WriteChanges(nil, events); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(sink.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d", len(lines))
+	}
+	var event DataChangeEvent
+	if err := JSONParse(lines[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.PrimaryKey != "1" || len(event.Changes) != 1 || event.Changes[0].New != changeAuditRedacted {
+		t.Errorf("unexpected event: %s", lines[0])
+	}
+}
+
+func TestFileChangeSinkAfterCommit(t *testing.T) {
+	defer useTestDB(t)()
+	dir, err := ioutil.TempDir("", "kuu_changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sink := &FileChangeSink{Path: filepath.Join(dir, "changes.log")}
+	events := []DataChangeEvent{{Table: "user", Model: "User", PrimaryKey: "1", Action: DataChangeActionDelete}}
+
+	// 事务回滚时不写入
+	_ = WithTransaction(func(tx *gorm.DB) error {
+		if err := sink.WriteChanges(tx, events); err != nil {
+			t.Fatal(err)
+		}
+		return errors.New("rollback")
+	})
+	if _, err := os.Stat(sink.Path); !os.IsNotExist(err) {
+		t.Fatalf("expected no file after rollback, got %v", err)
+	}
+	if err := WithTransaction(func(tx *gorm.DB) error {
+		return sink.WriteChanges(tx, events)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(sink.Path); err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("expected 1 line after commit, got %q: %v", data, err)
+	}
+}
+
+func TestChangeAuditReloadInBatches(t *testing.T) {
+	defer useTestConfig(`{"changeAudit": {"enabled": true, "sinks": ["db"]}}`)()
+	defer useTestDB(t, &DataChangeLog{}, &OAuthClient{})()
+	prev := ChangeAuditReloadBatchSize
+	ChangeAuditReloadBatchSize = 2
+	defer func() { ChangeAuditReloadBatchSize = prev }()
+
+	for i := 0; i < 5; i++ {
+		if err := DB().Create(&OAuthClient{ClientID: fmt.Sprintf("app%d", i), Name: "App"}).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := DB().Model(&OAuthClient{}).Where("name = ?", "App").Update("name", "Renamed").Error; err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	DB().Model(&DataChangeLog{}).Where("action = ?", DataChangeActionUpdate).Count(&count)
+	if count != 5 {
+		t.Errorf("expected 5 update logs, got %d", count)
+	}
+}
+
+func TestChangeAuditOmitsSecrets(t *testing.T) {
+	defer useTestConfig(`{"changeAudit": {"enabled": true, "sinks": ["db"]}}`)()
+	defer useTestDB(t, &DataChangeLog{}, &UserTOTP{}, &OAuthClient{})()
+
+	enrolled, err := enrollTOTP(DB(), 1, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DB().Create(&OAuthClient{ClientID: "app", SecretHash: "s3cret-hash", Name: "App"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var logs []DataChangeLog
+	if err := DB().Find(&logs).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].ModelName != "OAuthClient" {
+		t.Fatalf("unexpected change logs: %+v", logs)
+	}
+	for _, log := range logs {
+		if strings.Contains(log.Changes, enrolled["Secret"].(string)) || strings.Contains(log.Changes, "s3cret-hash") {
+			t.Errorf("secret written to change log: %s", log.Changes)
+		}
+	}
+	if !strings.Contains(logs[0].Changes, changeAuditRedacted) {
+		t.Errorf("secret hash not redacted: %s", logs[0].Changes)
+	}
+}
+
+func TestEventBusChangeSinkUsesOutbox(t *testing.T) {
+	defer useTestDB(t, &OutboxEvent{})()
+
+	events := []DataChangeEvent{{Table: "user", Model: "User", PrimaryKey: "1", Action: DataChangeActionUpdate}}
+	if err := (EventBusChangeSink{}).WriteChanges(DB(), events); err != ErrNoTransaction {
+		t.Errorf("expected ErrNoTransaction, got %v", err)
+	}
+	// 事务回滚时不发布
+	tx := DB().Begin()
+	if err := (EventBusChangeSink{}).WriteChanges(tx, events); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	var count int
+	DB().Model(&OutboxEvent{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("expected no outbox events after rollback, got %d", count)
+	}
+
+	tx = DB().Begin()
+	if err := (EventBusChangeSink{}).WriteChanges(tx, events); err != nil {
+		t.Fatal(err)
+	}
+	tx.Commit()
+	var outbox []OutboxEvent
+	DB().Find(&outbox)
+	if len(outbox) != 1 || outbox[0].Topic != ChangeEventTopic || outbox[0].Aggregate != "User:1" || outbox[0].Status != OutboxStatusPending {
+		t.Errorf("unexpected outbox events: %+v", outbox)
+	}
+}
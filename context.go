@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type STDReply struct {
@@ -266,6 +267,14 @@ func (c *Context) validSignType(sign *SignContext) bool {
 	return false
 }
 
+// HasPermission 判断当前用户是否为root或拥有指定权限，令牌限定了授权范围时root也受其限制
+func (c *Context) HasPermission(code string) bool {
+	if c.SignInfo == nil || !c.SignInfo.Secret.PermissionAllowed(code) {
+		return false
+	}
+	return c.SignInfo.UID == RootUID() || c.PrisDesc.HasPermission(code)
+}
+
 // QueryCI
 func (c *Context) QueryCI(key string) (v string) {
 	query := c.Request.URL.Query()
@@ -324,7 +333,16 @@ func (c *Context) parseAcceptLanguage() string {
 
 // WithTransaction
 func (c *Context) WithTransaction(fn func(*gorm.DB) error) error {
-	return WithTransaction(fn)
+	return WithTransaction(func(tx *gorm.DB) error {
+		return c.bindTx(tx, func() error { return fn(tx) })
+	})
+}
+
+// WithLockedTransaction
+func (c *Context) WithLockedTransaction(key string, ttl time.Duration, fn func(*gorm.DB, *CacheLock) error) error {
+	return WithLockedTransaction(key, ttl, func(tx *gorm.DB, l *CacheLock) error {
+		return c.bindTx(tx, func() error { return fn(tx, l) })
+	})
 }
 
 // SetValue
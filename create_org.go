@@ -94,6 +94,8 @@ func CreateOrg(args *CreateOrgArgs) (reply *CreateOrgReply, err error) {
 				Username:    args.AdminUsername,
 				Password:    password,
 				IsBuiltIn:   null.BoolFrom(true),
+				// 自动生成的密码不受密码策略约束
+				IgnorePasswordPolicy: args.GeneratePassword,
 			}
 			if err := mergo.Merge(&adminUser, args.ExtraAdminUserInfo); err != nil {
 				return nil, err
@@ -2,7 +2,9 @@ package kuu
 
 import (
 	"crypto/md5"
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"golang.org/x/crypto/bcrypt"
@@ -31,6 +33,25 @@ func Sha1(p string, upper ...bool) (v string) {
 	return
 }
 
+// Sha256 加密
+func Sha256(p string, upper ...bool) (v string) {
+	d := sha256.Sum256([]byte(p))
+	v = hex.EncodeToString(d[:])
+	if len(upper) > 0 && upper[0] {
+		v = strings.ToUpper(v)
+	}
+	return
+}
+
+// RandomHex 生成指定字节数的安全随机串（十六进制）
+func RandomHex(size int) string {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		PANIC(err)
+	}
+	return hex.EncodeToString(b)
+}
+
 // GenerateFromPassword 生成新密码
 func GenerateFromPassword(p string) (string, error) {
 	hash, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.DefaultCost)
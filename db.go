@@ -124,17 +124,48 @@ func WithTransaction(fn func(*gorm.DB) error) (err error) {
 		err = tx.Error
 		return
 	}
+	key := tx.CommonDB()
+	afterCommitMu.Lock()
+	afterCommitHooks[key] = nil
+	afterCommitMu.Unlock()
 	defer func() {
+		afterCommitMu.Lock()
+		hooks := afterCommitHooks[key]
+		delete(afterCommitHooks, key)
+		afterCommitMu.Unlock()
 		if err != nil {
 			_ = tx.Rollback()
 		} else {
 			err = tx.Commit().Error
 		}
+		if err == nil {
+			for _, hook := range hooks {
+				hook()
+			}
+		}
 	}()
 	err = fn(tx)
 	return
 }
 
+var (
+	afterCommitHooks = make(map[interface{}][]func())
+	afterCommitMu    sync.Mutex
+)
+
+// AfterCommit 在WithTransaction的事务提交成功后执行fn，回滚时不执行，tx不是WithTransaction开启的事务时立即执行
+func AfterCommit(tx *gorm.DB, fn func()) {
+	key := tx.CommonDB()
+	afterCommitMu.Lock()
+	if hooks, ok := afterCommitHooks[key]; ok {
+		afterCommitHooks[key] = append(hooks, fn)
+		afterCommitMu.Unlock()
+		return
+	}
+	afterCommitMu.Unlock()
+	fn()
+}
+
 func releaseDB() {
 	dataSourcesMap.Range(func(_, value interface{}) bool {
 		db := value.(*gorm.DB)
@@ -0,0 +1,87 @@
+package kuu
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	jsoniter "github.com/json-iterator/go"
+	"sync/atomic"
+	"testing"
+)
+
+var testDBSeq int64
+
+// useTestDB 使用内存SQLite作为默认数据源并迁移模型，返回还原函数
+func useTestDB(t *testing.T, models ...interface{}) func() {
+	name := fmt.Sprintf("file:kuu_test_%d?mode=memory&cache=shared", atomic.AddInt64(&testDBSeq, 1))
+	db, err := gorm.Open("sqlite3", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 内存数据库的每个连接相互独立
+	db.DB().SetMaxOpenConns(1)
+	prev, hasPrev := dataSourcesMap.Load(singleDSName)
+	dataSourcesMap.Store(singleDSName, db)
+	registerCallbacks()
+	if err := db.AutoMigrate(models...).Error; err != nil {
+		t.Fatal(err)
+	}
+	restoreCache := useTestCacheMemory()
+	restoreJSON := useStdJSON()
+	return func() {
+		restoreJSON()
+		restoreCache()
+		db.Close()
+		if hasPrev {
+			dataSourcesMap.Store(singleDSName, prev)
+		} else {
+			dataSourcesMap.Delete(singleDSName)
+		}
+	}
+}
+
+// useTestConfig 替换配置，返回还原函数
+func useTestConfig(data string) func() {
+	C()
+	prev := configInst.data
+	configInst.data = []byte(data)
+	return func() {
+		configInst.data = prev
+	}
+}
+
+// stdJSON 以标准库实现编解码，当前工具链下jsoniter编码map会崩溃
+type stdJSON struct {
+	jsoniter.API
+}
+
+func (stdJSON) Marshal(v interface{}) ([]byte, error) {
+	return stdjson.Marshal(v)
+}
+
+func (stdJSON) MarshalIndent(v interface{}, prefix, indent string) ([]byte, error) {
+	return stdjson.MarshalIndent(v, prefix, indent)
+}
+
+func (stdJSON) MarshalToString(v interface{}) (string, error) {
+	data, err := stdjson.Marshal(v)
+	return string(data), err
+}
+
+func (stdJSON) Unmarshal(data []byte, v interface{}) error {
+	return stdjson.Unmarshal(data, v)
+}
+
+func (stdJSON) UnmarshalFromString(str string, v interface{}) error {
+	return stdjson.Unmarshal([]byte(str), v)
+}
+
+// useStdJSON 替换JSON编解码实现，返回还原函数
+func useStdJSON() func() {
+	prev := json
+	json = stdJSON{prev}
+	return func() {
+		json = prev
+	}
+}
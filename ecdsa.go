@@ -0,0 +1,38 @@
+package kuu
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// GenECKey 生成ECDSA密钥，默认使用P-256曲线
+func GenECKey(curve ...elliptic.Curve) (prvKey, pubKey []byte) {
+	c := elliptic.P256()
+	if len(curve) > 0 && curve[0] != nil {
+		c = curve[0]
+	}
+	privateKey, err := ecdsa.GenerateKey(c, rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	derStream, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		panic(err)
+	}
+	prvKey = pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: derStream,
+	})
+	derPkix, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		panic(err)
+	}
+	pubKey = pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: derPkix,
+	})
+	return
+}
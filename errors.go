@@ -5,9 +5,25 @@ import (
 )
 
 var (
-	ErrTokenNotFound       = errors.New("token not found")
-	ErrSecretNotFound      = errors.New("secret not found")
-	ErrInvalidToken        = errors.New("invalid token")
-	ErrAffectedSaveToken   = errors.New("未新增或修改任何记录，请检查更新条件或数据权限")
-	ErrAffectedDeleteToken = errors.New("未删除任何记录，请检查更新条件或数据权限")
+	ErrTokenNotFound             = errors.New("token not found")
+	ErrSecretNotFound            = errors.New("secret not found")
+	ErrInvalidToken              = errors.New("invalid token")
+	ErrInvalidRefreshToken       = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired       = errors.New("refresh token expired")
+	ErrRefreshTokenReused        = errors.New("refresh token reused")
+	ErrInvalidTwoFactorCode      = errors.New("invalid two-factor code")
+	ErrTwoFactorChallengeExpired = errors.New("two-factor challenge expired")
+	ErrTwoFactorNotEnrolled      = errors.New("two-factor authentication not enrolled")
+	ErrTwoFactorAlreadyEnabled   = errors.New("two-factor authentication already enabled")
+	ErrTwoFactorRequired         = errors.New("two-factor authentication is required")
+	ErrPasswordTooWeak           = errors.New("password is too weak")
+	ErrPasswordReused            = errors.New("password has been used recently")
+	ErrPasswordExpired           = errors.New("password expired")
+	ErrInvalidPasswordResetToken = errors.New("invalid password reset token")
+	ErrIPNotAllowed              = errors.New("client IP is not allowed")
+	ErrScopeNotAllowed           = errors.New("token scope does not allow this request")
+	ErrSessionEvicted            = errors.New("session evicted by a newer login")
+	ErrSessionLimitExceeded      = errors.New("maximum number of concurrent sessions reached")
+	ErrAffectedSaveToken         = errors.New("未新增或修改任何记录，请检查更新条件或数据权限")
+	ErrAffectedDeleteToken       = errors.New("未删除任何记录，请检查更新条件或数据权限")
 )
@@ -0,0 +1,194 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventBusDriverRedis = "redis"
+	EventBusDriverBolt  = "bolt"
+	// ChangeEventTopic 数据变更事件默认发布的主题
+	ChangeEventTopic = "kuu.changes"
+)
+
+var (
+	// EventBusMaxRetries 消息的最大投递次数，超过后转入死信主题
+	EventBusMaxRetries = 5
+	// EventBusRetryInterval 未确认的消息重新投递的间隔
+	EventBusRetryInterval = 30 * time.Second
+	// EventBusMaxLen 每个主题保留的消息数
+	EventBusMaxLen int64 = 100000
+	// EventBusPollInterval 等待新消息的最长时间
+	EventBusPollInterval = time.Second
+	// EventBusBatchSize 每次读取的消息数
+	EventBusBatchSize = 10
+)
+
+// BusMessage 事件总线中的消息
+type BusMessage struct {
+	ID      string
+	Topic   string
+	Payload string
+	// Attempts 投递次数（含本次）
+	Attempts int
+}
+
+// BusHandler 返回nil时确认消息；返回错误时消息在EventBusRetryInterval后重新投递，超过EventBusMaxRetries次后转入死信主题
+type BusHandler func(msg *BusMessage) error
+
+// EventBus 至少投递一次的持久化事件总线，同一消费组内每条消息只由一个消费者处理
+type EventBus interface {
+	// Publish 发布消息，payload为string或[]byte时原样发布，否则序列化为JSON
+	Publish(topic string, payload interface{}) (string, error)
+	// Subscribe 以消费组订阅主题，消费组首次创建时从最新的消息开始消费
+	Subscribe(topic, group string, handler BusHandler) error
+	// Replay 将消费组的位置重置到fromID，之后的消息将重新投递，"0"表示从头开始
+	Replay(topic, group, fromID string) error
+	// Range 从start（含）开始读取消息，start为空时从头开始，用于查看或手动重放
+	Range(topic, start string, limit int) ([]BusMessage, error)
+	Close()
+}
+
+var (
+	defaultEventBus     EventBus
+	defaultEventBusOnce sync.Once
+	defaultEventBusMu   sync.RWMutex
+)
+
+// DeadLetterTopic 返回主题对应的死信主题
+func DeadLetterTopic(topic string) string {
+	return fmt.Sprintf("%s.dead", topic)
+}
+
+// NewEventBusFromConfig 按配置eventBus.driver创建事件总线，未配置时缓存使用Redis则使用Redis Streams，否则使用Bolt
+func NewEventBusFromConfig() EventBus {
+	driver := strings.ToLower(C().GetString("eventBus.driver"))
+	client := redisClientOf(DefaultCache)
+	if driver == "" {
+		if client != nil {
+			driver = EventBusDriverRedis
+		} else {
+			driver = EventBusDriverBolt
+		}
+	}
+	switch driver {
+	case EventBusDriverRedis:
+		if client == nil {
+			client = NewCacheRedis().client
+		}
+		return NewEventBusRedis(client)
+	case EventBusDriverBolt:
+		return NewEventBusBolt(C().GetString("eventBus.path"))
+	}
+	PANIC("unsupported event bus driver: %s", driver)
+	return nil
+}
+
+func redisClientOf(cache Cache) redis.UniversalClient {
+	switch v := cache.(type) {
+	case *CacheRedis:
+		return v.client
+	case *CacheTiered:
+		return redisClientOf(v.l2)
+	}
+	return nil
+}
+
+// GetEventBus 返回默认的事件总线，首次调用时按配置创建
+func GetEventBus() EventBus {
+	defaultEventBusOnce.Do(func() {
+		defaultEventBusMu.Lock()
+		defer defaultEventBusMu.Unlock()
+		if defaultEventBus == nil {
+			defaultEventBus = NewEventBusFromConfig()
+		}
+	})
+	defaultEventBusMu.RLock()
+	defer defaultEventBusMu.RUnlock()
+	return defaultEventBus
+}
+
+// SetEventBus 替换默认的事件总线
+func SetEventBus(bus EventBus) {
+	defaultEventBusMu.Lock()
+	defer defaultEventBusMu.Unlock()
+	defaultEventBus = bus
+}
+
+// PublishEvent
+func PublishEvent(topic string, payload interface{}) (string, error) {
+	return GetEventBus().Publish(topic, payload)
+}
+
+// SubscribeEvent
+func SubscribeEvent(topic, group string, handler BusHandler) error {
+	return GetEventBus().Subscribe(topic, group, handler)
+}
+
+func releaseEventBus() {
+	defaultEventBusMu.RLock()
+	defer defaultEventBusMu.RUnlock()
+	if defaultEventBus != nil {
+		defaultEventBus.Close()
+	}
+}
+
+func busPayload(payload interface{}) (string, error) {
+	switch v := payload.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	}
+	data, err := json.Marshal(payload)
+	return string(data), err
+}
+
+// 消费者名称，同一消费组内区分实例
+func busConsumerName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), strings.ReplaceAll(uuid.NewV4().String(), "-", "")[:8])
+}
+
+// 执行处理函数，捕获panic作为错误返回
+func handleBusMessage(handler BusHandler, msg *BusMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("event bus handler panic: %v", r)
+		}
+	}()
+	if err = handler(msg); err != nil {
+		ERROR("event bus message %s/%s failed (attempt %d): %v", msg.Topic, msg.ID, msg.Attempts, err)
+	}
+	return
+}
+
+// EventBusChangeSink 将数据变更事件在同一事务中写入发件箱，提交后由中继发布到事件总线，同一记录的变更按顺序发布
+type EventBusChangeSink struct {
+	Topic string
+}
+
+// WriteChanges
+func (s EventBusChangeSink) WriteChanges(tx *gorm.DB, events []DataChangeEvent) error {
+	topic := s.Topic
+	if topic == "" {
+		topic = ChangeEventTopic
+	}
+	for _, event := range events {
+		if err := EmitEvent(tx, DomainEvent{
+			Topic:     topic,
+			Aggregate: fmt.Sprintf("%s:%s", event.Model, event.PrimaryKey),
+			Payload:   event,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	busMessagesBucketName = []byte("messages")
+	busPendingBucketName  = []byte("pending")
+	busCursorKey          = []byte("cursor")
+)
+
+// EventBusBolt 基于Bolt的单机事件总线，每个主题一个桶，消费组保存消费位置和未确认的消息
+type EventBusBolt struct {
+	db          *bolt.DB
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	subscribers map[string][]chan struct{}
+}
+
+// NewEventBusBolt
+func NewEventBusBolt(path ...string) *EventBusBolt {
+	file := "eventbus.db"
+	if len(path) > 0 && path[0] != "" {
+		file = path[0]
+	}
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		FATAL(err)
+	}
+	return &EventBusBolt{
+		db:          db,
+		done:        make(chan struct{}),
+		subscribers: make(map[string][]chan struct{}),
+	}
+}
+
+func busTopicBucketName(topic string) []byte {
+	return []byte(fmt.Sprintf("topic_%s", topic))
+}
+
+func busGroupBucketName(group string) []byte {
+	return []byte(fmt.Sprintf("group_%s", group))
+}
+
+func busMessageID(seq uint64) string {
+	return strconv.FormatUint(seq, 10)
+}
+
+func parseBusMessageID(id string) (uint64, error) {
+	if id == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid event bus message id: %s", id)
+	}
+	return seq, nil
+}
+
+// 追加消息并裁剪超出EventBusMaxLen的旧消息
+func (b *EventBusBolt) append(tx *bolt.Tx, topic, payload string) (uint64, error) {
+	root, err := tx.CreateBucketIfNotExists(busTopicBucketName(topic))
+	if err != nil {
+		return 0, err
+	}
+	messages, err := root.CreateBucketIfNotExists(busMessagesBucketName)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := messages.NextSequence()
+	if err != nil {
+		return 0, err
+	}
+	if err := messages.Put(int64tob(int64(seq)), []byte(payload)); err != nil {
+		return 0, err
+	}
+	if EventBusMaxLen > 0 && seq > uint64(EventBusMaxLen) {
+		cutoff := seq - uint64(EventBusMaxLen)
+		cursor := messages.Cursor()
+		for k, _ := cursor.First(); k != nil && uint64(btoint64(k)) <= cutoff; k, _ = cursor.First() {
+			if err := messages.Delete(k); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return seq, nil
+}
+
+func (b *EventBusBolt) notify(topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subscribers[topic] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Publish
+func (b *EventBusBolt) Publish(topic string, payload interface{}) (string, error) {
+	value, err := busPayload(payload)
+	if err != nil {
+		return "", err
+	}
+	var seq uint64
+	err = b.db.Update(func(tx *bolt.Tx) (err error) {
+		seq, err = b.append(tx, topic, value)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+	b.notify(topic)
+	return busMessageID(seq), nil
+}
+
+// 返回消费组的桶，不存在时创建并从最新的消息开始消费
+func (b *EventBusBolt) group(tx *bolt.Tx, topic, group string) (*bolt.Bucket, error) {
+	root, err := tx.CreateBucketIfNotExists(busTopicBucketName(topic))
+	if err != nil {
+		return nil, err
+	}
+	messages, err := root.CreateBucketIfNotExists(busMessagesBucketName)
+	if err != nil {
+		return nil, err
+	}
+	name := busGroupBucketName(group)
+	if bucket := root.Bucket(name); bucket != nil {
+		return bucket, nil
+	}
+	bucket, err := root.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bucket.CreateBucketIfNotExists(busPendingBucketName); err != nil {
+		return nil, err
+	}
+	return bucket, bucket.Put(busCursorKey, int64tob(int64(messages.Sequence())))
+}
+
+// Subscribe
+func (b *EventBusBolt) Subscribe(topic, group string, handler BusHandler) error {
+	if err := b.db.Update(func(tx *bolt.Tx) error {
+		_, err := b.group(tx, topic, group)
+		return err
+	}); err != nil {
+		return err
+	}
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	b.subscribers[topic] = append(b.subscribers[topic], ch)
+	b.mu.Unlock()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for {
+			msgs, err := b.claim(topic, group)
+			if err != nil {
+				ERROR("event bus claim %s failed: %v", topic, err)
+			}
+			for _, msg := range msgs {
+				if handleBusMessage(handler, msg) != nil {
+					continue
+				}
+				if err := b.ack(topic, group, msg.ID); err != nil {
+					ERROR("event bus ack %s/%s failed: %v", topic, msg.ID, err)
+				}
+			}
+			if len(msgs) > 0 {
+				continue
+			}
+			select {
+			case <-b.done:
+				return
+			case <-ch:
+			case <-time.After(EventBusPollInterval):
+			}
+		}
+	}()
+	return nil
+}
+
+// 在同一事务内取出到期重试的消息和新消息并登记为未确认，投递次数用尽的消息转入死信主题
+func (b *EventBusBolt) claim(topic, group string) (msgs []*BusMessage, err error) {
+	var dead bool
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := b.group(tx, topic, group)
+		if err != nil {
+			return err
+		}
+		var (
+			messages = tx.Bucket(busTopicBucketName(topic)).Bucket(busMessagesBucketName)
+			pending  = bucket.Bucket(busPendingBucketName)
+			now      = time.Now()
+			nextAt   = int64tob(now.Add(EventBusRetryInterval).UnixNano())
+			expired  [][]byte
+			retries  [][]byte
+		)
+		cursor := pending.Cursor()
+		for k, v := cursor.First(); k != nil && len(msgs) < EventBusBatchSize; k, v = cursor.Next() {
+			attempts, at := btoint64(v[:8]), btoint64(v[8:])
+			if at > now.UnixNano() {
+				continue
+			}
+			payload := messages.Get(k)
+			if payload == nil || int(attempts) >= EventBusMaxRetries {
+				expired = append(expired, append([]byte(nil), k...))
+				if payload != nil {
+					if _, err := b.append(tx, DeadLetterTopic(topic), string(payload)); err != nil {
+						return err
+					}
+					WARN("event bus message %s/%d moved to %s", topic, btoint64(k), DeadLetterTopic(topic))
+					dead = true
+				}
+				continue
+			}
+			msgs = append(msgs, &BusMessage{
+				ID:       busMessageID(uint64(btoint64(k))),
+				Topic:    topic,
+				Payload:  string(payload),
+				Attempts: int(attempts) + 1,
+			})
+			retries = append(retries, append([]byte(nil), k...))
+		}
+		// 遍历结束后再修改，避免游标失效
+		for _, k := range expired {
+			if err := pending.Delete(k); err != nil {
+				return err
+			}
+		}
+		for i, k := range retries {
+			if err := pending.Put(k, append(int64tob(int64(msgs[i].Attempts)), nextAt...)); err != nil {
+				return err
+			}
+		}
+
+		last := bucket.Get(busCursorKey)
+		cursor = messages.Cursor()
+		for k, v := cursor.Seek(int64tob(btoint64(last) + 1)); k != nil && len(msgs) < EventBusBatchSize; k, v = cursor.Next() {
+			msgs = append(msgs, &BusMessage{
+				ID:       busMessageID(uint64(btoint64(k))),
+				Topic:    topic,
+				Payload:  string(v),
+				Attempts: 1,
+			})
+			if err := pending.Put(k, append(int64tob(1), nextAt...)); err != nil {
+				return err
+			}
+			last = k
+		}
+		return bucket.Put(busCursorKey, append([]byte(nil), last...))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dead {
+		b.notify(DeadLetterTopic(topic))
+	}
+	return
+}
+
+func (b *EventBusBolt) ack(topic, group, id string) error {
+	seq, err := parseBusMessageID(id)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := b.group(tx, topic, group)
+		if err != nil {
+			return err
+		}
+		return bucket.Bucket(busPendingBucketName).Delete(int64tob(int64(seq)))
+	})
+}
+
+// Replay
+func (b *EventBusBolt) Replay(topic, group, fromID string) error {
+	seq, err := parseBusMessageID(fromID)
+	if err != nil {
+		return err
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := b.group(tx, topic, group)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(busCursorKey, int64tob(int64(seq)))
+	})
+	if err == nil {
+		b.notify(topic)
+	}
+	return err
+}
+
+// Range
+func (b *EventBusBolt) Range(topic, start string, limit int) (values []BusMessage, err error) {
+	seq, err := parseBusMessageID(start)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = EventBusBatchSize
+	}
+	err = b.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(busTopicBucketName(topic))
+		if root == nil {
+			return nil
+		}
+		cursor := root.Bucket(busMessagesBucketName).Cursor()
+		for k, v := cursor.Seek(int64tob(int64(seq))); k != nil && len(values) < limit; k, v = cursor.Next() {
+			values = append(values, BusMessage{ID: busMessageID(uint64(btoint64(k))), Topic: topic, Payload: string(v)})
+		}
+		return nil
+	})
+	return
+}
+
+// Close
+func (b *EventBusBolt) Close() {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.wg.Wait()
+		if err := b.db.Close(); err != nil {
+			ERROR(err)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package kuu
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestEventBusBolt(t *testing.T) (*EventBusBolt, func()) {
+	dir, err := ioutil.TempDir("", "kuu-eventbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewEventBusBolt(filepath.Join(dir, "eventbus.db"))
+	return b, func() {
+		b.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func setTestEventBusIntervals() func() {
+	retry, poll := EventBusRetryInterval, EventBusPollInterval
+	EventBusRetryInterval, EventBusPollInterval = 10*time.Millisecond, 10*time.Millisecond
+	return func() {
+		EventBusRetryInterval, EventBusPollInterval = retry, poll
+	}
+}
+
+type testBusRecorder struct {
+	mu       sync.Mutex
+	payloads []string
+	ch       chan *BusMessage
+}
+
+func newTestBusRecorder() *testBusRecorder {
+	return &testBusRecorder{ch: make(chan *BusMessage, 100)}
+}
+
+func (r *testBusRecorder) handler(fail func(*BusMessage) bool) BusHandler {
+	return func(msg *BusMessage) error {
+		defer func() { r.ch <- msg }()
+		if fail != nil && fail(msg) {
+			return errors.New("failed")
+		}
+		r.mu.Lock()
+		r.payloads = append(r.payloads, msg.Payload)
+		r.mu.Unlock()
+		return nil
+	}
+}
+
+func (r *testBusRecorder) wait(t *testing.T, n int) []*BusMessage {
+	var msgs []*BusMessage
+	for len(msgs) < n {
+		select {
+		case msg := <-r.ch:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %d messages, got %d", n, len(msgs))
+		}
+	}
+	return msgs
+}
+
+func TestEventBusBoltGroups(t *testing.T) {
+	defer setTestEventBusIntervals()()
+	b, cleanup := newTestEventBusBolt(t)
+	defer cleanup()
+
+	// 订阅前发布的消息不投递给新的消费组
+	if _, err := b.Publish("orders", "old"); err != nil {
+		t.Fatal(err)
+	}
+	billing, shipping := newTestBusRecorder(), newTestBusRecorder()
+	if err := b.Subscribe("orders", "billing", billing.handler(nil)); err != nil {
+		t.Fatal(err)
+	}
+	// 同一消费组的两个订阅者，每条消息只处理一次
+	for i := 0; i < 2; i++ {
+		if err := b.Subscribe("orders", "shipping", shipping.handler(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Publish("orders", "a")
+	b.Publish("orders", struct{ ID string }{"b"})
+
+	billing.wait(t, 2)
+	shipping.wait(t, 2)
+	time.Sleep(50 * time.Millisecond)
+	if len(shipping.ch) != 0 {
+		t.Error("message delivered twice within a group")
+	}
+	if !reflect.DeepEqual(billing.payloads, []string{"a", `{"ID":"b"}`}) {
+		t.Errorf("unexpected payloads: %v", billing.payloads)
+	}
+}
+
+func TestEventBusBoltRetry(t *testing.T) {
+	defer setTestEventBusIntervals()()
+	b, cleanup := newTestEventBusBolt(t)
+	defer cleanup()
+
+	r := newTestBusRecorder()
+	b.Subscribe("orders", "billing", r.handler(func(msg *BusMessage) bool {
+		return msg.Payload == "dead" || msg.Attempts < 2
+	}))
+	b.Publish("orders", "retry")
+	b.Publish("orders", "dead")
+
+	msgs := r.wait(t, 2+EventBusMaxRetries)
+	var deadAttempts []int
+	for _, msg := range msgs {
+		if msg.Payload == "dead" {
+			deadAttempts = append(deadAttempts, msg.Attempts)
+		}
+	}
+	if len(deadAttempts) != EventBusMaxRetries || deadAttempts[len(deadAttempts)-1] != EventBusMaxRetries {
+		t.Errorf("unexpected attempts: %v", deadAttempts)
+	}
+
+	var dead []BusMessage
+	for i := 0; i < 100 && len(dead) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		dead, _ = b.Range(DeadLetterTopic("orders"), "", 10)
+	}
+	if len(dead) != 1 || dead[0].Payload != "dead" {
+		t.Errorf("unexpected dead letters: %+v", dead)
+	}
+	if !reflect.DeepEqual(r.payloads, []string{"retry"}) {
+		t.Errorf("unexpected payloads: %v", r.payloads)
+	}
+}
+
+func TestEventBusBoltReplay(t *testing.T) {
+	defer setTestEventBusIntervals()()
+	b, cleanup := newTestEventBusBolt(t)
+	defer cleanup()
+
+	var ids []string
+	for _, payload := range []string{"a", "b", "c"} {
+		id, _ := b.Publish("orders", payload)
+		ids = append(ids, id)
+	}
+	if msgs, _ := b.Range("orders", ids[1], 10); len(msgs) != 2 || msgs[0].Payload != "b" {
+		t.Errorf("unexpected range: %+v", msgs)
+	}
+
+	if err := b.Replay("orders", "billing", ids[0]); err != nil {
+		t.Fatal(err)
+	}
+	r := newTestBusRecorder()
+	b.Subscribe("orders", "billing", r.handler(nil))
+	r.wait(t, 2)
+	if !reflect.DeepEqual(r.payloads, []string{"b", "c"}) {
+		t.Errorf("unexpected payloads: %v", r.payloads)
+	}
+
+	b.Replay("orders", "billing", "0")
+	r.wait(t, 3)
+	if err := b.Replay("orders", "billing", "x"); err == nil {
+		t.Error("expected invalid id error")
+	}
+}
@@ -0,0 +1,217 @@
+package kuu
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventBusRedis 基于Redis Streams和消费组的事件总线
+type EventBusRedis struct {
+	client   redis.UniversalClient
+	consumer string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewEventBusRedis
+func NewEventBusRedis(client redis.UniversalClient) *EventBusRedis {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EventBusRedis{
+		client:   client,
+		consumer: busConsumerName(),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (b *EventBusRedis) stream(topic string) string {
+	return BuildKey("bus", topic)
+}
+
+// Publish
+func (b *EventBusRedis) Publish(topic string, payload interface{}) (string, error) {
+	value, err := busPayload(payload)
+	if err != nil {
+		return "", err
+	}
+	return b.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream:       b.stream(topic),
+		MaxLenApprox: EventBusMaxLen,
+		Values:       map[string]interface{}{"payload": value},
+	}).Result()
+}
+
+func (b *EventBusRedis) createGroup(stream, group string) error {
+	err := b.client.XGroupCreateMkStream(context.Background(), stream, group, "$").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// Subscribe
+func (b *EventBusRedis) Subscribe(topic, group string, handler BusHandler) error {
+	stream := b.stream(topic)
+	if err := b.createGroup(stream, group); err != nil {
+		return err
+	}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.consume(topic, stream, group, handler)
+	}()
+	return nil
+}
+
+func (b *EventBusRedis) consume(topic, stream, group string, handler BusHandler) {
+	for b.ctx.Err() == nil {
+		b.reclaim(topic, stream, group, handler)
+		res, err := b.client.XReadGroup(b.ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: b.consumer,
+			Streams:  []string{stream, ">"},
+			Count:    int64(EventBusBatchSize),
+			Block:    EventBusPollInterval,
+		}).Result()
+		if err != nil {
+			if err != redis.Nil && b.ctx.Err() == nil {
+				ERROR("event bus read %s failed: %v", topic, err)
+				b.sleep(EventBusPollInterval)
+			}
+			continue
+		}
+		for _, s := range res {
+			for _, msg := range s.Messages {
+				b.deliver(topic, stream, group, msg, 1, handler)
+			}
+		}
+	}
+}
+
+func (b *EventBusRedis) sleep(d time.Duration) {
+	select {
+	case <-b.ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+func (b *EventBusRedis) deliver(topic, stream, group string, msg redis.XMessage, attempts int, handler BusHandler) {
+	payload, _ := msg.Values["payload"].(string)
+	if err := handleBusMessage(handler, &BusMessage{ID: msg.ID, Topic: topic, Payload: payload, Attempts: attempts}); err != nil {
+		return
+	}
+	if err := b.client.XAck(context.Background(), stream, group, msg.ID).Err(); err != nil {
+		ERROR("event bus ack %s/%s failed: %v", topic, msg.ID, err)
+	}
+}
+
+// 认领超时未确认的消息重新投递，投递次数用尽的消息转入死信主题
+func (b *EventBusRedis) reclaim(topic, stream, group string, handler BusHandler) {
+	ctx := context.Background()
+	pending, err := b.client.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(EventBusBatchSize),
+	}).Result()
+	if err != nil {
+		if err != redis.Nil {
+			ERROR("event bus pending %s failed: %v", topic, err)
+		}
+		return
+	}
+	for _, p := range pending {
+		if p.Idle < EventBusRetryInterval {
+			continue
+		}
+		msgs, err := b.client.XClaim(ctx, &redis.XClaimArgs{
+			Stream:   stream,
+			Group:    group,
+			Consumer: b.consumer,
+			MinIdle:  EventBusRetryInterval,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			ERROR("event bus claim %s/%s failed: %v", topic, p.ID, err)
+			continue
+		}
+		if len(msgs) == 0 {
+			// 已被其他消费者认领
+			continue
+		}
+		msg := msgs[0]
+		if msg.Values == nil {
+			// 消息已被裁剪
+			if err := b.client.XAck(ctx, stream, group, p.ID).Err(); err != nil {
+				ERROR("event bus ack %s/%s failed: %v", topic, p.ID, err)
+			}
+			continue
+		}
+		if int(p.RetryCount) >= EventBusMaxRetries {
+			b.deadLetter(topic, stream, group, msg)
+			continue
+		}
+		b.deliver(topic, stream, group, msg, int(p.RetryCount)+1, handler)
+	}
+}
+
+func (b *EventBusRedis) deadLetter(topic, stream, group string, msg redis.XMessage) {
+	payload, _ := msg.Values["payload"].(string)
+	if _, err := b.client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream:       b.stream(DeadLetterTopic(topic)),
+		MaxLenApprox: EventBusMaxLen,
+		Values: map[string]interface{}{
+			"payload": payload,
+			"id":      msg.ID,
+			"group":   group,
+		},
+	}).Result(); err != nil {
+		ERROR("event bus dead letter %s/%s failed: %v", topic, msg.ID, err)
+		return
+	}
+	WARN("event bus message %s/%s moved to %s", topic, msg.ID, DeadLetterTopic(topic))
+	if err := b.client.XAck(context.Background(), stream, group, msg.ID).Err(); err != nil {
+		ERROR("event bus ack %s/%s failed: %v", topic, msg.ID, err)
+	}
+}
+
+// Replay
+func (b *EventBusRedis) Replay(topic, group, fromID string) error {
+	stream := b.stream(topic)
+	if err := b.createGroup(stream, group); err != nil {
+		return err
+	}
+	return b.client.XGroupSetID(context.Background(), stream, group, fromID).Err()
+}
+
+// Range
+func (b *EventBusRedis) Range(topic, start string, limit int) ([]BusMessage, error) {
+	if start == "" {
+		start = "-"
+	}
+	if limit <= 0 {
+		limit = EventBusBatchSize
+	}
+	msgs, err := b.client.XRangeN(context.Background(), b.stream(topic), start, "+", int64(limit)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("event bus range %s failed: %v", topic, err)
+	}
+	values := make([]BusMessage, len(msgs))
+	for i, msg := range msgs {
+		payload, _ := msg.Values["payload"].(string)
+		values[i] = BusMessage{ID: msg.ID, Topic: topic, Payload: payload}
+	}
+	return values, nil
+}
+
+// Close 停止消费，连接由缓存负责关闭
+func (b *EventBusRedis) Close() {
+	b.cancel()
+	b.wg.Wait()
+}
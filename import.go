@@ -8,10 +8,18 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var importCallbackMap = make(map[string]*ImportCallback)
 
+const importLockContextKey = "__kuu_import_lock__"
+
+type importLock struct {
+	lock *CacheLock
+	lost <-chan struct{}
+}
+
 // ImportCallbackResult
 type ImportCallbackResult struct {
 	Feedback []ImportFeedback
@@ -50,6 +58,7 @@ type ImportCallback struct {
 	TemplateGenerator func(*Context) (string, []string)
 	Validator         ImportCallbackValidator
 	Processor         ImportCallbackProcessor
+	LockTTL           time.Duration // 大于0时同一渠道的导入在多个实例间串行执行，处理期间自动续期
 }
 
 type ImportFeedback struct {
@@ -59,17 +68,18 @@ type ImportFeedback struct {
 
 // ImportRecord
 type ImportRecord struct {
-	Model    `rest:"*" displayName:"导入记录"`
-	Sync     bool   `name:"是否同步执行"`
-	ImportSn string `name:"批次编号" sql:"index"`
-	Context  string `name:"导入时上下文数据" gorm:"type:text"`
-	Channel  string `name:"导入渠道"`
-	Data     string `name:"导入数据（[][]string）" gorm:"type:text"`
-	Feedback string `name:"反馈记录（[]string）" gorm:"type:text"`
-	Status   string `name:"导入状态" enum:"ImportStatus"`
-	Message  string `name:"导入结果"`
-	Error    string `name:"错误详情"`
-	Extra    string `name:"额外数据（JSON）" gorm:"type:text"`
+	Model     `rest:"*" displayName:"导入记录"`
+	Sync      bool   `name:"是否同步执行"`
+	ImportSn  string `name:"批次编号" sql:"index"`
+	Context   string `name:"导入时上下文数据" gorm:"type:text"`
+	Channel   string `name:"导入渠道"`
+	Data      string `name:"导入数据（[][]string）" gorm:"type:text"`
+	Feedback  string `name:"反馈记录（[]string）" gorm:"type:text"`
+	Status    string `name:"导入状态" enum:"ImportStatus"`
+	Message   string `name:"导入结果"`
+	Error     string `name:"错误详情"`
+	Extra     string `name:"额外数据（JSON）" gorm:"type:text"`
+	LockToken int64  `name:"导入锁防护令牌"`
 }
 
 // BeforeCreate
@@ -93,6 +103,26 @@ func RegisterImportCallback(callback *ImportCallback) {
 	importCallbackMap[callback.Channel] = callback
 }
 
+// ImportLockToken 返回当前导入持有的锁的防护令牌，写入下游时携带以拒绝过期持有者的请求，未加锁时返回0
+func ImportLockToken(c *Context) int64 {
+	if v, ok := c.Get(importLockContextKey); ok {
+		return v.(*importLock).lock.Token
+	}
+	return 0
+}
+
+// ImportLockLost 判断当前导入持有的锁是否已丢失，耗时较长的处理应定期检查并及时停止
+func ImportLockLost(c *Context) bool {
+	if v, ok := c.Get(importLockContextKey); ok {
+		select {
+		case <-v.(*importLock).lost:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
 // CallImportCallback
 func CallImportCallback(c *Context, info *ImportRecord) {
 	if info == nil {
@@ -111,12 +141,50 @@ func CallImportCallback(c *Context, info *ImportRecord) {
 		return
 	}
 	args := callback
-	result := args.Processor(c, rows)
+	var (
+		result    *ImportCallbackResult
+		lockToken int64
+	)
+	if args.LockTTL > 0 {
+		var processed bool
+		err := WithLock(fmt.Sprintf("import_%s", info.Channel), args.LockTTL, func(l *CacheLock) error {
+			lost, stop := l.KeepAlive(args.LockTTL)
+			defer stop()
+			lockToken = l.Token
+			if c != nil {
+				c.Set(importLockContextKey, &importLock{lock: l, lost: lost})
+			}
+			result = args.Processor(c, rows)
+			processed = true
+			select {
+			case <-lost:
+				return ErrLockNotHeld
+			default:
+			}
+			return l.Extend(args.LockTTL)
+		})
+		if err != nil {
+			if !processed {
+				result = &ImportCallbackResult{Message: "failed", Error: err}
+			} else {
+				// 处理期间锁已丢失，其他实例可能同时执行了该渠道的导入
+				WARN("import lock lost: channel=%s, token=%d, error=%v", info.Channel, lockToken, err)
+				if result == nil {
+					result = &ImportCallbackResult{Message: "failed"}
+				}
+				if result.Error == nil {
+					result.Error = fmt.Errorf("import lock lost before finish: %v", err)
+				}
+			}
+		}
+	} else {
+		result = args.Processor(c, rows)
+	}
 	if result == nil {
 		result = &ImportCallbackResult{Message: "success"}
 	}
 	db := DB().Model(&ImportRecord{}).Where(&ImportRecord{Model: Model{ID: info.ID}})
-	doc := ImportRecord{Message: result.Message}
+	doc := ImportRecord{Message: result.Message, LockToken: lockToken}
 	if result.Error != nil {
 		doc.Error = result.Error.Error()
 		doc.Status = ImportStatusFailed
@@ -0,0 +1,46 @@
+package kuu
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCallImportCallbackKeepsLock(t *testing.T) {
+	defer useTestDB(t, &ImportRecord{})()
+
+	var (
+		token int64
+		held  error
+	)
+	RegisterImportCallback(&ImportCallback{
+		Channel: "test_keepalive",
+		LockTTL: 60 * time.Millisecond,
+		Processor: func(c *Context, rows [][]string) *ImportCallbackResult {
+			time.Sleep(200 * time.Millisecond)
+			token = ImportLockToken(c)
+			_, held = TryLock("import_test_keepalive", time.Minute)
+			if ImportLockLost(c) {
+				t.Error("lock reported lost while held")
+			}
+			return nil
+		},
+	})
+	defer delete(importCallbackMap, "test_keepalive")
+
+	record := ImportRecord{Channel: "test_keepalive", Data: "[]"}
+	if err := DB().Create(&record).Error; err != nil {
+		t.Fatal(err)
+	}
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	CallImportCallback(&Context{Context: ginCtx}, &record)
+	if held != ErrLockNotAcquired || token == 0 {
+		t.Fatalf("lock not kept during processing: %v %d", held, token)
+	}
+	var saved ImportRecord
+	DB().First(&saved, record.ID)
+	if saved.Status != ImportStatusSuccess || saved.LockToken != token {
+		t.Errorf("unexpected record: %s %d", saved.Status, saved.LockToken)
+	}
+}
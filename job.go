@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // DefaultCron (set option 5 cron to convet 6 cron)
@@ -41,15 +42,18 @@ type Job struct {
 	Code        string              `json:"code"`
 	Name        string              `json:"name" valid:"required"`
 	RunAfterAdd bool                `json:"runAfterAdd"`
+	LockTTL     time.Duration       `json:"lockTTL"` // 大于0时多个任务实例间互斥执行，执行期间自动续期
 	EntryID     cron.EntryID        `json:"entryID,omitempty"`
 	cmd         func()
 }
 
 // JobContext
 type JobContext struct {
-	name string
-	errs []error
-	l    *sync.RWMutex
+	name      string
+	errs      []error
+	l         *sync.RWMutex
+	lockToken int64
+	lockLost  <-chan struct{}
 }
 
 func (j *Job) NewJobContext() *JobContext {
@@ -70,6 +74,21 @@ func (c *JobContext) Name() string {
 	return c.name
 }
 
+// LockToken 返回任务锁的防护令牌，写入下游时携带以拒绝过期持有者的请求，未加锁时返回0
+func (c *JobContext) LockToken() int64 {
+	return c.lockToken
+}
+
+// LockLost 判断任务锁是否已丢失，耗时较长的任务应定期检查并及时停止
+func (c *JobContext) LockLost() bool {
+	select {
+	case <-c.lockLost:
+		return true
+	default:
+		return false
+	}
+}
+
 // AddJobEntry
 func AddJobEntry(j *Job) error {
 	jobsMu.Lock()
@@ -96,11 +115,34 @@ func AddJobEntry(j *Job) error {
 		if runningJobs[j.EntryID] {
 			return
 		}
+		c := j.NewJobContext()
+		if j.LockTTL > 0 {
+			l, err := TryLock(fmt.Sprintf("job_%s", j.Name), j.LockTTL)
+			if err != nil {
+				if err == ErrLockNotAcquired {
+					INFO("Job '%s' is running on another instance, skipped.", j.Name)
+				} else {
+					ERROR(err)
+				}
+				return
+			}
+			defer func() {
+				if err := l.Unlock(); err != nil {
+					WARN("Job '%s' lock released with error: %v", j.Name, err)
+				}
+			}()
+			lost, stop := l.KeepAlive(j.LockTTL)
+			defer stop()
+			c.lockToken = l.Token
+			c.lockLost = lost
+		}
 		runningJobs[j.EntryID] = true
 		INFO("----------- Job '%s' start -----------", j.Name)
 
-		c := j.NewJobContext()
 		j.Cmd(c)
+		if c.LockLost() {
+			c.Error(fmt.Errorf("lock lost before finish, token=%d", c.lockToken))
+		}
 		if len(c.errs) > 0 {
 			for i, err := range c.errs {
 				c.errs[i] = errors.Wrap(err, fmt.Sprintf("Job '%s' execute error", j.Name))
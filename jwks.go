@@ -0,0 +1,161 @@
+package kuu
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// JWK
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Find
+func (s *JWKS) Find(kid string) *JWK {
+	for i, key := range s.Keys {
+		if kid == "" || key.Kid == kid {
+			return &s.Keys[i]
+		}
+	}
+	return nil
+}
+
+func jwkBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func jwkEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// PublicKey 转换为*rsa.PublicKey或*ecdsa.PublicKey
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := jwkBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := jwkBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := jwkBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := jwkBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+}
+
+// NewJWK 根据公钥生成JWK
+func NewJWK(kid, alg string, pub crypto.PublicKey) (*JWK, error) {
+	switch v := pub.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			N:   jwkEncode(v.N.Bytes()),
+			E:   jwkEncode(big.NewInt(int64(v.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (v.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		xb, yb := v.X.Bytes(), v.Y.Bytes()
+		copy(x[size-len(xb):], xb)
+		copy(y[size-len(yb):], yb)
+		return &JWK{
+			Kty: "EC",
+			Kid: kid,
+			Use: "sig",
+			Alg: alg,
+			Crv: v.Curve.Params().Name,
+			X:   jwkEncode(x),
+			Y:   jwkEncode(y),
+		}, nil
+	}
+	return nil, errors.New("unsupported public key")
+}
+
+type jwksCacheItem struct {
+	set       *JWKS
+	fetchedAt time.Time
+}
+
+var (
+	jwksCache sync.Map
+	// JWKSCacheExpires 远程JWKS的缓存时间
+	JWKSCacheExpires = time.Hour
+)
+
+// FetchJWKS 获取远程JWKS，force为true时忽略缓存（用于密钥轮换后查找新kid）
+func FetchJWKS(client *http.Client, uri string, force ...bool) (*JWKS, error) {
+	if len(force) == 0 || !force[0] {
+		if v, ok := jwksCache.Load(uri); ok {
+			item := v.(*jwksCacheItem)
+			if time.Since(item.fetchedAt) < JWKSCacheExpires {
+				return item.set, nil
+			}
+		}
+	}
+	resp, err := client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks failed: %s", resp.Status)
+	}
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+	jwksCache.Store(uri, &jwksCacheItem{set: &set, fetchedAt: time.Now()})
+	return &set, nil
+}
@@ -107,12 +107,13 @@ func beforeRun() {
 	}
 	DefaultCron.Start()
 	RunAllRunAfterJobs()
+	startOutboxRelay()
 }
 
 func shutdown(srv *http.Server) {
 	// Wait for interrupt signal to gracefully shutdown the server with
 	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
 	// kill -9 is syscall.SIGKILL but can"t be catch, so don't need add it
@@ -199,6 +200,7 @@ func (app *Engine) convertHandlers(chain HandlersChain, isMiddleware ...bool) (h
 					desc := GetPrivilegesDesc(sc)
 					kc.PrisDesc = desc
 					kc.SignInfo = sc
+					c.Set(prisDescContextKey, desc)
 				}
 				glsVals := make(gls.Values)
 				glsVals[GLSSignInfoKey] = kc.SignInfo
@@ -454,6 +456,9 @@ func GetAppName() string {
 
 // Release
 func Release() {
+	flushEventLogs()
+	releaseOutboxRelay()
 	releaseDB()
+	releaseEventBus()
 	releaseCacheDB()
 }
@@ -0,0 +1,180 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrLockNotAcquired 锁已被其他持有者占用
+	ErrLockNotAcquired = errors.New("lock not acquired")
+	// ErrLockNotHeld 锁已过期或已被其他持有者获取
+	ErrLockNotHeld = errors.New("lock not held")
+	// LockWaitTimeout Lock的默认等待时间
+	LockWaitTimeout = 10 * time.Second
+	// LockRetryInterval 等待锁时的重试间隔
+	LockRetryInterval = 50 * time.Millisecond
+)
+
+// CacheLocker 支持原子比较后删除或续期的缓存，用于实现分布式锁
+type CacheLocker interface {
+	StructuredCache
+	CompareAndDel(key, val string) bool
+	CompareAndExpire(key, val string, expiration time.Duration) bool
+}
+
+// CacheLock 基于缓存的分布式锁，Token为单调递增的防护令牌，可随写入传给下游以拒绝过期持有者的请求
+type CacheLock struct {
+	Key   string
+	Token int64
+	value string
+	cache CacheLocker
+}
+
+func lockCache() (CacheLocker, error) {
+	if v, ok := DefaultCache.(CacheLocker); ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("cache does not support locks: %T", DefaultCache)
+}
+
+func lockCacheKey(key string) string {
+	return fmt.Sprintf("lock_%s", key)
+}
+
+// TryLock 尝试获取锁，已被占用时返回ErrLockNotAcquired
+func TryLock(key string, ttl time.Duration) (*CacheLock, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("lock ttl must be positive: %v", ttl)
+	}
+	cache, err := lockCache()
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := lockCacheKey(key)
+	// 防护令牌不设置过期时间，保证同一个键的令牌始终递增
+	token := int64(cache.IncrBy(fmt.Sprintf("%s_fence", cacheKey), 1))
+	value := fmt.Sprintf("%s:%d", strings.ReplaceAll(uuid.NewV4().String(), "-", ""), token)
+	if !cache.SetNX(cacheKey, value, ttl) {
+		return nil, ErrLockNotAcquired
+	}
+	return &CacheLock{Key: key, Token: token, value: value, cache: cache}, nil
+}
+
+// Lock 获取锁，已被占用时按LockRetryInterval重试，超过等待时间（默认LockWaitTimeout）后返回ErrLockNotAcquired
+func Lock(key string, ttl time.Duration, wait ...time.Duration) (*CacheLock, error) {
+	timeout := LockWaitTimeout
+	if len(wait) > 0 {
+		timeout = wait[0]
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := TryLock(key, ttl)
+		if err != ErrLockNotAcquired || !time.Now().Before(deadline) {
+			return l, err
+		}
+		time.Sleep(LockRetryInterval)
+	}
+}
+
+// Unlock 释放锁，锁已过期或被其他持有者获取时返回ErrLockNotHeld
+func (l *CacheLock) Unlock() error {
+	if !l.cache.CompareAndDel(lockCacheKey(l.Key), l.value) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Extend 将锁的过期时间重置为ttl
+func (l *CacheLock) Extend(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("lock ttl must be positive: %v", ttl)
+	}
+	if !l.cache.CompareAndExpire(lockCacheKey(l.Key), l.value, ttl) {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// KeepAlive 在后台每隔ttl/3续期锁，直到调用stop；续期时发现锁已丢失则关闭lost并停止续期
+func (l *CacheLock) KeepAlive(ttl time.Duration) (lost <-chan struct{}, stop func()) {
+	var (
+		lostCh = make(chan struct{})
+		done   = make(chan struct{})
+		once   sync.Once
+		exited = make(chan struct{})
+	)
+	interval := ttl / 3
+	if interval <= 0 {
+		interval = ttl
+	}
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Extend(ttl); err != nil {
+					close(lostCh)
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return lostCh, func() {
+		once.Do(func() { close(done) })
+		<-exited
+	}
+}
+
+// WithLock 持有锁执行fn
+func WithLock(key string, ttl time.Duration, fn func(*CacheLock) error) (err error) {
+	l, err := Lock(key, ttl)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := l.Unlock(); e != nil && err == nil {
+			err = e
+		}
+	}()
+	return fn(l)
+}
+
+// WithLockedTransaction 持有锁执行事务，提交前续期锁，锁已失效时回滚
+func WithLockedTransaction(key string, ttl time.Duration, fn func(*gorm.DB, *CacheLock) error) error {
+	return WithLock(key, ttl, func(l *CacheLock) error {
+		return WithTransaction(func(tx *gorm.DB) error {
+			if err := fn(tx, l); err != nil {
+				return err
+			}
+			return l.Extend(ttl)
+		})
+	})
+}
+
+// 以下为Bolt和内存缓存共用的实现
+
+func cacheCompareAndDel(u cacheUpdater, key, val string) (ok bool) {
+	u.update(key, func(old []byte, exists bool) ([]byte, bool, time.Duration) {
+		ok = exists && string(old) == val
+		return nil, ok, 0
+	})
+	return
+}
+
+func cacheCompareAndExpire(u cacheUpdater, key, val string, expiration time.Duration) (ok bool) {
+	u.update(key, func(old []byte, exists bool) ([]byte, bool, time.Duration) {
+		ok = exists && string(old) == val && expiration > 0
+		return old, ok, expiration
+	})
+	return
+}
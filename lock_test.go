@@ -0,0 +1,125 @@
+package kuu
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTryLock(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	l1, err := TryLock("import", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryLock("import", time.Minute); err != ErrLockNotAcquired {
+		t.Fatalf("expected ErrLockNotAcquired, got %v", err)
+	}
+	if err := l1.Extend(time.Minute); err != nil {
+		t.Error(err)
+	}
+	if err := l1.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l1.Unlock(); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+
+	l2, err := TryLock("import", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l2.Token <= l1.Token {
+		t.Errorf("fencing token not increased: %d <= %d", l2.Token, l1.Token)
+	}
+	time.Sleep(30 * time.Millisecond)
+	l3, err := TryLock("import", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 过期后的持有者不能释放或续期新持有者的锁
+	if err := l2.Extend(time.Minute); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if err := l2.Unlock(); err != ErrLockNotHeld {
+		t.Errorf("expected ErrLockNotHeld, got %v", err)
+	}
+	if err := l3.Unlock(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWithLock(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		running int
+		maxRun  int
+	)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := WithLock("sn", time.Second, func(l *CacheLock) error {
+				mu.Lock()
+				running++
+				if running > maxRun {
+					maxRun = running
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRun != 1 {
+		t.Errorf("expected mutual exclusion, got %d concurrent holders", maxRun)
+	}
+	if _, err := Lock("sn", time.Second, 0); err != nil {
+		t.Errorf("lock not released: %v", err)
+	}
+}
+
+func TestCacheLockKeepAlive(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	l, err := TryLock("keepalive", 60*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lost, stop := l.KeepAlive(60 * time.Millisecond)
+	defer stop()
+	time.Sleep(200 * time.Millisecond)
+	if _, err := TryLock("keepalive", time.Minute); err != ErrLockNotAcquired {
+		t.Fatalf("expected the lock to be kept alive, got %v", err)
+	}
+	select {
+	case <-lost:
+		t.Fatal("lock reported lost while held")
+	default:
+	}
+	// 锁被其他持有者获取后停止续期
+	DelCache(lockCacheKey("keepalive"))
+	other, err := TryLock("keepalive", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("lost lock not reported")
+	}
+	if err := other.Unlock(); err != nil {
+		t.Errorf("new holder's lock was changed: %v", err)
+	}
+}
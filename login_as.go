@@ -0,0 +1,134 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LoginAsPermission 模拟登录的权限编码
+const LoginAsPermission = "sys_login_as"
+
+// LoginAsMaxMinutes 模拟登录令牌的默认最长有效期（分钟）
+var LoginAsMaxMinutes = 60
+
+// CanLoginAs 判断当前用户是否可以模拟登录
+func CanLoginAs(c *Context) bool {
+	if c.SignInfo == nil {
+		return false
+	}
+	return c.HasPermission(LoginAsPermission)
+}
+
+// LoginAs 为指定用户签发模拟登录令牌，令牌记录实际操作人且到期后不可刷新
+func LoginAs(c *Context, uid uint, reason string, minutes int) (*SignSecret, error) {
+	if !isUserSign(c) {
+		return nil, errors.New("impersonation requires a user token")
+	}
+	if uid == 0 || uid == c.SignInfo.UID {
+		return nil, fmt.Errorf("invalid impersonation target: %d", uid)
+	}
+	if uid == RootUID() && c.SignInfo.UID != RootUID() {
+		return nil, errors.New("root can not be impersonated")
+	}
+	if reason == "" && C().GetBool("loginAs.requireReason") {
+		return nil, errors.New("impersonation reason is required")
+	}
+	max := C().DefaultGetInt("loginAs.maxMinutes", LoginAsMaxMinutes)
+	if minutes <= 0 || minutes > max {
+		minutes = max
+	}
+	var user User
+	if err := DB().Where("id = ?", uid).First(&user).Error; err != nil {
+		return nil, err
+	}
+	if user.Disable.Bool || user.DenyLogin.Bool {
+		return nil, fmt.Errorf("account deny login: %v", user.ID)
+	}
+	// 非root用户只能模拟权限不超过自己的用户
+	if c.SignInfo.UID != RootUID() && !privilegesSubset(GetPrivilegesDesc(user.ID), c.PrisDesc) {
+		return nil, fmt.Errorf("impersonation target has privileges beyond the operator: %d", uid)
+	}
+	payload := userPayload(c, &user)
+	payload["ImpersonatorID"] = c.SignInfo.UID
+	payload["ImpersonatorUsername"] = c.SignInfo.Username
+	secretData, err := GenToken(GenTokenDesc{
+		UID:            user.ID,
+		Username:       user.Username,
+		Exp:            time.Now().Add(time.Duration(minutes) * time.Minute).Unix(),
+		Type:           AdminSignType,
+		Desc:           fmt.Sprintf("Impersonated by %s", c.SignInfo.Username),
+		Payload:        payload,
+		impersonatorID: c.SignInfo.UID,
+		reason:         reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	addImpersonationEventLog(c, secretData, "Impersonation started",
+		fmt.Sprintf("%s signed in as %s for %d minutes: %s", c.SignInfo.Username, user.Username, minutes, reason), D{
+			"SecretID": secretData.ID,
+			"Reason":   reason,
+			"Exp":      secretData.Exp,
+		})
+	return secretData, nil
+}
+
+// 判断sub的操作权限和数据权限是否均包含在super中
+func privilegesSubset(sub, super *PrivilegesDesc) bool {
+	if sub == nil || super == nil {
+		return false
+	}
+	for code := range sub.PermissionMap {
+		if _, has := super.PermissionMap[code]; !has {
+			return false
+		}
+	}
+	for _, pair := range [][2]map[uint]Org{
+		{sub.ReadableOrgIDMap, super.ReadableOrgIDMap},
+		{sub.WritableOrgIDMap, super.WritableOrgIDMap},
+		{sub.LoginableOrgIDMap, super.LoginableOrgIDMap},
+	} {
+		for orgID := range pair[0] {
+			if _, has := pair[1][orgID]; !has {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func addImpersonationEventLog(c *Context, secret *SignSecret, subject, summary string, data D) {
+	data["ImpersonatorID"] = secret.ImpersonatorID
+	data["UID"] = secret.UID
+	data["Username"] = secret.Username
+	if _, err := AddEventLog(EventLogDesc{
+		Class:    EventLogClassImpersonation,
+		Subject:  subject,
+		Summary:  summary,
+		Data:     data,
+		UID:      secret.ImpersonatorID,
+		SourceIP: c.ClientIP(),
+		Labels: map[string]string{
+			"ImpersonatorID": fmt.Sprintf("%d", secret.ImpersonatorID),
+			"UID":            fmt.Sprintf("%d", secret.UID),
+		},
+	}); err != nil {
+		ERROR(err)
+	}
+}
+
+// 记录模拟登录期间的每个请求
+func logImpersonatedRequest(c *Context, sign *SignContext) {
+	if sign == nil || sign.Secret == nil || sign.Secret.ImpersonatorID == 0 {
+		return
+	}
+	addImpersonationEventLog(c, sign.Secret, "Impersonated request",
+		fmt.Sprintf("%s %s", c.Request.Method, c.Request.URL.Path), D{
+			"SecretID": sign.Secret.ID,
+			"Method":   c.Request.Method,
+			"Path":     c.Request.URL.Path,
+			"Query":    c.Request.URL.RawQuery,
+			"Status":   c.Writer.Status(),
+		})
+}
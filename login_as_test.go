@@ -0,0 +1,74 @@
+package kuu
+
+import (
+	"github.com/gin-gonic/gin"
+	"gopkg.in/guregu/null.v3"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImpersonationTokenIsNotUserSign(t *testing.T) {
+	for _, item := range []struct {
+		secret *SignSecret
+		want   bool
+	}{
+		{&SignSecret{UID: 2}, true},
+		{&SignSecret{UID: 2, ImpersonatorID: 1}, false},
+		{&SignSecret{UID: 2, ClientID: "app"}, false},
+		{&SignSecret{UID: 2, IsAPIKey: null.BoolFrom(true)}, false},
+	} {
+		c := &Context{SignInfo: &SignContext{UID: 2, Secret: item.secret}}
+		if got := isUserSign(c); got != item.want {
+			t.Errorf("isUserSign(%+v) = %v, want %v", item.secret, got, item.want)
+		}
+	}
+}
+
+func TestAPIKeyRouteRejectsImpersonation(t *testing.T) {
+	ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ginCtx.Request = httptest.NewRequest("POST", "/apikeys", strings.NewReader(`{"UID":2,"Exp":4102444800,"Type":"ADMIN"}`))
+	ginCtx.Request.Header.Set("Content-Type", "application/json")
+	c := &Context{
+		Context: ginCtx,
+		SignInfo: &SignContext{
+			UID:      2,
+			ActorUID: 1,
+			Secret:   &SignSecret{UID: 2, ImpersonatorID: 1},
+		},
+	}
+	reply := APIKeyRoute.HandlerFunc(c)
+	err, _ := reply.Data.(error)
+	if reply.Code == 0 || err == nil || !strings.Contains(err.Error(), "user token") {
+		t.Fatalf("expected impersonation token to be rejected, got %+v", reply)
+	}
+}
+
+func TestPrivilegesSubset(t *testing.T) {
+	caller := &PrivilegesDesc{
+		PermissionMap:     map[string]int64{"sys_user": 0, "sys_login_as": 0},
+		ReadableOrgIDMap:  map[uint]Org{1: {}, 2: {}},
+		WritableOrgIDMap:  map[uint]Org{1: {}},
+		LoginableOrgIDMap: map[uint]Org{1: {}, 2: {}},
+	}
+	target := &PrivilegesDesc{
+		PermissionMap:     map[string]int64{"sys_user": 0},
+		ReadableOrgIDMap:  map[uint]Org{2: {}},
+		LoginableOrgIDMap: map[uint]Org{2: {}},
+	}
+	if !privilegesSubset(target, caller) {
+		t.Error("expected target to be within the caller's privileges")
+	}
+	target.PermissionMap["sys_role"] = 0
+	if privilegesSubset(target, caller) {
+		t.Error("expected extra permission to be rejected")
+	}
+	delete(target.PermissionMap, "sys_role")
+	target.WritableOrgIDMap = map[uint]Org{2: {}}
+	if privilegesSubset(target, caller) {
+		t.Error("expected extra writable org to be rejected")
+	}
+	if privilegesSubset(nil, caller) || privilegesSubset(target, nil) {
+		t.Error("expected nil privileges to be rejected")
+	}
+}
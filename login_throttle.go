@@ -0,0 +1,192 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"strings"
+	"time"
+)
+
+// LoginUnlockPermission 解除账号锁定的权限编码
+const LoginUnlockPermission = "acc_login_unlock"
+
+// LoginThrottle 登录限流配置，对应配置loginThrottle
+type LoginThrottle struct {
+	// Window 滑动窗口长度（秒）
+	Window int
+	// IPLimit 每个IP在窗口内允许的登录失败次数
+	IPLimit int
+	// AccountLimit 每个账号在窗口内允许的登录失败次数
+	AccountLimit int
+	// LockoutThreshold 连续失败多少次后锁定账号，为0时不锁定
+	LockoutThreshold int
+	// LockoutSeconds 首次锁定时长（秒），此后每次失败加倍
+	LockoutSeconds int
+	// MaxLockoutSeconds 最长锁定时长（秒）
+	MaxLockoutSeconds int
+	// ResetSeconds 连续失败计数的保留时长（秒）
+	ResetSeconds int
+}
+
+// DefaultLoginThrottle
+var DefaultLoginThrottle = LoginThrottle{
+	Window:            300,
+	IPLimit:           50,
+	AccountLimit:      10,
+	LockoutThreshold:  5,
+	LockoutSeconds:    60,
+	MaxLockoutSeconds: 3600,
+	ResetSeconds:      86400,
+}
+
+// GetLoginThrottle
+func GetLoginThrottle() LoginThrottle {
+	throttle := DefaultLoginThrottle
+	C().GetInterface("loginThrottle", &throttle)
+	return throttle
+}
+
+// LockoutDuration 根据连续失败次数计算锁定时长（指数退避）
+func (t LoginThrottle) LockoutDuration(failures int) time.Duration {
+	if t.LockoutThreshold <= 0 || failures < t.LockoutThreshold {
+		return 0
+	}
+	seconds := t.LockoutSeconds
+	for i := t.LockoutThreshold; i < failures && seconds < t.MaxLockoutSeconds; i++ {
+		seconds *= 2
+	}
+	if t.MaxLockoutSeconds > 0 && seconds > t.MaxLockoutSeconds {
+		seconds = t.MaxLockoutSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func loginIPKey(ip string) string {
+	return fmt.Sprintf("login_throttle_ip_%s", ip)
+}
+
+func loginAccountKey(username string) string {
+	return strings.ToLower(fmt.Sprintf("login_throttle_user_%s", username))
+}
+
+func loginFailuresKey(username string) string {
+	return strings.ToLower(fmt.Sprintf("login_%s_failures", username))
+}
+
+func loginLockedKey(username string) string {
+	return strings.ToLower(fmt.Sprintf("login_%s_locked_until", username))
+}
+
+// LoginLockedUntil 返回账号锁定的截止时间戳，未锁定时返回0
+func LoginLockedUntil(username string) int64 {
+	if username == "" {
+		return 0
+	}
+	until := int64(GetCacheInt(loginLockedKey(username)))
+	if until <= time.Now().Unix() {
+		return 0
+	}
+	return until
+}
+
+// 校验IP和账号是否超出限制，返回需要等待的秒数
+func checkLoginThrottle(c *Context, username string) (retryAfter int64, err error) {
+	throttle := GetLoginThrottle()
+	window := time.Duration(throttle.Window) * time.Second
+	if window <= 0 {
+		return
+	}
+	if throttle.IPLimit > 0 && peekSlidingWindow(loginIPKey(c.ClientIP()), window) >= throttle.IPLimit {
+		return int64(throttle.Window), fmt.Errorf("too many login failures from IP: %s", c.ClientIP())
+	}
+	if until := LoginLockedUntil(username); until > 0 {
+		return until - time.Now().Unix(), fmt.Errorf("account locked: %s", username)
+	}
+	if username != "" && throttle.AccountLimit > 0 && peekSlidingWindow(loginAccountKey(username), window) >= throttle.AccountLimit {
+		return int64(throttle.Window), fmt.Errorf("too many login failures for account: %s", username)
+	}
+	return
+}
+
+// RecordLoginFailure 记录登录失败，达到阈值时锁定账号
+func RecordLoginFailure(c *Context, username string) {
+	throttle := GetLoginThrottle()
+	if window := time.Duration(throttle.Window) * time.Second; window > 0 {
+		incrSlidingWindow(loginIPKey(c.ClientIP()), window)
+		if username != "" {
+			incrSlidingWindow(loginAccountKey(username), window)
+		}
+	}
+	if username == "" || throttle.LockoutThreshold <= 0 {
+		return
+	}
+	key := loginFailuresKey(username)
+	failures := GetCacheInt(key) + 1
+	SetCacheInt(key, failures, time.Duration(throttle.ResetSeconds)*time.Second)
+	if d := throttle.LockoutDuration(failures); d > 0 {
+		until := time.Now().Add(d).Unix()
+		SetCacheInt(loginLockedKey(username), int(until), d)
+		addLoginEventLog("Account locked", fmt.Sprintf("Account %s locked for %v after %d failed logins", username, d, failures), username, D{
+			"Username": username,
+			"Failures": failures,
+			"Until":    until,
+		})
+	}
+}
+
+// ClearLoginFailures 登录成功或管理员解锁时清除失败记录
+func ClearLoginFailures(username string) {
+	DelCache(getFailedTimesKey(username), loginFailuresKey(username), loginLockedKey(username))
+}
+
+func addLoginEventLog(subject, summary, username string, data interface{}) {
+	if _, err := AddEventLog(EventLogDesc{
+		Class:   EventLogClassSecurity,
+		Subject: subject,
+		Summary: summary,
+		Data:    data,
+		Labels:  map[string]string{"Username": username},
+	}); err != nil {
+		ERROR(err)
+	}
+}
+
+// LoginUnlockRoute
+var LoginUnlockRoute = RouteInfo{
+	Name:   "解除账号登录锁定",
+	Method: "POST",
+	Path:   "/login/unlock",
+	IntlMessages: map[string]string{
+		"acc_login_unlock_unauthorized": "Unauthorized operation",
+		"acc_login_unlock_failed":       "Unlock account failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !c.HasPermission(LoginUnlockPermission) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "acc_login_unlock_unauthorized")
+		}
+		var body struct {
+			Username string
+			IP       string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_login_unlock_failed")
+		}
+		if body.Username == "" && body.IP == "" {
+			return c.STDErr(errors.New("username or IP is required"), "acc_login_unlock_failed")
+		}
+		window := time.Duration(GetLoginThrottle().Window) * time.Second
+		if body.Username != "" {
+			ClearLoginFailures(body.Username)
+			clearSlidingWindow(loginAccountKey(body.Username), window)
+		}
+		if body.IP != "" {
+			clearSlidingWindow(loginIPKey(body.IP), window)
+		}
+		addLoginEventLog("Account unlocked", fmt.Sprintf("Login lockout cleared by %s: username=%s ip=%s", c.SignInfo.Username, body.Username, body.IP), body.Username, D{
+			"Username": body.Username,
+			"IP":       body.IP,
+		})
+		return c.STDOK()
+	},
+}
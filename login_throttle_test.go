@@ -0,0 +1,47 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleLockoutDuration(t *testing.T) {
+	throttle := LoginThrottle{LockoutThreshold: 3, LockoutSeconds: 60, MaxLockoutSeconds: 300}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := throttle.LockoutDuration(tt.failures); got != tt.want {
+			t.Errorf("LockoutDuration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+	if got := (LoginThrottle{}).LockoutDuration(10); got != 0 {
+		t.Errorf("LockoutDuration() should be disabled without threshold, got %v", got)
+	}
+}
+
+func TestSlidingWindowEstimate(t *testing.T) {
+	tests := []struct {
+		prev, cur int
+		elapsed   float64
+		want      int
+	}{
+		{10, 0, 0, 10},
+		{10, 2, 0.5, 7},
+		{10, 2, 0.99, 2},
+		{0, 5, 0.3, 5},
+	}
+	for _, tt := range tests {
+		if got := slidingWindowEstimate(tt.prev, tt.cur, tt.elapsed); got != tt.want {
+			t.Errorf("slidingWindowEstimate(%d, %d, %v) = %d, want %d", tt.prev, tt.cur, tt.elapsed, got, tt.want)
+		}
+	}
+}
@@ -94,11 +94,13 @@ func (app *Engine) Import(mods ...*Mod) {
 				for _, method := range []string{"GET", "POST", "PUT", "PATCH", "HEAD", "OPTIONS", "DELETE", "CONNECT", "TRACE"} {
 					key := fmt.Sprintf("%s %s", method, routePath)
 					routesMap[key] = route
+					addRouteMatcher(method, routePath, route)
 				}
 			} else {
 				app.Handle(route.Method, routePath, route.HandlerFunc)
 				key := fmt.Sprintf("%s %s", route.Method, routePath)
 				routesMap[key] = route
+				addRouteMatcher(route.Method, routePath, route)
 			}
 			if len(route.IntlMessages) > 0 {
 				AddDefaultIntlMessage(route.IntlMessages)
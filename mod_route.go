@@ -1,6 +1,11 @@
 package kuu
 
-import "github.com/kuuland/kuu/route"
+import (
+	"github.com/kuuland/kuu/route"
+	"gopkg.in/guregu/null.v3"
+	"sort"
+	"strings"
+)
 
 // RouteInfo represents a request route's specification which contains method and path and its handler.
 type RouteInfo struct {
@@ -15,7 +20,114 @@ type RouteInfo struct {
 	RequestParams  route.RequestParams
 	ResponseParams route.ResponseParams
 	IntlMessages   map[string]string
+	// Audit 是否记录请求审计日志，未设置时按全局配置
+	Audit null.Bool
+	// RateLimit 路由的限流规则，优先于配置中的规则
+	RateLimit *RateLimit
 }
 
 // RoutesInfo defines a RouteInfo array.
 type RoutesInfo []RouteInfo
+
+// 带路径参数的路由，按优先级排序，在注册路由时构建
+var routeMatchers []routeMatcher
+
+type routeMatcher struct {
+	method  string
+	pattern routePattern
+	info    *RouteInfo
+}
+
+// 预先拆分的路由路径，支持":name"和"*name"形式的路径参数
+type routePattern struct {
+	raw      string
+	segments []string
+	params   int
+	wildcard bool
+}
+
+func compileRoutePattern(pattern string) routePattern {
+	p := routePattern{raw: pattern}
+	if !strings.ContainsAny(pattern, ":*") {
+		return p
+	}
+	p.segments = strings.Split(strings.Trim(pattern, "/"), "/")
+	for _, seg := range p.segments {
+		if strings.HasPrefix(seg, "*") {
+			p.wildcard = true
+		} else if strings.HasPrefix(seg, ":") {
+			p.params++
+		}
+	}
+	return p
+}
+
+func (p *routePattern) match(requestPath string) bool {
+	if p.segments == nil {
+		return p.raw == requestPath
+	}
+	rs := strings.Split(strings.Trim(requestPath, "/"), "/")
+	for i, seg := range p.segments {
+		if strings.HasPrefix(seg, "*") {
+			return true
+		}
+		if i >= len(rs) {
+			return false
+		}
+		if !strings.HasPrefix(seg, ":") && seg != rs[i] {
+			return false
+		}
+		if strings.HasPrefix(seg, ":") && rs[i] == "" {
+			return false
+		}
+	}
+	return len(p.segments) == len(rs)
+}
+
+// 静态段越多的路由越优先，通配路由最后匹配
+func (p *routePattern) before(o *routePattern) bool {
+	if p.wildcard != o.wildcard {
+		return !p.wildcard
+	}
+	if p.params != o.params {
+		return p.params < o.params
+	}
+	return len(p.segments) > len(o.segments)
+}
+
+func addRouteMatcher(method, routePath string, info RouteInfo) {
+	pattern := compileRoutePattern(routePath)
+	if pattern.segments == nil {
+		return
+	}
+	matcher := routeMatcher{method: method, pattern: pattern, info: &info}
+	for i, item := range routeMatchers {
+		if item.method == method && item.pattern.raw == routePath {
+			routeMatchers[i] = matcher
+			return
+		}
+	}
+	routeMatchers = append(routeMatchers, matcher)
+	sort.SliceStable(routeMatchers, func(i, j int) bool {
+		return routeMatchers[i].pattern.before(&routeMatchers[j].pattern)
+	})
+}
+
+// 查找请求对应的路由，先按完整路径查找，再按优先级匹配路径参数
+func matchRouteInfo(method, requestPath string) *RouteInfo {
+	if info, has := routesMap[method+" "+requestPath]; has {
+		return &info
+	}
+	for i := range routeMatchers {
+		item := &routeMatchers[i]
+		if item.method == method && item.pattern.match(requestPath) {
+			return item.info
+		}
+	}
+	return nil
+}
+
+func matchRoutePath(pattern, requestPath string) bool {
+	p := compileRoutePattern(pattern)
+	return p.match(requestPath)
+}
@@ -99,18 +99,22 @@ func RESTful(r *Engine, routePrefix string, value interface{}) (desc *RestDesc)
 				if createMethod != "-" {
 					desc.Create = true
 					r.Handle(createMethod, routePath, restCreateHandler(reflectType))
+					restModelRoutes[fmt.Sprintf("%s %s", createMethod, routePath)] = structName
 				}
 				if deleteMethod != "-" {
 					desc.Delete = true
 					r.Handle(deleteMethod, routePath, restDeleteHandler(reflectType))
+					restModelRoutes[fmt.Sprintf("%s %s", deleteMethod, routePath)] = structName
 				}
 				if queryMethod != "-" {
 					desc.Query = true
 					r.Handle(queryMethod, routePath, restQueryHandler(reflectType))
+					restModelRoutes[fmt.Sprintf("%s %s", queryMethod, routePath)] = structName
 				}
 				if updateMethod != "-" {
 					desc.Update = true
 					r.Handle(updateMethod, routePath, restUpdateHandler(reflectType))
+					restModelRoutes[fmt.Sprintf("%s %s", updateMethod, routePath)] = structName
 				}
 			}
 			break
@@ -0,0 +1,155 @@
+package kuu
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// NotifyMessage
+type NotifyMessage struct {
+	To      []string
+	Subject string
+	Body    string
+	HTML    bool
+}
+
+// Notifier 消息通知接口，可自定义短信、邮件等实现
+type Notifier interface {
+	Notify(msg *NotifyMessage) error
+}
+
+// DefaultNotifier 默认通知器，未设置时根据smtp配置创建
+var DefaultNotifier Notifier
+
+// SMTPTimeout 连接及发送邮件的超时时间
+var SMTPTimeout = 30 * time.Second
+
+// GetNotifier
+func GetNotifier() Notifier {
+	if DefaultNotifier != nil {
+		return DefaultNotifier
+	}
+	if C().Has("smtp") {
+		var n SMTPNotifier
+		C().GetInterface("smtp", &n)
+		if n.Host != "" {
+			return &n
+		}
+	}
+	return nil
+}
+
+// SMTPNotifier
+type SMTPNotifier struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+}
+
+// Notify
+func (n *SMTPNotifier) Notify(msg *NotifyMessage) error {
+	if msg == nil || len(msg.To) == 0 {
+		return errors.New("no recipients")
+	}
+	port := n.Port
+	if port == 0 {
+		port = 25
+	}
+	from := n.From
+	if from == "" {
+		from = n.Username
+	}
+	addr := net.JoinHostPort(n.Host, fmt.Sprintf("%d", port))
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+	data := n.encode(from, msg)
+	// 整个会话设置截止时间，避免服务器无响应时阻塞
+	dialer := &net.Dialer{Timeout: SMTPTimeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	// 465端口使用隐式TLS，其余端口在服务器支持时协商STARTTLS
+	if port == 465 {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: n.Host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Now().Add(SMTPTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, n.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: n.Host}); err != nil {
+				return err
+			}
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (n *SMTPNotifier) encode(from string, msg *NotifyMessage) []byte {
+	contentType := "text/plain"
+	if msg.HTML {
+		contentType = "text/html"
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("From: %s\r\n", from))
+	buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(msg.To, ", ")))
+	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject)))
+	buf.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString(fmt.Sprintf("Content-Type: %s; charset=UTF-8\r\n", contentType))
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+	encoded := Base64Encode(msg.Body)
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes()
+}
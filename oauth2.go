@@ -0,0 +1,778 @@
+package kuu
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	"gopkg.in/guregu/null.v3"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// OAuth2SignType
+	OAuth2SignType = "OAUTH2"
+	// OAuth2ClientsAdminPermission 管理OAuth客户端的权限编码
+	OAuth2ClientsAdminPermission = "oauth2_clients_admin"
+)
+
+var (
+	// OAuth2CodeExpires 授权码有效期（秒）
+	OAuth2CodeExpires = 60
+	// OAuth2AccessExpires 访问令牌默认有效期（秒）
+	OAuth2AccessExpires = 3600
+)
+
+// OAuth2Error
+type OAuth2Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *OAuth2Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func oauth2Err(code, description string) *OAuth2Error {
+	return &OAuth2Error{Code: code, Description: description}
+}
+
+// HasGrantType
+func (client *OAuthClient) HasGrantType(grantType string) bool {
+	for _, item := range ParseScopes(client.GrantTypes) {
+		if item == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRedirectURI
+func (client *OAuthClient) HasRedirectURI(uri string) bool {
+	for _, item := range ParseScopes(client.RedirectURIs) {
+		if item == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterScopes 过滤出客户端允许的授权范围，未申请时返回全部允许的范围，客户端未配置授权范围时拒绝授权
+func (client *OAuthClient) FilterScopes(requested []string) ([]string, error) {
+	allowed := SplitScopes(client.Scopes)
+	// 不限授权范围的令牌拥有用户的全部权限，不能签发给客户端
+	if len(allowed) == 0 {
+		return nil, oauth2Err("invalid_scope", "client has no scopes")
+	}
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	allowedMap := make(map[string]bool)
+	for _, item := range allowed {
+		allowedMap[item] = true
+	}
+	for _, item := range requested {
+		if !allowedMap[item] {
+			return nil, oauth2Err("invalid_scope", item)
+		}
+	}
+	return requested, nil
+}
+
+// GetOAuthClient
+func GetOAuthClient(clientID string) (*OAuthClient, error) {
+	var client OAuthClient
+	if clientID == "" {
+		return nil, oauth2Err("invalid_client", "client_id is required")
+	}
+	if err := DB().Where(&OAuthClient{ClientID: clientID}).First(&client).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, oauth2Err("invalid_client", "client not found")
+		}
+		return nil, err
+	}
+	if client.Disable.Bool {
+		return nil, oauth2Err("invalid_client", "client disabled")
+	}
+	return &client, nil
+}
+
+// 校验客户端身份（HTTP Basic或表单参数）
+func authenticateOAuthClient(c *Context) (*OAuthClient, error) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+	client, err := GetOAuthClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.Confidential.Bool {
+		if secret == "" || !hmac.Equal([]byte(Sha256(secret)), []byte(client.SecretHash)) {
+			return nil, oauth2Err("invalid_client", "client authentication failed")
+		}
+	} else if secret != "" {
+		return nil, oauth2Err("invalid_client", "public client must not send a secret")
+	}
+	return client, nil
+}
+
+type oauth2AuthorizeRequest struct {
+	ResponseType        string `form:"response_type" json:"response_type"`
+	ClientID            string `form:"client_id" json:"client_id"`
+	RedirectURI         string `form:"redirect_uri" json:"redirect_uri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"code_challenge_method"`
+	Approve             bool   `form:"-" json:"approve"`
+}
+
+// 校验授权请求，返回的错误在回调地址确认前不得重定向
+func (req *oauth2AuthorizeRequest) validate() (client *OAuthClient, scopes []string, redirectable bool, err error) {
+	if client, err = GetOAuthClient(req.ClientID); err != nil {
+		return
+	}
+	if req.RedirectURI == "" {
+		if uris := ParseScopes(client.RedirectURIs); len(uris) == 1 {
+			req.RedirectURI = uris[0]
+		}
+	}
+	if !client.HasRedirectURI(req.RedirectURI) {
+		err = oauth2Err("invalid_request", "redirect_uri mismatch")
+		return
+	}
+	redirectable = true
+	if req.ResponseType != "code" {
+		err = oauth2Err("unsupported_response_type", req.ResponseType)
+		return
+	}
+	if !client.HasGrantType("authorization_code") {
+		err = oauth2Err("unauthorized_client", "authorization_code")
+		return
+	}
+	// 公开客户端必须使用PKCE
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		err = oauth2Err("invalid_request", "code_challenge_method must be S256")
+		return
+	}
+	if req.CodeChallenge == "" && !client.Confidential.Bool {
+		err = oauth2Err("invalid_request", "code_challenge is required")
+		return
+	}
+	scopes, err = client.FilterScopes(ParseScopes(req.Scope))
+	return
+}
+
+func (req *oauth2AuthorizeRequest) redirect(values url.Values) string {
+	if req.State != "" {
+		values.Set("state", req.State)
+	}
+	sep := "?"
+	if strings.Contains(req.RedirectURI, "?") {
+		sep = "&"
+	}
+	return req.RedirectURI + sep + values.Encode()
+}
+
+func (req *oauth2AuthorizeRequest) redirectErr(err error) string {
+	values := url.Values{}
+	if e, ok := err.(*OAuth2Error); ok {
+		values.Set("error", e.Code)
+		if e.Description != "" {
+			values.Set("error_description", e.Description)
+		}
+	} else {
+		values.Set("error", "server_error")
+	}
+	return req.redirect(values)
+}
+
+type oauth2Code struct {
+	ClientID      string
+	UID           uint
+	RedirectURI   string
+	Scopes        []string
+	CodeChallenge string
+}
+
+func oauth2CodeKey(code string) string {
+	return fmt.Sprintf("oauth2_code_%s", Sha256(code))
+}
+
+func (req *oauth2AuthorizeRequest) issueCode(uid uint, scopes []string) string {
+	code := RandomHex(32)
+	SetCacheString(oauth2CodeKey(code), JSONStringify(oauth2Code{
+		ClientID:      req.ClientID,
+		UID:           uid,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        scopes,
+		CodeChallenge: req.CodeChallenge,
+	}), time.Second*time.Duration(C().DefaultGetInt("oauth2.codeExpires", OAuth2CodeExpires)))
+	return req.redirect(url.Values{"code": []string{code}})
+}
+
+func hasConsent(uid uint, clientID string, scopes []string) bool {
+	var consent OAuthConsent
+	if err := DB().Where(&OAuthConsent{UID: uid, ClientID: clientID}).First(&consent).Error; err != nil {
+		return false
+	}
+	granted := make(map[string]bool)
+	for _, item := range ParseScopes(consent.Scopes) {
+		granted[item] = true
+	}
+	for _, item := range scopes {
+		if !granted[item] {
+			return false
+		}
+	}
+	return true
+}
+
+func saveConsent(uid uint, clientID string, scopes []string) error {
+	var consent OAuthConsent
+	if err := DB().Where(&OAuthConsent{UID: uid, ClientID: clientID}).First(&consent).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+		return err
+	}
+	merged := ParseScopes(consent.Scopes)
+	exists := make(map[string]bool)
+	for _, item := range merged {
+		exists[item] = true
+	}
+	for _, item := range scopes {
+		if !exists[item] {
+			merged = append(merged, item)
+		}
+	}
+	if consent.ID == 0 {
+		return DB().Create(&OAuthConsent{UID: uid, ClientID: clientID, Scopes: strings.Join(merged, " ")}).Error
+	}
+	return DB().Model(&consent).Updates(&OAuthConsent{Scopes: strings.Join(merged, " ")}).Error
+}
+
+// IssueOAuth2Token 为客户端签发访问令牌
+func IssueOAuth2Token(client *OAuthClient, uid uint, scopes []string) (*SignSecret, error) {
+	return issueOAuth2Token(client, uid, scopes, true)
+}
+
+func issueOAuth2Token(client *OAuthClient, uid uint, scopes []string, withRefresh bool) (*SignSecret, error) {
+	if len(scopes) == 0 {
+		return nil, oauth2Err("invalid_scope", "scope is required")
+	}
+	user := GetUserFromCache(uid)
+	if user.ID == 0 || user.Disable.Bool || user.DenyLogin.Bool {
+		return nil, oauth2Err("invalid_grant", "user unavailable")
+	}
+	return GenToken(GenTokenDesc{
+		UID:      user.ID,
+		Username: user.Username,
+		Exp:      time.Now().Add(time.Second * time.Duration(C().DefaultGetInt("oauth2.accessExpires", OAuth2AccessExpires))).Unix(),
+		Type:     OAuth2SignType,
+		Desc:     client.Name,
+		Payload: jwt.MapClaims{
+			"UID":      user.ID,
+			"Username": user.Username,
+			"ClientID": client.ClientID,
+			"Scope":    strings.Join(scopes, " "),
+		},
+		Scopes:    scopes,
+		clientID:  client.ClientID,
+		noRefresh: !withRefresh,
+	})
+}
+
+func oauth2JSON(c *Context, code int, obj interface{}) *STDReply {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(code, obj)
+	return nil
+}
+
+func oauth2Fail(c *Context, err error) *STDReply {
+	if e, ok := err.(*OAuth2Error); ok {
+		code := http.StatusBadRequest
+		if e.Code == "invalid_client" {
+			code = http.StatusUnauthorized
+		}
+		return oauth2JSON(c, code, e)
+	}
+	ERROR(err)
+	return oauth2JSON(c, http.StatusInternalServerError, oauth2Err("server_error", ""))
+}
+
+// 原子地取出并删除授权码，并发兑换同一授权码时只有一个请求成功
+func takeOAuth2Code(code string) (string, error) {
+	if code == "" {
+		return "", nil
+	}
+	cache, err := lockCache()
+	if err != nil {
+		return "", err
+	}
+	key := oauth2CodeKey(code)
+	raw := cache.GetString(key)
+	if raw == "" || !cache.CompareAndDel(key, raw) {
+		return "", nil
+	}
+	return raw, nil
+}
+
+func oauth2TokenGrant(c *Context, client *OAuthClient) (*SignSecret, error) {
+	grantType := c.PostForm("grant_type")
+	if !client.HasGrantType(grantType) {
+		return nil, oauth2Err("unauthorized_client", grantType)
+	}
+	switch grantType {
+	case "authorization_code":
+		raw, err := takeOAuth2Code(c.PostForm("code"))
+		if err != nil {
+			return nil, err
+		}
+		if raw == "" {
+			return nil, oauth2Err("invalid_grant", "invalid code")
+		}
+		var data oauth2Code
+		if err := JSONParse(raw, &data); err != nil {
+			return nil, err
+		}
+		if data.ClientID != client.ClientID || data.RedirectURI != c.PostForm("redirect_uri") {
+			return nil, oauth2Err("invalid_grant", "client or redirect_uri mismatch")
+		}
+		if data.CodeChallenge != "" && pkceChallenge(c.PostForm("code_verifier")) != data.CodeChallenge {
+			return nil, oauth2Err("invalid_grant", "code_verifier mismatch")
+		}
+		return IssueOAuth2Token(client, data.UID, data.Scopes)
+	case "client_credentials":
+		if !client.Confidential.Bool || client.UID == 0 {
+			return nil, oauth2Err("unauthorized_client", grantType)
+		}
+		scopes, err := client.FilterScopes(ParseScopes(c.PostForm("scope")))
+		if err != nil {
+			return nil, err
+		}
+		return issueOAuth2Token(client, client.UID, scopes, false)
+	case "refresh_token":
+		secretData, err := RefreshToken(c.PostForm("refresh_token"), client.ClientID)
+		if err != nil {
+			return nil, oauth2Err("invalid_grant", err.Error())
+		}
+		return secretData, nil
+	}
+	return nil, oauth2Err("unsupported_grant_type", grantType)
+}
+
+// OAuth2AuthorizeRoute
+var OAuth2AuthorizeRoute = RouteInfo{
+	Name:   "OAuth2授权接口",
+	Method: "GET",
+	Path:   "/oauth2/authorize",
+	IntlMessages: map[string]string{
+		"oauth2_authorize_failed": "Authorization failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var req oauth2AuthorizeRequest
+		if err := c.ShouldBindQuery(&req); err != nil {
+			return c.STDErr(err, "oauth2_authorize_failed")
+		}
+		if !isUserSign(c) {
+			return c.STDErrWithCode(errors.New("user token is required"), 555, "acc_please_login", "Please login")
+		}
+		client, scopes, redirectable, err := req.validate()
+		if err != nil {
+			if redirectable {
+				c.Redirect(http.StatusFound, req.redirectErr(err))
+				return nil
+			}
+			return c.STDErr(err, "oauth2_authorize_failed")
+		}
+		// 已授权过的范围直接跳转
+		if hasConsent(c.SignInfo.UID, client.ClientID, scopes) {
+			c.Redirect(http.StatusFound, req.issueCode(c.SignInfo.UID, scopes))
+			return nil
+		}
+		return c.STD(D{
+			"ConsentRequired": true,
+			"ClientID":        client.ClientID,
+			"ClientName":      client.Name,
+			"Scopes":          scopes,
+		})
+	},
+}
+
+// OAuth2ConsentRoute
+var OAuth2ConsentRoute = RouteInfo{
+	Name:   "OAuth2用户授权确认接口",
+	Method: "POST",
+	Path:   "/oauth2/authorize",
+	IntlMessages: map[string]string{
+		"oauth2_authorize_failed": "Authorization failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var req oauth2AuthorizeRequest
+		if err := c.ShouldBindBodyWith(&req, binding.JSON); err != nil {
+			return c.STDErr(err, "oauth2_authorize_failed")
+		}
+		if !isUserSign(c) {
+			return c.STDErrWithCode(errors.New("user token is required"), 555, "acc_please_login", "Please login")
+		}
+		client, scopes, redirectable, err := req.validate()
+		if err != nil {
+			if redirectable {
+				return c.STD(D{"RedirectURI": req.redirectErr(err)})
+			}
+			return c.STDErr(err, "oauth2_authorize_failed")
+		}
+		if !req.Approve {
+			return c.STD(D{"RedirectURI": req.redirectErr(oauth2Err("access_denied", ""))})
+		}
+		if err := saveConsent(c.SignInfo.UID, client.ClientID, scopes); err != nil {
+			return c.STDErr(err, "oauth2_authorize_failed")
+		}
+		return c.STD(D{"RedirectURI": req.issueCode(c.SignInfo.UID, scopes)})
+	},
+}
+
+// OAuth2TokenRoute
+var OAuth2TokenRoute = RouteInfo{
+	Name:   "OAuth2令牌接口",
+	Method: "POST",
+	Path:   "/oauth2/token",
+	HandlerFunc: func(c *Context) *STDReply {
+		client, err := authenticateOAuthClient(c)
+		if err != nil {
+			return oauth2Fail(c, err)
+		}
+		secretData, err := oauth2TokenGrant(c, client)
+		if err != nil {
+			return oauth2Fail(c, err)
+		}
+		ret := D{
+			"access_token": secretData.Token,
+			"token_type":   "Bearer",
+			"expires_in":   secretData.Exp - time.Now().Unix(),
+			"scope":        secretData.Scopes,
+		}
+		if secretData.RefreshToken != "" {
+			ret["refresh_token"] = secretData.RefreshToken
+		}
+		return oauth2JSON(c, http.StatusOK, ret)
+	},
+}
+
+// OAuth2IntrospectRoute
+var OAuth2IntrospectRoute = RouteInfo{
+	Name:   "OAuth2令牌校验接口",
+	Method: "POST",
+	Path:   "/oauth2/introspect",
+	HandlerFunc: func(c *Context) *STDReply {
+		if _, err := authenticateOAuthClient(c); err != nil {
+			return oauth2Fail(c, err)
+		}
+		var secret SignSecret
+		token := c.PostForm("token")
+		if token != "" {
+			if err := DB().Where(&SignSecret{Token: token}).First(&secret).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+				return oauth2Fail(c, err)
+			}
+		}
+		// 仅可校验签发给OAuth客户端的令牌
+		if secret.ID == 0 || secret.ClientID == "" || secret.Method != SignMethodLogin ||
+			secret.Exp < time.Now().Unix() || IsSignRevoked(secret.Secret) {
+			return oauth2JSON(c, http.StatusOK, D{"active": false})
+		}
+		return oauth2JSON(c, http.StatusOK, D{
+			"active":     true,
+			"scope":      secret.Scopes,
+			"client_id":  secret.ClientID,
+			"username":   secret.Username,
+			"sub":        fmt.Sprintf("%d", secret.UID),
+			"token_type": "Bearer",
+			"iat":        secret.Iat,
+			"exp":        secret.Exp,
+		})
+	},
+}
+
+// OAuth2RevokeRoute
+var OAuth2RevokeRoute = RouteInfo{
+	Name:   "OAuth2令牌吊销接口",
+	Method: "POST",
+	Path:   "/oauth2/revoke",
+	HandlerFunc: func(c *Context) *STDReply {
+		client, err := authenticateOAuthClient(c)
+		if err != nil {
+			return oauth2Fail(c, err)
+		}
+		token := c.PostForm("token")
+		if token == "" {
+			return oauth2Fail(c, oauth2Err("invalid_request", "token is required"))
+		}
+		var secret SignSecret
+		if err := DB().Where(&SignSecret{Token: token, ClientID: client.ClientID}).First(&secret).Error; err == nil {
+			err = RevokeSignSecrets(DB(), []SignSecret{secret})
+			if err != nil {
+				return oauth2Fail(c, err)
+			}
+			return oauth2JSON(c, http.StatusOK, D{})
+		}
+		var record SignRefreshToken
+		if err := DB().Where(&SignRefreshToken{TokenHash: Sha256(token), ClientID: client.ClientID}).First(&record).Error; err == nil {
+			if err := RevokeRefreshTokenFamily(DB(), record.Family); err != nil {
+				return oauth2Fail(c, err)
+			}
+		}
+		// 无效令牌同样返回成功（RFC 7009）
+		return oauth2JSON(c, http.StatusOK, D{})
+	},
+}
+
+func canManageOAuthClients(c *Context) bool {
+	return c.HasPermission(OAuth2ClientsAdminPermission)
+}
+
+// OAuth2ClientsRoute
+var OAuth2ClientsRoute = RouteInfo{
+	Name:   "查询OAuth客户端列表",
+	Method: "GET",
+	Path:   "/oauth2/clients",
+	IntlMessages: map[string]string{
+		"oauth2_unauthorized":   "Unauthorized operation",
+		"oauth2_clients_failed": "OAuth clients query failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !canManageOAuthClients(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "oauth2_unauthorized")
+		}
+		var list []OAuthClient
+		if err := DB().Order("id desc").Find(&list).Error; err != nil {
+			return c.STDErr(err, "oauth2_clients_failed")
+		}
+		return c.STD(list)
+	},
+}
+
+// OAuth2ClientCreateRoute
+var OAuth2ClientCreateRoute = RouteInfo{
+	Name:   "注册OAuth客户端",
+	Method: "POST",
+	Path:   "/oauth2/clients",
+	IntlMessages: map[string]string{
+		"oauth2_unauthorized":         "Unauthorized operation",
+		"oauth2_client_create_failed": "Create OAuth client failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !canManageOAuthClients(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "oauth2_unauthorized")
+		}
+		var body struct {
+			Name         string `binding:"required"`
+			RedirectURIs []string
+			Scopes       []string
+			GrantTypes   []string
+			Confidential bool
+			UID          uint
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "oauth2_client_create_failed")
+		}
+		if len(body.GrantTypes) == 0 {
+			body.GrantTypes = []string{"authorization_code", "refresh_token"}
+		}
+		uid, scopes, err := oauthClientOwner(c, body.UID, body.Scopes)
+		if err != nil {
+			return c.STDErr(err, "oauth2_client_create_failed")
+		}
+		body.UID, body.Scopes = uid, scopes
+		client := OAuthClient{
+			ClientID:     RandomHex(12),
+			Name:         body.Name,
+			RedirectURIs: strings.Join(body.RedirectURIs, " "),
+			Scopes:       JoinScopes(body.Scopes),
+			GrantTypes:   strings.Join(body.GrantTypes, " "),
+			Confidential: null.NewBool(body.Confidential, true),
+			UID:          body.UID,
+		}
+		var secret string
+		if body.Confidential {
+			secret = RandomHex(32)
+			client.SecretHash = Sha256(secret)
+		}
+		if err := DB().Create(&client).Error; err != nil {
+			return c.STDErr(err, "oauth2_client_create_failed")
+		}
+		// 客户端密钥仅在创建时返回一次
+		return c.STD(D{
+			"Client":       client,
+			"ClientID":     client.ClientID,
+			"ClientSecret": secret,
+		})
+	},
+}
+
+// 客户端默认绑定当前用户，非root用户只能绑定权限不超过自己的用户，授权范围中的权限编码须为绑定用户所有
+func oauthClientOwner(c *Context, uid uint, scopes []string) (uint, []string, error) {
+	if uid == 0 {
+		uid = c.SignInfo.UID
+	}
+	if uid == RootUID() {
+		if c.SignInfo.UID != RootUID() {
+			return 0, nil, errors.New("clients can not be bound to root")
+		}
+		return uid, scopes, nil
+	}
+	desc := GetPrivilegesDesc(uid)
+	if desc == nil {
+		return 0, nil, fmt.Errorf("user not found: %d", uid)
+	}
+	if uid != c.SignInfo.UID && c.SignInfo.UID != RootUID() && !privilegesSubset(desc, c.PrisDesc) {
+		return 0, nil, fmt.Errorf("client owner has privileges beyond the operator: %d", uid)
+	}
+	filtered := filterPermissionScopes(scopes, desc)
+	if len(scopes) > 0 && len(filtered) == 0 {
+		return 0, nil, fmt.Errorf("client owner has none of the scopes: %d", uid)
+	}
+	return uid, filtered, nil
+}
+
+// 移除用户不具备的权限编码，路由规则保留并在请求时按用户权限校验
+func filterPermissionScopes(scopes []string, desc *PrivilegesDesc) (list []string) {
+	for _, item := range scopes {
+		if IsRouteScope(item) {
+			list = append(list, item)
+		} else if _, has := desc.PermissionMap[item]; has {
+			list = append(list, item)
+		}
+	}
+	return
+}
+
+// OAuth2ClientSecretRoute
+var OAuth2ClientSecretRoute = RouteInfo{
+	Name:   "重置OAuth客户端密钥",
+	Method: "POST",
+	Path:   "/oauth2/clients/:id/secret",
+	IntlMessages: map[string]string{
+		"oauth2_unauthorized":         "Unauthorized operation",
+		"oauth2_client_secret_failed": "Reset OAuth client secret failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !canManageOAuthClients(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "oauth2_unauthorized")
+		}
+		var client OAuthClient
+		if err := DB().First(&client, ParseID(c.Param("id"))).Error; err != nil {
+			return c.STDErr(err, "oauth2_client_secret_failed")
+		}
+		if !client.Confidential.Bool {
+			return c.STDErr(errors.New("public client has no secret"), "oauth2_client_secret_failed")
+		}
+		secret := RandomHex(32)
+		if err := DB().Model(&client).Updates(&OAuthClient{SecretHash: Sha256(secret)}).Error; err != nil {
+			return c.STDErr(err, "oauth2_client_secret_failed")
+		}
+		return c.STD(D{
+			"ClientID":     client.ClientID,
+			"ClientSecret": secret,
+		})
+	},
+}
+
+// 吊销指定客户端签发的令牌，uid为0时吊销全部用户
+func revokeOAuthClientTokens(tx *gorm.DB, clientID string, uid uint) error {
+	var secrets []SignSecret
+	if err := tx.Where(&SignSecret{ClientID: clientID, UID: uid, Method: SignMethodLogin}).Find(&secrets).Error; err != nil {
+		return err
+	}
+	return RevokeSignSecrets(tx, secrets)
+}
+
+// OAuth2ClientDeleteRoute
+var OAuth2ClientDeleteRoute = RouteInfo{
+	Name:   "删除OAuth客户端",
+	Method: "DELETE",
+	Path:   "/oauth2/clients/:id",
+	IntlMessages: map[string]string{
+		"oauth2_unauthorized":         "Unauthorized operation",
+		"oauth2_client_delete_failed": "Delete OAuth client failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if !canManageOAuthClients(c) {
+			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "oauth2_unauthorized")
+		}
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			var client OAuthClient
+			if err := tx.First(&client, ParseID(c.Param("id"))).Error; err != nil {
+				return err
+			}
+			if err := revokeOAuthClientTokens(tx, client.ClientID, 0); err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Where(&OAuthConsent{ClientID: client.ClientID}).Delete(&OAuthConsent{}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&client).Error
+		})
+		if err != nil {
+			return c.STDErr(err, "oauth2_client_delete_failed")
+		}
+		return c.STDOK()
+	},
+}
+
+// OAuth2ConsentsRoute
+var OAuth2ConsentsRoute = RouteInfo{
+	Name:   "查询当前用户的OAuth授权",
+	Method: "GET",
+	Path:   "/oauth2/consents",
+	IntlMessages: map[string]string{
+		"oauth2_consents_failed": "OAuth consents query failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var list []OAuthConsent
+		if err := DB().Where(&OAuthConsent{UID: c.SignInfo.UID}).Find(&list).Error; err != nil {
+			return c.STDErr(err, "oauth2_consents_failed")
+		}
+		return c.STD(list)
+	},
+}
+
+// OAuth2ConsentRevokeRoute
+var OAuth2ConsentRevokeRoute = RouteInfo{
+	Name:   "撤销当前用户的OAuth授权",
+	Method: "POST",
+	Path:   "/oauth2/consents/revoke",
+	IntlMessages: map[string]string{
+		"oauth2_consents_revoke_failed": "Revoke OAuth consent failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			ClientID string `binding:"required"`
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "oauth2_consents_revoke_failed")
+		}
+		uid := c.SignInfo.UID
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Where(&OAuthConsent{UID: uid, ClientID: body.ClientID}).Delete(&OAuthConsent{}).Error; err != nil {
+				return err
+			}
+			return revokeOAuthClientTokens(tx, body.ClientID, uid)
+		})
+		if err != nil {
+			return c.STDErr(err, "oauth2_consents_revoke_failed")
+		}
+		return c.STDOK()
+	},
+}
@@ -0,0 +1,145 @@
+package kuu
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestParseScopes(t *testing.T) {
+	got := ParseScopes(" read,write\tadmin\n ")
+	if want := []string{"read", "write", "admin"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseScopes() = %v, want %v", got, want)
+	}
+}
+
+func TestOAuthClientFilterScopes(t *testing.T) {
+	client := &OAuthClient{Scopes: "read write", RedirectURIs: "https://a.example/cb https://b.example/cb"}
+	if got, _ := client.FilterScopes(nil); !reflect.DeepEqual(got, []string{"read", "write"}) {
+		t.Errorf("FilterScopes(nil) = %v", got)
+	}
+	if _, err := client.FilterScopes([]string{"read", "admin"}); err == nil {
+		t.Errorf("FilterScopes() should reject scopes not granted to client")
+	}
+	if _, err := (&OAuthClient{}).FilterScopes(nil); err == nil {
+		t.Errorf("FilterScopes() should reject clients without scopes")
+	}
+	if !client.HasRedirectURI("https://b.example/cb") || client.HasRedirectURI("https://b.example/cb/x") {
+		t.Errorf("HasRedirectURI() should require exact match")
+	}
+}
+
+func TestTakeOAuth2CodeOnce(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	SetCacheString(oauth2CodeKey("abc"), `{"UID":2}`)
+	var (
+		wg      sync.WaitGroup
+		success int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if raw, err := takeOAuth2Code("abc"); err == nil && raw != "" {
+				atomic.AddInt32(&success, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Errorf("expected the code to be redeemed once, got %d", success)
+	}
+	if raw, _ := takeOAuth2Code(""); raw != "" {
+		t.Errorf("expected empty code to be rejected")
+	}
+}
+
+func TestClientCredentialsWithoutRefreshToken(t *testing.T) {
+	defer useTestDB(t, &User{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestConfig(`{"token":{"refresh":true}}`)()
+
+	if err := DB().Create(&User{Username: "app", Password: "x"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	var user User
+	DB().Where(&User{Username: "app"}).First(&user)
+	client := &OAuthClient{ClientID: "app", Name: "App", UID: user.ID}
+
+	secret, err := issueOAuth2Token(client, user.ID, []string{"read"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.RefreshToken != "" {
+		t.Error("client_credentials tokens should not carry refresh tokens")
+	}
+	if secret, err = IssueOAuth2Token(client, user.ID, []string{"read"}); err != nil || secret.RefreshToken == "" {
+		t.Errorf("expected a refresh token for authorization_code tokens: %v", err)
+	}
+	if _, err := IssueOAuth2Token(client, user.ID, nil); err == nil {
+		t.Error("expected unscoped tokens to be rejected")
+	}
+}
+
+func TestOAuthClientOwner(t *testing.T) {
+	c := &Context{SignInfo: &SignContext{UID: 2}, PrisDesc: &PrivilegesDesc{UID: 2, PermissionMap: map[string]int64{"sys_oauth_client": 0}}}
+	// 非root用户不能将客户端绑定到root
+	if _, _, err := oauthClientOwner(c, RootUID(), []string{"read"}); err == nil {
+		t.Error("expected binding to root to be rejected")
+	}
+	c.SignInfo.UID = RootUID()
+	if uid, scopes, err := oauthClientOwner(c, RootUID(), []string{"read"}); err != nil || uid != RootUID() || len(scopes) != 1 {
+		t.Errorf("unexpected result for root: %d %v %v", uid, scopes, err)
+	}
+
+	// 绑定其他用户时须不超过操作人的权限，授权范围按绑定用户的权限过滤
+	defer useTestDB(t, &Org{})()
+	prev := GetUserWithRoles
+	defer func() { GetUserWithRoles = prev }()
+	GetUserWithRoles = func(uid uint) (*User, error) {
+		role := &Role{OperationPrivileges: []OperationPrivileges{{MenuCode: "read"}, {MenuCode: "sys_role"}}}
+		return &User{ID: uid, RoleAssigns: []RoleAssign{{Role: role}}}, nil
+	}
+	c.SignInfo.UID = 2
+	if _, _, err := oauthClientOwner(c, 3, []string{"read"}); err == nil {
+		t.Error("expected binding a user with more privileges to be rejected")
+	}
+	c.PrisDesc.PermissionMap = map[string]int64{"read": 0, "sys_role": 0, "sys_user": 0}
+	c.PrisDesc.ReadableOrgIDMap = map[uint]Org{0: {}}
+	c.PrisDesc.WritableOrgIDMap = map[uint]Org{0: {}}
+	c.PrisDesc.LoginableOrgIDMap = map[uint]Org{0: {}}
+	if uid, scopes, err := oauthClientOwner(c, 3, []string{"read", "sys_user"}); err != nil || uid != 3 || !reflect.DeepEqual(scopes, []string{"read"}) {
+		t.Errorf("unexpected result: %d %v %v", uid, scopes, err)
+	}
+
+	desc := &PrivilegesDesc{PermissionMap: map[string]int64{"read": 0}}
+	got := filterPermissionScopes([]string{"read", "write", "GET /api/user"}, desc)
+	if want := []string{"read", "GET /api/user"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("filterPermissionScopes() = %v, want %v", got, want)
+	}
+}
+
+func TestOAuth2PermissionScopedToken(t *testing.T) {
+	defer useTestDB(t, &User{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+
+	user := createTestUser(t, "oauth_perm")
+	client := &OAuthClient{ClientID: "app", Name: "app", Scopes: "user_read"}
+	secret, err := issueOAuth2Token(client, user.ID, []string{"user_read"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 仅有权限编码的令牌可以访问路由，权限按授权范围限制
+	if !secret.RouteAllowed("GET", "/api/user") {
+		t.Error("expected the token to reach routes")
+	}
+	c := &Context{SignInfo: &SignContext{UID: user.ID, Secret: secret}, PrisDesc: &PrivilegesDesc{
+		UID:           user.ID,
+		Valid:         true,
+		SignInfo:      &SignContext{UID: user.ID, Token: secret.Token, Secret: secret},
+		PermissionMap: map[string]int64{"user_read": 0, "user_write": 0},
+	}}
+	if !c.HasPermission("user_read") || c.HasPermission("user_write") {
+		t.Error("expected permissions to be limited by the token scopes")
+	}
+}
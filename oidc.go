@@ -0,0 +1,494 @@
+package kuu
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// OIDCHTTPClient 访问身份提供方时使用的HTTP客户端
+	OIDCHTTPClient = &http.Client{Timeout: 10 * time.Second}
+	// OIDCStateExpires 授权请求的有效期（秒）
+	OIDCStateExpires = 600
+	// OIDCDiscoveryExpires 发现文档的缓存时间
+	OIDCDiscoveryExpires = time.Hour
+	oidcDiscoveryCache   sync.Map
+)
+
+// OIDCSignTypePrefix
+const OIDCSignTypePrefix = "OIDC:"
+
+// OIDCProvider
+type OIDCProvider struct {
+	Name          string   `json:"-"`
+	Issuer        string   `json:"issuer"`
+	ClientID      string   `json:"clientId"`
+	ClientSecret  string   `json:"clientSecret"`
+	RedirectURL   string   `json:"redirectUrl"`
+	Scopes        []string `json:"scopes"`
+	UsernameClaim string   `json:"usernameClaim"`
+	AutoProvision bool     `json:"autoProvision"`
+	LinkExisting  bool     `json:"linkExisting"`
+	SuccessURL    string   `json:"successUrl"`
+	// TwoFactorURL 需要双因素认证时跳转的页面，挑战码等参数附加在URL片段中，默认为SuccessURL
+	TwoFactorURL string `json:"twoFactorUrl"`
+	// SkipTwoFactor 信任身份提供方的认证，跳过双因素认证
+	SkipTwoFactor bool `json:"skipTwoFactor"`
+}
+
+// OIDCDiscovery
+type OIDCDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcDiscoveryItem struct {
+	doc       *OIDCDiscovery
+	fetchedAt time.Time
+}
+
+// OIDCTokenResponse
+type OIDCTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+}
+
+// OIDCProviders 读取oidc配置
+func OIDCProviders() map[string]*OIDCProvider {
+	providers := make(map[string]*OIDCProvider)
+	C().GetInterface("oidc", &providers)
+	for name, p := range providers {
+		p.Name = name
+	}
+	return providers
+}
+
+// GetOIDCProvider
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	if p, ok := OIDCProviders()[name]; ok && p.Issuer != "" {
+		return p, nil
+	}
+	return nil, fmt.Errorf("oidc provider not found: %s", name)
+}
+
+// OIDCSignType
+func OIDCSignType(provider string) string {
+	return OIDCSignTypePrefix + strings.ToUpper(provider)
+}
+
+// Discover 获取发现文档
+func (p *OIDCProvider) Discover() (*OIDCDiscovery, error) {
+	issuer := strings.TrimSuffix(p.Issuer, "/")
+	if v, ok := oidcDiscoveryCache.Load(issuer); ok {
+		item := v.(*oidcDiscoveryItem)
+		if time.Since(item.fetchedAt) < OIDCDiscoveryExpires {
+			return item.doc, nil
+		}
+	}
+	resp, err := OIDCHTTPClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed: %s", resp.Status)
+	}
+	var doc OIDCDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("oidc issuer mismatch: %s", doc.Issuer)
+	}
+	oidcDiscoveryCache.Store(issuer, &oidcDiscoveryItem{doc: &doc, fetchedAt: time.Now()})
+	return &doc, nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL 生成授权地址（PKCE S256）
+func (p *OIDCProvider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	doc, err := p.Discover()
+	if err != nil {
+		return "", err
+	}
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.ClientID)
+	values.Set("redirect_uri", p.RedirectURL)
+	values.Set("scope", strings.Join(scopes, " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", pkceChallenge(verifier))
+	values.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + values.Encode(), nil
+}
+
+// Exchange 使用授权码换取令牌
+func (p *OIDCProvider) Exchange(code, verifier string) (*OIDCTokenResponse, error) {
+	doc, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", p.RedirectURL)
+	values.Set("client_id", p.ClientID)
+	values.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := OIDCHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange failed: %s", resp.Status)
+	}
+	var ret OIDCTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, err
+	}
+	if ret.IDToken == "" {
+		return nil, errors.New("oidc token response has no id_token")
+	}
+	return &ret, nil
+}
+
+func claimsHasAudience(claims jwt.MapClaims, aud string) bool {
+	switch v := claims["aud"].(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyIDToken 通过JWKS校验ID令牌的签名、签发者、受众、有效期和nonce
+func (p *OIDCProvider) VerifyIDToken(raw, nonce string) (jwt.MapClaims, error) {
+	doc, err := p.Discover()
+	if err != nil {
+		return nil, err
+	}
+	claims := make(jwt.MapClaims)
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}}
+	_, err = parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		set, err := FetchJWKS(OIDCHTTPClient, doc.JWKSURI)
+		if err != nil {
+			return nil, err
+		}
+		key := set.Find(kid)
+		if key == nil {
+			// 密钥轮换后重新获取
+			if set, err = FetchJWKS(OIDCHTTPClient, doc.JWKSURI, true); err != nil {
+				return nil, err
+			}
+			if key = set.Find(kid); key == nil {
+				return nil, fmt.Errorf("jwk not found: %s", kid)
+			}
+		}
+		return key.PublicKey()
+	})
+	if err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(doc.Issuer, "/") {
+		return nil, fmt.Errorf("invalid id_token issuer: %s", iss)
+	}
+	if !claimsHasAudience(claims, p.ClientID) {
+		return nil, errors.New("invalid id_token audience")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id_token has no exp")
+	}
+	if v, _ := claims["nonce"].(string); nonce != "" && v != nonce {
+		return nil, errors.New("invalid id_token nonce")
+	}
+	if v, _ := claims["sub"].(string); v == "" {
+		return nil, errors.New("id_token has no sub")
+	}
+	return claims, nil
+}
+
+// OIDCMapUser 将身份声明映射到新建用户，可覆盖
+var OIDCMapUser = func(provider *OIDCProvider, claims jwt.MapClaims, user *User) {
+	if v, ok := claims["name"].(string); ok {
+		user.Name = v
+	}
+	if v, ok := claims["email"].(string); ok {
+		user.Email = v
+	}
+	if v, ok := claims["picture"].(string); ok {
+		user.Avatar = v
+	}
+	if v, ok := claims["phone_number"].(string); ok {
+		user.Mobile = v
+	}
+}
+
+func (p *OIDCProvider) username(claims jwt.MapClaims) string {
+	keys := []string{"preferred_username", "email", "sub"}
+	if p.UsernameClaim != "" {
+		keys = []string{p.UsernameClaim}
+	}
+	for _, key := range keys {
+		if v, ok := claims[key].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ResolveUser 根据身份声明查找或创建用户，并将身份ID记录到SubDocIDs
+func (p *OIDCProvider) ResolveUser(claims jwt.MapClaims) (user *User, identity *UserIdentity, err error) {
+	subject, _ := claims["sub"].(string)
+	user = &User{}
+	identity = &UserIdentity{}
+	signType := OIDCSignType(p.Name)
+	err = WithTransaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&UserIdentity{Provider: p.Name, Subject: subject}).First(identity).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		if identity.ID != 0 {
+			return tx.Where("id = ?", identity.UID).First(user).Error
+		}
+		username := p.username(claims)
+		if username == "" {
+			return errors.New("oidc username claim is empty")
+		}
+		if err := tx.Where(&User{Username: username}).First(user).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		if user.ID != 0 {
+			// 同名本地账号须显式允许关联，避免账号被冒用
+			if !p.LinkExisting {
+				return fmt.Errorf("oidc user conflicts with local account: %s", username)
+			}
+		} else {
+			if !p.AutoProvision {
+				return fmt.Errorf("oidc user not provisioned: %s", username)
+			}
+			user = &User{
+				Username:             username,
+				Password:             MD5(RandomHex(16)),
+				IgnorePasswordPolicy: true,
+			}
+			OIDCMapUser(p, claims, user)
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+		}
+		*identity = UserIdentity{
+			UID:      user.ID,
+			Provider: p.Name,
+			Subject:  subject,
+			Claims:   JSONStringify(claims),
+		}
+		if v, ok := claims["email"].(string); ok {
+			identity.Email = v
+		}
+		if err := tx.Create(identity).Error; err != nil {
+			return err
+		}
+		if err := user.SetSubDocID(signType, identity.ID); err != nil {
+			return err
+		}
+		return tx.Model(user).Updates(&User{SubDocIDs: user.SubDocIDs}).Error
+	})
+	return
+}
+
+type oidcState struct {
+	Provider string
+	Nonce    string
+	Verifier string
+	Redirect string
+}
+
+func oidcStateKey(state string) string {
+	return fmt.Sprintf("oidc_state_%s", state)
+}
+
+// 仅允许站内相对路径，避免开放重定向
+var oidcRedirectRegexp = regexp.MustCompile(`^/[^/\\]`)
+
+// OIDCProvidersRoute
+var OIDCProvidersRoute = RouteInfo{
+	Name:   "查询单点登录提供方",
+	Method: "GET",
+	Path:   "/oidc/providers",
+	HandlerFunc: func(c *Context) *STDReply {
+		var names []string
+		for name := range OIDCProviders() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return c.STD(names)
+	},
+}
+
+// OIDCLoginRoute
+var OIDCLoginRoute = RouteInfo{
+	Name:   "单点登录跳转接口",
+	Method: "GET",
+	Path:   "/oidc/login/:provider",
+	IntlMessages: map[string]string{
+		"acc_oidc_failed": "Single sign-on failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		provider, err := GetOIDCProvider(c.Param("provider"))
+		if err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		state := oidcState{
+			Provider: provider.Name,
+			Nonce:    RandomHex(16),
+			Verifier: RandomHex(32),
+		}
+		if v := c.Query("redirect"); oidcRedirectRegexp.MatchString(v) {
+			state.Redirect = v
+		}
+		key := RandomHex(16)
+		authURL, err := provider.AuthCodeURL(key, state.Nonce, state.Verifier)
+		if err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		SetCacheString(oidcStateKey(key), JSONStringify(state), time.Second*time.Duration(OIDCStateExpires))
+		c.Redirect(http.StatusFound, authURL)
+		return nil
+	},
+}
+
+// OIDCCallbackRoute
+var OIDCCallbackRoute = RouteInfo{
+	Name:   "单点登录回调接口",
+	Method: "GET",
+	Path:   "/oidc/callback/:provider",
+	IntlMessages: map[string]string{
+		"acc_oidc_failed": "Single sign-on failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		if v := c.Query("error"); v != "" {
+			return c.STDErr(fmt.Errorf("oidc error: %s %s", v, c.Query("error_description")), "acc_oidc_failed")
+		}
+		key := c.Query("state")
+		raw := GetCacheString(oidcStateKey(key))
+		if key == "" || raw == "" {
+			return c.STDErr(errors.New("invalid oidc state"), "acc_oidc_failed")
+		}
+		DelCache(oidcStateKey(key))
+		var state oidcState
+		if err := JSONParse(raw, &state); err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		if state.Provider != c.Param("provider") {
+			return c.STDErr(errors.New("oidc provider mismatch"), "acc_oidc_failed")
+		}
+		provider, err := GetOIDCProvider(state.Provider)
+		if err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		ret, err := provider.Exchange(c.Query("code"), state.Verifier)
+		if err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		claims, err := provider.VerifyIDToken(ret.IDToken, state.Nonce)
+		if err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		user, _, err := provider.ResolveUser(claims)
+		if err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		// 与账号密码登录共用锁定状态
+		if reply := loginThrottled(c, user.Username); reply != nil {
+			return reply
+		}
+		if user.Disable.Bool || user.DenyLogin.Bool {
+			return c.STDErr(fmt.Errorf("account deny login: %v", user.ID), "acc_oidc_failed")
+		}
+		resp := &LoginHandlerResponse{
+			UID:           user.ID,
+			Username:      user.Username,
+			Lang:          user.Lang,
+			SignType:      OIDCSignType(provider.Name),
+			Payload:       userPayload(c, user),
+			SkipTwoFactor: provider.SkipTwoFactor,
+		}
+		redirect := state.Redirect
+		if redirect == "" {
+			redirect = provider.SuccessURL
+		}
+		if redirect == "" {
+			redirect = "/"
+		}
+		if !resp.SkipTwoFactor {
+			challenge, enrolled, err := beginTwoFactor(resp)
+			if err != nil {
+				return c.STDErr(err, "acc_oidc_failed")
+			}
+			// 由前端完成双因素认证后签发令牌，挑战码放在URL片段中，不会发送到服务端或出现在Referer中
+			if challenge != "" {
+				target := provider.TwoFactorURL
+				if target == "" {
+					target = redirect
+				}
+				fragment := url.Values{
+					"TwoFactorChallenge": {challenge},
+					"TwoFactorEnrolled":  {strconv.FormatBool(enrolled)},
+					"Redirect":           {redirect},
+				}
+				c.Redirect(http.StatusFound, strings.SplitN(target, "#", 2)[0]+"#"+fragment.Encode())
+				return nil
+			}
+		}
+		if _, err := signIn(c, resp); err != nil {
+			return c.STDErr(err, "acc_oidc_failed")
+		}
+		c.Redirect(http.StatusFound, redirect)
+		return nil
+	},
+}
@@ -0,0 +1,177 @@
+package kuu
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type stubIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newStubIssuer(t *testing.T) *stubIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubIssuer{key: key}
+	mux := http.NewServeMux()
+	s.server = httptest.NewServer(mux)
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(JSONStringify(OIDCDiscovery{
+			Issuer:                s.server.URL,
+			AuthorizationEndpoint: s.server.URL + "/authorize",
+			TokenEndpoint:         s.server.URL + "/token",
+			JWKSURI:               s.server.URL + "/jwks",
+		})))
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwk, _ := NewJWK("stub", "RS256", &key.PublicKey)
+		_, _ = w.Write([]byte(JSONStringify(JWKS{Keys: []JWK{*jwk}})))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code") != "stub-code" || pkceChallenge(r.PostForm.Get("code_verifier")) != s.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(JSONStringify(OIDCTokenResponse{
+			AccessToken: "stub-access",
+			TokenType:   "Bearer",
+			IDToken:     s.sign(t, s.claims, "stub"),
+		})))
+	})
+	return s
+}
+
+func (s *stubIssuer) sign(t *testing.T, claims jwt.MapClaims, kid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCProvider(t *testing.T) {
+	issuer := newStubIssuer(t)
+	defer issuer.server.Close()
+
+	provider := &OIDCProvider{
+		Name:        "stub",
+		Issuer:      issuer.server.URL,
+		ClientID:    "kuu",
+		RedirectURL: "http://localhost/oidc/callback/stub",
+	}
+	verifier := RandomHex(32)
+	authURL, err := provider.AuthCodeURL("state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	issuer.challenge = u.Query().Get("code_challenge")
+	if u.Query().Get("code_challenge_method") != "S256" || issuer.challenge != pkceChallenge(verifier) {
+		t.Fatalf("AuthCodeURL() missing PKCE params: %s", authURL)
+	}
+
+	now := time.Now().Unix()
+	issuer.claims = jwt.MapClaims{
+		"iss":   issuer.server.URL,
+		"aud":   []string{"kuu", "other"},
+		"sub":   "1001",
+		"nonce": "nonce",
+		"iat":   now,
+		"exp":   now + 300,
+	}
+	if _, err := provider.Exchange("stub-code", "wrong"); err == nil {
+		t.Errorf("Exchange() should reject wrong code_verifier")
+	}
+	ret, err := provider.Exchange("stub-code", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := provider.VerifyIDToken(ret.IDToken, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "1001" {
+		t.Errorf("VerifyIDToken() sub = %v", claims["sub"])
+	}
+
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+		kid    string
+		nonce  string
+	}{
+		{"nonce", issuer.claims, "stub", "other"},
+		{"audience", jwt.MapClaims{"iss": issuer.server.URL, "aud": "other", "sub": "1001", "exp": now + 300}, "stub", ""},
+		{"issuer", jwt.MapClaims{"iss": "http://evil", "aud": "kuu", "sub": "1001", "exp": now + 300}, "stub", ""},
+		{"expired", jwt.MapClaims{"iss": issuer.server.URL, "aud": "kuu", "sub": "1001", "exp": now - 60}, "stub", ""},
+		{"kid", jwt.MapClaims{"iss": issuer.server.URL, "aud": "kuu", "sub": "1001", "exp": now + 300}, "unknown", ""},
+	}
+	for _, tt := range tests {
+		if _, err := provider.VerifyIDToken(issuer.sign(t, tt.claims, tt.kid), tt.nonce); err == nil {
+			t.Errorf("VerifyIDToken() should reject invalid %s", tt.name)
+		}
+	}
+}
+
+func TestOIDCCallbackEnforcesLoginPolicy(t *testing.T) {
+	defer useTestDB(t, &User{}, &UserIdentity{}, &UserTOTP{}, &UserRecoveryCode{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestMaxSessions(0)()
+	issuer := newStubIssuer(t)
+	defer issuer.server.Close()
+	defer useTestConfig(`{"twoFactor":{"required":true},"oidc":{"stub":{"issuer":"` + issuer.server.URL +
+		`","clientId":"kuu","redirectUrl":"http://localhost/oidc/callback/stub","autoProvision":true,"successUrl":"/home"}}}`)()
+
+	callback := func() (*httptest.ResponseRecorder, *STDReply) {
+		verifier := RandomHex(32)
+		issuer.challenge = pkceChallenge(verifier)
+		issuer.claims = jwt.MapClaims{
+			"iss":                issuer.server.URL,
+			"aud":                "kuu",
+			"sub":                "1001",
+			"preferred_username": "oidc_user",
+			"nonce":              "nonce",
+			"exp":                time.Now().Unix() + 300,
+		}
+		SetCacheString(oidcStateKey("state"), JSONStringify(oidcState{Provider: "stub", Nonce: "nonce", Verifier: verifier}))
+		w := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(w)
+		ginCtx.Request = httptest.NewRequest("GET", "/oidc/callback/stub?state=state&code=stub-code", nil)
+		ginCtx.Params = gin.Params{{Key: "provider", Value: "stub"}}
+		reply := OIDCCallbackRoute.HandlerFunc(&Context{Context: ginCtx})
+		return w, reply
+	}
+
+	// 角色要求双因素认证时返回挑战，不签发令牌
+	w, reply := callback()
+	location, _ := url.Parse(w.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(location.Fragment)
+	if reply != nil || w.Code != http.StatusFound || location.Path != "/home" || fragment.Get("TwoFactorChallenge") == "" {
+		t.Fatalf("expected a two-factor challenge, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	var count int
+	DB().Model(&SignSecret{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected no token before two-factor authentication, got %d", count)
+	}
+
+	// 账号锁定期间拒绝登录
+	SetCacheInt(loginLockedKey("oidc_user"), int(time.Now().Add(time.Hour).Unix()), time.Hour)
+	if _, reply := callback(); reply == nil || reply.Code == 0 {
+		t.Error("expected the locked account to be rejected")
+	}
+}
@@ -0,0 +1,418 @@
+package kuu
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	OutboxStatusPending = "PENDING"
+	OutboxStatusSent    = "SENT"
+	OutboxStatusDead    = "DEAD"
+
+	contextTxKey = "__kuu_tx__"
+)
+
+var (
+	// OutboxPollInterval 中继查询待发布事件的间隔
+	OutboxPollInterval = time.Second
+	// OutboxBatchSize 每次中继的最大事件数
+	OutboxBatchSize = 100
+	// OutboxMaxRetries 最大发布次数，超过后标记为DEAD
+	OutboxMaxRetries = 10
+	// OutboxRetryInterval 首次重试的间隔，之后每次翻倍
+	OutboxRetryInterval = 5 * time.Second
+	// OutboxMaxRetryInterval 重试间隔的上限
+	OutboxMaxRetryInterval = 10 * time.Minute
+	// OutboxRelayLockTTL 中继锁的有效期，多个实例中同一时间只有一个中继
+	OutboxRelayLockTTL = 30 * time.Second
+	// OutboxRetentionDays 已发布事件的保留天数
+	OutboxRetentionDays = 7
+	// OutboxHTTPClient Webhook使用的客户端
+	OutboxHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+	// ErrNoTransaction 不在事务中调用Emit
+	ErrNoTransaction = errors.New("emit must be called within a transaction")
+
+	errOutboxRelayLockLost = errors.New("outbox relay lock lost")
+)
+
+// DomainEvent 领域事件
+type DomainEvent struct {
+	Topic string
+	// Aggregate 聚合标识（如"Order:1"），同一聚合的事件按写入顺序发布，为空时不保证顺序
+	Aggregate string
+	// Payload 为string或[]byte时原样发布，否则序列化为JSON
+	Payload interface{}
+}
+
+// OutboxEvent 发件箱事件，与业务数据在同一事务中写入，提交后由中继发布
+type OutboxEvent struct {
+	Model     `rest:"R" displayName:"发件箱事件"`
+	Topic     string `name:"主题" gorm:"NOT NULL"`
+	Aggregate string `name:"聚合标识" gorm:"INDEX"`
+	Payload   string `name:"事件内容" gorm:"type:text"`
+	Status    string `name:"状态" gorm:"NOT NULL;INDEX" enum:"OutboxStatus"`
+	Attempts  int    `name:"发布次数"`
+	NextAt    int64  `name:"下次发布时间（毫秒）"`
+	LastError string `name:"最后一次错误" gorm:"type:text"`
+	SentAt    int64  `name:"发布时间（毫秒）"`
+	RequestID string `name:"请求ID"`
+}
+
+func init() {
+	Enum("OutboxStatus", "发件箱事件状态").
+		Add(OutboxStatusPending, "待发布").
+		Add(OutboxStatusSent, "已发布").
+		Add(OutboxStatusDead, "发布失败")
+}
+
+// EmitEvent 在事务tx中写入发件箱，事务提交后由中继发布，tx不是事务时返回ErrNoTransaction
+func EmitEvent(tx *gorm.DB, event DomainEvent) error {
+	if tx == nil {
+		return ErrNoTransaction
+	}
+	if _, ok := tx.CommonDB().(*sql.Tx); !ok {
+		return ErrNoTransaction
+	}
+	if event.Topic == "" {
+		return errors.New("event topic is required")
+	}
+	payload, err := busPayload(event.Payload)
+	if err != nil {
+		return err
+	}
+	// 忽略tx上的查询条件
+	return tx.New().Create(&OutboxEvent{
+		Topic:     event.Topic,
+		Aggregate: event.Aggregate,
+		Payload:   payload,
+		Status:    OutboxStatusPending,
+		RequestID: GetRoutineRequestID(),
+	}).Error
+}
+
+// Emit 写入当前业务事务的发件箱，查询等不在事务中的场景返回ErrNoTransaction
+func (scope *Scope) Emit(event DomainEvent) error {
+	return EmitEvent(scope.DB, event)
+}
+
+// Emit 写入c.WithTransaction中事务的发件箱，不在事务中时返回ErrNoTransaction
+func (c *Context) Emit(event DomainEvent) error {
+	v, ok := c.Get(contextTxKey)
+	if !ok {
+		return ErrNoTransaction
+	}
+	return EmitEvent(v.(*gorm.DB), event)
+}
+
+// 执行期间将事务绑定到上下文，供Emit使用
+func (c *Context) bindTx(tx *gorm.DB, fn func() error) error {
+	prev, has := c.Get(contextTxKey)
+	c.Set(contextTxKey, tx)
+	defer func() {
+		if has {
+			c.Set(contextTxKey, prev)
+		} else {
+			delete(c.Keys, contextTxKey)
+		}
+	}()
+	return fn()
+}
+
+// OutboxSink 发件箱事件的发布目标，返回错误时事件将重试
+type OutboxSink interface {
+	Send(event *OutboxEvent) error
+}
+
+// OutboxSinkFunc
+type OutboxSinkFunc func(event *OutboxEvent) error
+
+// Send
+func (fn OutboxSinkFunc) Send(event *OutboxEvent) error {
+	return fn(event)
+}
+
+// EventBusOutboxSink 发布到事件总线的同名主题，默认的发布目标
+type EventBusOutboxSink struct{}
+
+// Send
+func (EventBusOutboxSink) Send(event *OutboxEvent) error {
+	_, err := PublishEvent(event.Topic, event.Payload)
+	return err
+}
+
+// WebhookOutboxSink 以POST请求发送事件内容，事件信息在请求头中，配置Secret时附带HMAC-SHA256签名
+type WebhookOutboxSink struct {
+	URL     string
+	Secret  string
+	Headers map[string]string
+}
+
+// Send
+func (s WebhookOutboxSink) Send(event *OutboxEvent) error {
+	body := []byte(event.Payload)
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("X-Kuu-Event-ID", strconv.FormatUint(uint64(event.ID), 10))
+	req.Header.Set("X-Kuu-Event-Topic", event.Topic)
+	if event.Aggregate != "" {
+		req.Header.Set("X-Kuu-Event-Aggregate", event.Aggregate)
+	}
+	if s.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Kuu-Signature", fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil))))
+	}
+	resp, err := OutboxHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// OutboxWebhook 配置outbox.webhooks中的Webhook
+type OutboxWebhook struct {
+	Topic   string
+	URL     string
+	Secret  string
+	Headers map[string]string
+}
+
+type outboxRoute struct {
+	pattern string
+	sink    OutboxSink
+}
+
+var (
+	outboxRoutes     []outboxRoute
+	outboxRoutesMu   sync.RWMutex
+	outboxConfigOnce sync.Once
+)
+
+// RegisterOutboxSink 为匹配通配符的主题指定发布目标，按注册顺序使用第一个匹配的目标，未匹配时发布到事件总线
+func RegisterOutboxSink(topicPattern string, sink OutboxSink) {
+	outboxRoutesMu.Lock()
+	defer outboxRoutesMu.Unlock()
+	outboxRoutes = append(outboxRoutes, outboxRoute{pattern: topicPattern, sink: sink})
+}
+
+func outboxSinkOf(topic string) OutboxSink {
+	outboxConfigOnce.Do(func() {
+		var webhooks []OutboxWebhook
+		C().GetInterface("outbox.webhooks", &webhooks)
+		for _, item := range webhooks {
+			if item.URL == "" {
+				continue
+			}
+			pattern := item.Topic
+			if pattern == "" {
+				pattern = "*"
+			}
+			RegisterOutboxSink(pattern, WebhookOutboxSink{URL: item.URL, Secret: item.Secret, Headers: item.Headers})
+		}
+	})
+	outboxRoutesMu.RLock()
+	defer outboxRoutesMu.RUnlock()
+	for _, route := range outboxRoutes {
+		if GlobMatch(route.pattern, topic) {
+			return route.sink
+		}
+	}
+	return EventBusOutboxSink{}
+}
+
+func outboxRetryDelay(attempts int) time.Duration {
+	delay := OutboxRetryInterval
+	for i := 1; i < attempts && delay < OutboxMaxRetryInterval; i++ {
+		delay *= 2
+	}
+	if delay > OutboxMaxRetryInterval {
+		delay = OutboxMaxRetryInterval
+	}
+	return delay
+}
+
+// 按ID顺序发布一批待发布事件，同一聚合中有事件等待重试或发布失败时跳过其后的事件，返回状态有变化的事件
+func relayOutboxBatch(events []OutboxEvent, now time.Time, send func(*OutboxEvent) error) (changed []*OutboxEvent) {
+	var (
+		nowMs   = now.UnixNano() / int64(time.Millisecond)
+		blocked = make(map[string]bool)
+	)
+	for i := range events {
+		event := &events[i]
+		if event.Aggregate != "" && blocked[event.Aggregate] {
+			continue
+		}
+		if event.NextAt > nowMs {
+			blocked[event.Aggregate] = true
+			continue
+		}
+		err := send(event)
+		if err == errOutboxRelayLockLost {
+			break
+		}
+		event.Attempts++
+		if err != nil {
+			event.LastError = err.Error()
+			if event.Attempts >= OutboxMaxRetries {
+				event.Status = OutboxStatusDead
+				ERROR("outbox event %d (%s) failed after %d attempts: %v", event.ID, event.Topic, event.Attempts, err)
+			} else {
+				event.NextAt = now.Add(outboxRetryDelay(event.Attempts)).UnixNano() / int64(time.Millisecond)
+			}
+			blocked[event.Aggregate] = true
+		} else {
+			event.Status = OutboxStatusSent
+			event.SentAt = nowMs
+			event.LastError = ""
+		}
+		changed = append(changed, event)
+	}
+	return
+}
+
+// 查询已到发布时间的事件，同一聚合中有更早的事件等待重试或发布失败时不查询，
+// 避免阻塞的聚合占满批次；发布失败的事件需人工重置为PENDING或删除后，其聚合才会继续发布
+func pendingOutboxEvents(now time.Time) *gorm.DB {
+	var (
+		db    = DB().Model(&OutboxEvent{})
+		table = db.NewScope(&OutboxEvent{}).QuotedTableName()
+		nowMs = now.UnixNano() / int64(time.Millisecond)
+	)
+	return db.Where(fmt.Sprintf("status = ? AND next_at <= ? AND (aggregate = '' OR NOT EXISTS ("+
+		"SELECT 1 FROM %s prior WHERE prior.aggregate = %s.aggregate AND prior.id < %s.id AND prior.deleted_at IS NULL "+
+		"AND (prior.status = ? OR (prior.status = ? AND prior.next_at > ?))))", table, table, table),
+		OutboxStatusPending, nowMs, OutboxStatusDead, OutboxStatusPending, nowMs)
+}
+
+// RelayOutboxEvents 发布一批待发布的事件，返回发布成功的数量
+func RelayOutboxEvents() (sent int, err error) {
+	l, err := TryLock("outbox_relay", OutboxRelayLockTTL)
+	if err != nil {
+		if err == ErrLockNotAcquired {
+			err = nil
+		}
+		return
+	}
+	defer func() {
+		if err := l.Unlock(); err != nil && err != ErrLockNotHeld {
+			ERROR(err)
+		}
+	}()
+
+	var (
+		events []OutboxEvent
+		now    = time.Now()
+	)
+	if err = pendingOutboxEvents(now).Order("id").Limit(OutboxBatchSize).Find(&events).Error; err != nil {
+		return
+	}
+	send := func(event *OutboxEvent) error {
+		// 发布前续期，避免锁过期后其他实例打乱顺序
+		if err := l.Extend(OutboxRelayLockTTL); err != nil {
+			WARN("outbox relay stopped: %v", err)
+			return errOutboxRelayLockLost
+		}
+		return outboxSinkOf(event.Topic).Send(event)
+	}
+	for _, event := range relayOutboxBatch(events, now, send) {
+		if event.Status == OutboxStatusSent {
+			sent++
+		}
+		if err = DB().Model(&OutboxEvent{}).Where("id = ?", event.ID).Updates(map[string]interface{}{
+			"status":     event.Status,
+			"attempts":   event.Attempts,
+			"next_at":    event.NextAt,
+			"last_error": event.LastError,
+			"sent_at":    event.SentAt,
+		}).Error; err != nil {
+			return
+		}
+	}
+	return
+}
+
+var (
+	outboxRelayDone chan struct{}
+	outboxRelayWg   sync.WaitGroup
+)
+
+// 启动中继，配置outbox.enabled为false时不启动
+func startOutboxRelay() {
+	if C().Has("outbox.enabled") && !C().GetBool("outbox.enabled") {
+		return
+	}
+	outboxRelayDone = make(chan struct{})
+	outboxRelayWg.Add(1)
+	go func() {
+		defer outboxRelayWg.Done()
+		for {
+			n, err := RelayOutboxEvents()
+			if err != nil {
+				ERROR("outbox relay failed: %v", err)
+			}
+			if n >= OutboxBatchSize {
+				continue
+			}
+			select {
+			case <-outboxRelayDone:
+				return
+			case <-time.After(OutboxPollInterval):
+			}
+		}
+	}()
+}
+
+func releaseOutboxRelay() {
+	if outboxRelayDone != nil {
+		close(outboxRelayDone)
+		outboxRelayWg.Wait()
+		outboxRelayDone = nil
+	}
+}
+
+// PruneOutboxEvents 删除指定天数前已发布的事件
+func PruneOutboxEvents(days int) (int64, error) {
+	if days <= 0 {
+		return 0, nil
+	}
+	before := time.Now().AddDate(0, 0, -days).UnixNano() / int64(time.Millisecond)
+	ret := DB().Unscoped().Where("status = ? AND sent_at < ?", OutboxStatusSent, before).Delete(&OutboxEvent{})
+	return ret.RowsAffected, ret.Error
+}
+
+func initOutboxJobs() error {
+	_, err := AddJob("@daily", "Prune outbox events", func(c *JobContext) {
+		days := C().DefaultGetInt("outbox.retentionDays", OutboxRetentionDays)
+		if n, err := PruneOutboxEvents(days); err != nil {
+			c.Error(err)
+		} else if n > 0 {
+			INFO("Pruned %d outbox events older than %d days", n, days)
+		}
+	})
+	return err
+}
@@ -0,0 +1,215 @@
+package kuu
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRelayOutboxBatchOrdering(t *testing.T) {
+	now := time.Now()
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	events := []OutboxEvent{
+		{Model: Model{ID: 1}, Topic: "order", Aggregate: "Order:1"},
+		{Model: Model{ID: 2}, Topic: "order", Aggregate: "Order:2", NextAt: nowMs + 1000},
+		{Model: Model{ID: 3}, Topic: "order", Aggregate: "Order:1"},
+		{Model: Model{ID: 4}, Topic: "order", Aggregate: "Order:2"},
+		{Model: Model{ID: 5}, Topic: "order", Aggregate: "Order:3"},
+		{Model: Model{ID: 6}, Topic: "order"},
+	}
+	var sent []uint
+	changed := relayOutboxBatch(events, now, func(event *OutboxEvent) error {
+		sent = append(sent, event.ID)
+		if event.ID == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+
+	// Order:1失败后跳过其后续事件，Order:2等待重试
+	if !reflect.DeepEqual(sent, []uint{1, 5, 6}) {
+		t.Errorf("unexpected sent events: %v", sent)
+	}
+	if len(changed) != 3 {
+		t.Fatalf("expected 3 changed events, got %d", len(changed))
+	}
+	if e := changed[0]; e.Status != "" || e.Attempts != 1 || e.LastError != "unavailable" || e.NextAt != nowMs+int64(OutboxRetryInterval/time.Millisecond) {
+		t.Errorf("unexpected failed event: %+v", e)
+	}
+	if e := changed[1]; e.Status != OutboxStatusSent || e.SentAt != nowMs {
+		t.Errorf("unexpected sent event: %+v", e)
+	}
+}
+
+func TestRelayOutboxBatchDead(t *testing.T) {
+	events := []OutboxEvent{
+		{Model: Model{ID: 1}, Topic: "order", Aggregate: "Order:1", Attempts: OutboxMaxRetries - 1},
+		{Model: Model{ID: 2}, Topic: "order", Aggregate: "Order:1"},
+	}
+	var sent []uint
+	changed := relayOutboxBatch(events, time.Now(), func(event *OutboxEvent) error {
+		sent = append(sent, event.ID)
+		if event.ID == 1 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	// 发布失败的事件继续阻塞同一聚合的后续事件
+	if !reflect.DeepEqual(sent, []uint{1}) || len(changed) != 1 || changed[0].Status != OutboxStatusDead {
+		t.Errorf("unexpected result: %v %+v", sent, changed)
+	}
+
+	events = []OutboxEvent{{Model: Model{ID: 1}}, {Model: Model{ID: 2}}}
+	changed = relayOutboxBatch(events, time.Now(), func(event *OutboxEvent) error {
+		return errOutboxRelayLockLost
+	})
+	if len(changed) != 0 || events[0].Attempts != 0 {
+		t.Error("events changed after the relay lock was lost")
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  OutboxRetryInterval,
+		2:  2 * OutboxRetryInterval,
+		3:  4 * OutboxRetryInterval,
+		20: OutboxMaxRetryInterval,
+	}
+	for attempts, want := range cases {
+		if got := outboxRetryDelay(attempts); got != want {
+			t.Errorf("%d: expected %v, got %v", attempts, want, got)
+		}
+	}
+}
+
+func TestWebhookOutboxSink(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+		status = http.StatusOK
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := WebhookOutboxSink{URL: srv.URL, Secret: "s3cret", Headers: map[string]string{"X-App": "kuu"}}
+	event := &OutboxEvent{Model: Model{ID: 7}, Topic: "order.created", Aggregate: "Order:1", Payload: `{"ID":1}`}
+	if err := sink.Send(event); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(event.Payload))
+	if string(body) != event.Payload ||
+		header.Get("X-Kuu-Event-ID") != "7" ||
+		header.Get("X-Kuu-Event-Topic") != "order.created" ||
+		header.Get("X-Kuu-Event-Aggregate") != "Order:1" ||
+		header.Get("X-App") != "kuu" ||
+		header.Get("X-Kuu-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("unexpected request: %v %s", header, body)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := sink.Send(event); err == nil {
+		t.Error("expected error for non-2xx response")
+	}
+}
+
+func TestOutboxSinkRouting(t *testing.T) {
+	outboxConfigOnce.Do(func() {})
+	routes := outboxRoutes
+	defer func() { outboxRoutes = routes }()
+
+	webhook := WebhookOutboxSink{URL: "http://localhost/hook"}
+	RegisterOutboxSink("order.*", webhook)
+	RegisterOutboxSink("*", OutboxSinkFunc(func(*OutboxEvent) error { return nil }))
+
+	if sink, ok := outboxSinkOf("order.created").(WebhookOutboxSink); !ok || sink.URL != webhook.URL {
+		t.Errorf("unexpected sink: %#v", sink)
+	}
+	if _, ok := outboxSinkOf("user.created").(OutboxSinkFunc); !ok {
+		t.Error("expected the fallback sink")
+	}
+	outboxRoutes = nil
+	if _, ok := outboxSinkOf("user.created").(EventBusOutboxSink); !ok {
+		t.Error("expected the event bus sink")
+	}
+}
+
+func TestContextEmitWithoutTransaction(t *testing.T) {
+	c := &Context{Context: &gin.Context{}}
+	if err := c.Emit(DomainEvent{Topic: "order.created"}); err != ErrNoTransaction {
+		t.Errorf("expected ErrNoTransaction, got %v", err)
+	}
+	if err := EmitEvent(nil, DomainEvent{Topic: "order.created"}); err != ErrNoTransaction {
+		t.Errorf("expected ErrNoTransaction, got %v", err)
+	}
+}
+
+func TestPendingOutboxEvents(t *testing.T) {
+	defer useTestDB(t, &OutboxEvent{})()
+
+	now := time.Now()
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	for _, event := range []OutboxEvent{
+		{Topic: "order", Aggregate: "Order:1", Status: OutboxStatusPending, NextAt: nowMs + 60000},
+		{Topic: "order", Aggregate: "Order:1", Status: OutboxStatusPending},
+		{Topic: "order", Aggregate: "Order:2", Status: OutboxStatusDead},
+		{Topic: "order", Aggregate: "Order:2", Status: OutboxStatusPending},
+		{Topic: "order", Aggregate: "Order:3", Status: OutboxStatusSent},
+		{Topic: "order", Aggregate: "Order:3", Status: OutboxStatusPending},
+		{Topic: "order", Status: OutboxStatusPending},
+	} {
+		event := event
+		if err := DB().Create(&event).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 等待重试和发布失败的聚合不占用批次
+	var events []OutboxEvent
+	if err := pendingOutboxEvents(now).Order("id").Limit(2).Find(&events).Error; err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	if !reflect.DeepEqual(ids, []uint{6, 7}) {
+		t.Errorf("unexpected pending events: %v", ids)
+	}
+}
+
+func TestEmitEventRequiresTransaction(t *testing.T) {
+	defer useTestDB(t, &OutboxEvent{})()
+
+	if err := EmitEvent(DB(), DomainEvent{Topic: "order.created"}); err != ErrNoTransaction {
+		t.Errorf("expected ErrNoTransaction, got %v", err)
+	}
+	// 查询场景的业务作用域不在事务中
+	scope := &Scope{DB: DB().Model(&OutboxEvent{}).Offset(10).Limit(10)}
+	if err := scope.Emit(DomainEvent{Topic: "order.created"}); err != ErrNoTransaction {
+		t.Errorf("expected ErrNoTransaction, got %v", err)
+	}
+	tx := DB().Begin()
+	if err := EmitEvent(tx.Where("id = ?", 1).Offset(10).Limit(10), DomainEvent{Topic: "order.created", Payload: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	DB().Model(&OutboxEvent{}).Where("status = ?", OutboxStatusPending).Count(&count)
+	if count != 1 {
+		t.Errorf("expected 1 pending event, got %d", count)
+	}
+}
@@ -0,0 +1,191 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+// PasswordPolicy
+type PasswordPolicy struct {
+	// 最低密码强度评分（由客户端在摘要前计算，仅校验自助修改和重置密码）
+	MinScore int `json:"minScore"`
+	// 禁止重复使用最近N次的密码
+	History int `json:"history"`
+	// 密码最长有效天数
+	MaxAgeDays int `json:"maxAgeDays"`
+}
+
+// GetPasswordPolicy
+func GetPasswordPolicy() (policy PasswordPolicy) {
+	C().GetInterface("passwordPolicy", &policy)
+	return
+}
+
+// CheckPasswordPolicy 校验新密码（客户端摘要）的强度和历史记录
+func CheckPasswordPolicy(db *gorm.DB, user *User) error {
+	policy := GetPasswordPolicy()
+	// 管理员创建、导入等场景无法得知明文强度，不校验评分
+	if policy.MinScore > 0 && user.checkPasswordScore && user.PasswordScore < policy.MinScore {
+		return ErrPasswordTooWeak
+	}
+	if policy.History > 0 && user.ID != 0 {
+		password := strings.ToLower(user.Password)
+		var current User
+		if err := db.Select("password").Where("id = ?", user.ID).First(&current).Error; err != nil && !gorm.IsRecordNotFoundError(err) {
+			return err
+		}
+		if current.Password != "" && CompareHashAndPassword(current.Password, password) == nil {
+			return ErrPasswordReused
+		}
+		var histories []PasswordHistory
+		if err := db.Where(&PasswordHistory{UID: user.ID}).Order("id desc").Limit(policy.History).Find(&histories).Error; err != nil {
+			return err
+		}
+		for _, item := range histories {
+			if CompareHashAndPassword(item.Password, password) == nil {
+				return ErrPasswordReused
+			}
+		}
+	}
+	return nil
+}
+
+func savePasswordHistory(db *gorm.DB, uid uint, hashed string) error {
+	policy := GetPasswordPolicy()
+	if policy.History <= 0 || uid == 0 {
+		return nil
+	}
+	if err := db.Create(&PasswordHistory{UID: uid, Password: hashed}).Error; err != nil {
+		return err
+	}
+	// 清理超出数量的历史记录
+	var expired []PasswordHistory
+	if err := db.Select("id").Where(&PasswordHistory{UID: uid}).Order("id desc").Offset(policy.History).Limit(1000).Find(&expired).Error; err != nil {
+		return err
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	var ids []uint
+	for _, item := range expired {
+		ids = append(ids, item.ID)
+	}
+	return db.Unscoped().Where("id in (?)", ids).Delete(&PasswordHistory{}).Error
+}
+
+// PasswordExpired 判断密码是否超过最长有效期
+func PasswordExpired(user *User) bool {
+	policy := GetPasswordPolicy()
+	if policy.MaxAgeDays <= 0 {
+		return false
+	}
+	changedAt := user.PasswordChangedAt
+	if changedAt == 0 {
+		changedAt = user.CreatedAt.Unix()
+	}
+	return time.Now().Unix() > changedAt+int64(policy.MaxAgeDays)*86400
+}
+
+// ChangePasswordRoute
+var ChangePasswordRoute = RouteInfo{
+	Name:   "修改密码接口",
+	Method: "POST",
+	Path:   "/password/change",
+	IntlMessages: map[string]string{
+		"acc_password_change_failed": "Change password failed",
+		"acc_password_failed":        "Incorrect username or password.",
+		"acc_password_too_weak":      "Password is too weak",
+		"acc_password_reused":        "Password has been used recently",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Username      string
+			OldPassword   string
+			NewPassword   string
+			PasswordScore int
+			CaptchaID     string `json:"captcha_id"`
+			CaptchaValue  string `json:"captcha_val"`
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_password_change_failed")
+		}
+		if len(body.NewPassword) != 32 {
+			return c.STDErr(fmt.Errorf("invalid password digest"), "acc_password_change_failed")
+		}
+		// 已登录时修改当前用户，密码过期时凭账号和旧密码修改
+		var (
+			user         User
+			query        = &User{Username: body.Username}
+			currentToken string
+		)
+		if sign, err := c.DecodedContext(); err == nil && sign.IsValid() {
+			query = &User{ID: sign.UID}
+			currentToken = sign.Token
+		} else if body.Username == "" {
+			return c.STDErrWithCode(err, 555, "acc_please_login", "Please login")
+		}
+		if err := DB().Where(query).First(&user).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				RecordLoginFailure(c, body.Username)
+			}
+			return c.STDErr(err, "acc_password_failed")
+		}
+		// 与登录共用限流和锁定，避免通过此接口猜测密码
+		if reply := loginThrottled(c, user.Username); reply != nil {
+			return reply
+		}
+		failedTimesKey := getFailedTimesKey(user.Username)
+		if failedTimesValid(GetCacheInt(failedTimesKey)) {
+			if body.CaptchaID == "" {
+				body.CaptchaID = ParseCaptchaID(c)
+			}
+			if body.CaptchaValue == "" {
+				body.CaptchaValue = ParseCaptchaValue(c)
+			}
+			if !VerifyCaptcha(body.CaptchaID, body.CaptchaValue) {
+				return c.STDErr(fmt.Errorf("incorrect captcha code: input_id = %s, input_value = %s", body.CaptchaID, body.CaptchaValue), "incorrect_captcha_code", "Incorrect captcha code.")
+			}
+		}
+		if user.Disable.Bool || user.DenyLogin.Bool {
+			return c.STDErr(fmt.Errorf("account deny login: %v", user.ID), "acc_password_failed")
+		}
+		if err := CompareHashAndPassword(user.Password, strings.ToLower(body.OldPassword)); err != nil {
+			SetCacheInt(failedTimesKey, GetCacheInt(failedTimesKey)+1, time.Duration(GetLoginThrottle().ResetSeconds)*time.Second)
+			RecordLoginFailure(c, user.Username)
+			return c.STDErr(err, "acc_password_failed")
+		}
+		user.PasswordScore = body.PasswordScore
+		user.checkPasswordScore = true
+		err := c.WithTransaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Updates(&User{Password: strings.ToLower(body.NewPassword)}).Error; err != nil {
+				return err
+			}
+			// 吊销除当前令牌外的所有会话
+			secrets, err := ActiveSessions(tx, user.ID)
+			if err != nil {
+				return err
+			}
+			var targets []SignSecret
+			for _, item := range secrets {
+				if item.Token != currentToken {
+					targets = append(targets, item)
+				}
+			}
+			return RevokeSignSecrets(tx, targets)
+		})
+		if err != nil {
+			switch err {
+			case ErrPasswordTooWeak:
+				return c.STDErr(err, "acc_password_too_weak")
+			case ErrPasswordReused:
+				return c.STDErr(err, "acc_password_reused")
+			}
+			return c.STDErr(err, "acc_password_change_failed")
+		}
+		DelCache(failedTimesKey)
+		return c.STDOK()
+	},
+}
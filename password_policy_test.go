@@ -0,0 +1,69 @@
+package kuu
+
+import (
+	"testing"
+)
+
+func TestPasswordPolicyScore(t *testing.T) {
+	defer useTestDB(t, &User{}, &PasswordResetToken{}, &PasswordHistory{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestConfig(`{"passwordPolicy":{"minScore":3}}`)()
+	notifier, restore := usePasswordResetNotifier()
+	defer restore()
+
+	// 管理员创建和修改用户时不校验评分
+	user := createTestUser(t, "score")
+	if err := DB().Model(&user).Updates(&User{Password: MD5("admin set")}).Error; err != nil {
+		t.Fatalf("unexpected error for admin update: %v", err)
+	}
+
+	if err := RequestPasswordReset("score", "127.0.0.1"); err != nil || len(notifier.messages) != 1 {
+		t.Fatalf("unexpected result: %v %d", err, len(notifier.messages))
+	}
+	token := notifier.messages[0].Body
+	if err := ConfirmPasswordReset(token, MD5("weak"), 0); err != ErrPasswordTooWeak {
+		t.Errorf("expected ErrPasswordTooWeak, got %v", err)
+	}
+	if err := ConfirmPasswordReset(token, MD5("weak"), 2); err != ErrPasswordTooWeak {
+		t.Errorf("expected ErrPasswordTooWeak, got %v", err)
+	}
+	// 校验失败时令牌不被消耗
+	if err := ConfirmPasswordReset(token, MD5("strong"), 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPasswordPolicyHistory(t *testing.T) {
+	defer useTestDB(t, &User{}, &PasswordHistory{})()
+	defer useTestConfig(`{"passwordPolicy":{"history":2}}`)()
+
+	user := User{Username: "history", Password: MD5("a")}
+	if err := DB().Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	change := func(password string) error {
+		return DB().Model(&user).Updates(&User{Password: MD5(password)}).Error
+	}
+	if err := change("a"); err != ErrPasswordReused {
+		t.Errorf("expected the current password to be rejected, got %v", err)
+	}
+	if err := change("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := change("a"); err != ErrPasswordReused {
+		t.Errorf("expected a recent password to be rejected, got %v", err)
+	}
+	for _, password := range []string{"c", "d"} {
+		if err := change(password); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var count int
+	DB().Model(&PasswordHistory{}).Where(&PasswordHistory{UID: user.ID}).Count(&count)
+	if count != 2 {
+		t.Errorf("expected 2 histories, got %d", count)
+	}
+	// 超出历史数量的密码可以再次使用
+	if err := change("a"); err != nil {
+		t.Errorf("expected an expired history to be reusable, got %v", err)
+	}
+}
@@ -0,0 +1,203 @@
+package kuu
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"time"
+)
+
+var (
+	// PasswordResetExpires 密码重置令牌的默认有效期（秒）
+	PasswordResetExpires = 1800
+	// PasswordResetUserLimit 每个账号每小时允许申请的次数
+	PasswordResetUserLimit = 5
+	// PasswordResetIPLimit 每个IP每小时允许请求的次数
+	PasswordResetIPLimit = 20
+)
+
+// PasswordResetMessage 生成密码重置通知内容，可覆盖
+var PasswordResetMessage = func(user *User, token string, exp int64) *NotifyMessage {
+	link := token
+	if tpl := C().GetString("passwordReset.url"); tpl != "" {
+		link = strings.ReplaceAll(tpl, "{{token}}", token)
+	}
+	return &NotifyMessage{
+		To:      []string{user.Email},
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the following link to reset your password, it expires at %s:\n\n%s\n\nIf you did not request a password reset, please ignore this message.\n",
+			user.Username, time.Unix(exp, 0).Format("2006-01-02 15:04:05"), link),
+	}
+}
+
+// 超出频率限制返回false
+func passwordResetAllowed(c *Context, username string) bool {
+	if incrCacheWindow(fmt.Sprintf("password_reset_ip_%s", c.ClientIP()), time.Hour) > C().DefaultGetInt("passwordReset.ipLimit", PasswordResetIPLimit) {
+		return false
+	}
+	if username == "" {
+		return true
+	}
+	return incrCacheWindow(strings.ToLower(fmt.Sprintf("password_reset_user_%s", username)), time.Hour) <= C().DefaultGetInt("passwordReset.userLimit", PasswordResetUserLimit)
+}
+
+// RequestPasswordReset 生成密码重置令牌并发送通知，账号不存在时静默返回
+// 耗时取决于账号是否存在，处理请求时应异步调用，避免响应时间暴露账号
+func RequestPasswordReset(username, ip string) error {
+	notifier := GetNotifier()
+	if notifier == nil {
+		return errors.New("notifier not configured")
+	}
+	var user User
+	if err := DB().Where(&User{Username: username}).First(&user).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	if user.Disable.Bool || user.DenyLogin.Bool || user.Email == "" {
+		return nil
+	}
+	token := RandomHex(32)
+	record := PasswordResetToken{
+		UID:       user.ID,
+		TokenHash: Sha256(token),
+		IP:        ip,
+		Exp:       time.Now().Add(time.Second * time.Duration(C().DefaultGetInt("passwordReset.expires", PasswordResetExpires))).Unix(),
+	}
+	err := WithTransaction(func(tx *gorm.DB) error {
+		// 作废此前未使用的令牌
+		if err := tx.Model(&PasswordResetToken{}).
+			Where(map[string]interface{}{"uid": user.ID, "used_at": 0}).
+			UpdateColumn("used_at", time.Now().Unix()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&record).Error
+	})
+	if err != nil {
+		return err
+	}
+	return notifier.Notify(PasswordResetMessage(&user, token, record.Exp))
+}
+
+// ConfirmPasswordReset 校验重置令牌并设置新密码（客户端摘要），成功后吊销该用户的所有会话
+func ConfirmPasswordReset(token, password string, score int) error {
+	if token == "" || len(password) != 32 {
+		return ErrInvalidPasswordResetToken
+	}
+	return WithTransaction(func(tx *gorm.DB) error {
+		var record PasswordResetToken
+		if err := tx.Where(&PasswordResetToken{TokenHash: Sha256(token)}).First(&record).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return ErrInvalidPasswordResetToken
+			}
+			return err
+		}
+		now := time.Now().Unix()
+		if record.UsedAt != 0 || record.Exp < now {
+			return ErrInvalidPasswordResetToken
+		}
+		// 带条件更新，保证令牌只能使用一次
+		ret := tx.Model(&PasswordResetToken{}).
+			Where(map[string]interface{}{"id": record.ID, "used_at": 0}).
+			UpdateColumn("used_at", now)
+		if ret.Error != nil {
+			return ret.Error
+		}
+		if ret.RowsAffected < 1 {
+			return ErrInvalidPasswordResetToken
+		}
+		var user User
+		if err := tx.Where("id = ?", record.UID).First(&user).Error; err != nil {
+			return err
+		}
+		user.PasswordScore = score
+		user.checkPasswordScore = true
+		if err := tx.Model(&user).Updates(&User{Password: strings.ToLower(password)}).Error; err != nil {
+			return err
+		}
+		secrets, err := ActiveSessions(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if err := RevokeSignSecrets(tx, secrets); err != nil {
+			return err
+		}
+		ClearLoginFailures(user.Username)
+		return nil
+	})
+}
+
+// PasswordResetRequestRoute
+var PasswordResetRequestRoute = RouteInfo{
+	Name:   "申请重置密码",
+	Method: "POST",
+	Path:   "/password/reset/request",
+	IntlMessages: map[string]string{
+		"acc_password_reset_failed":  "Password reset failed",
+		"acc_password_reset_limited": "Too many requests, please try again later",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Username string
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_password_reset_failed")
+		}
+		if body.Username == "" {
+			return c.STDErr(errors.New("username is required"), "acc_password_reset_failed")
+		}
+		if !passwordResetAllowed(c, body.Username) {
+			return c.STDErr(fmt.Errorf("password reset rate limited: %s", c.ClientIP()), "acc_password_reset_limited")
+		}
+		if GetNotifier() == nil {
+			return c.STDErr(errors.New("notifier not configured"), "acc_password_reset_failed")
+		}
+		// 查询账号、生成令牌和发送通知均异步执行，无论账号是否存在均立即返回成功
+		ip := c.ClientIP()
+		go func() {
+			if err := RequestPasswordReset(body.Username, ip); err != nil {
+				ERROR(err)
+			}
+		}()
+		return c.STDOK()
+	},
+}
+
+// PasswordResetConfirmRoute
+var PasswordResetConfirmRoute = RouteInfo{
+	Name:   "确认重置密码",
+	Method: "POST",
+	Path:   "/password/reset/confirm",
+	IntlMessages: map[string]string{
+		"acc_password_reset_failed":  "Password reset failed",
+		"acc_password_reset_limited": "Too many requests, please try again later",
+		"acc_password_too_weak":      "Password is too weak",
+		"acc_password_reused":        "Password has been used recently",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			Token         string
+			NewPassword   string
+			PasswordScore int
+		}
+		if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil {
+			return c.STDErr(err, "acc_password_reset_failed")
+		}
+		if !passwordResetAllowed(c, "") {
+			return c.STDErr(fmt.Errorf("password reset rate limited: %s", c.ClientIP()), "acc_password_reset_limited")
+		}
+		if err := ConfirmPasswordReset(body.Token, body.NewPassword, body.PasswordScore); err != nil {
+			switch err {
+			case ErrPasswordTooWeak:
+				return c.STDErr(err, "acc_password_too_weak")
+			case ErrPasswordReused:
+				return c.STDErr(err, "acc_password_reused")
+			}
+			return c.STDErr(err, "acc_password_reset_failed")
+		}
+		return c.STDOK()
+	},
+}
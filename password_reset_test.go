@@ -0,0 +1,135 @@
+package kuu
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+type testNotifier struct {
+	messages []*NotifyMessage
+}
+
+func (n *testNotifier) Notify(msg *NotifyMessage) error {
+	n.messages = append(n.messages, msg)
+	return nil
+}
+
+func usePasswordResetNotifier() (*testNotifier, func()) {
+	var (
+		notifier    = &testNotifier{}
+		prevNotify  = DefaultNotifier
+		prevMessage = PasswordResetMessage
+	)
+	DefaultNotifier = notifier
+	PasswordResetMessage = func(user *User, token string, exp int64) *NotifyMessage {
+		return &NotifyMessage{To: []string{user.Email}, Body: token}
+	}
+	return notifier, func() {
+		DefaultNotifier = prevNotify
+		PasswordResetMessage = prevMessage
+	}
+}
+
+func createTestUser(t *testing.T, username string) User {
+	user := User{Username: username, Password: GenPassword(), Email: username + "@example.com"}
+	if err := DB().Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestPasswordResetSingleUse(t *testing.T) {
+	defer useTestDB(t, &User{}, &PasswordResetToken{}, &PasswordHistory{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	notifier, restore := usePasswordResetNotifier()
+	defer restore()
+
+	user := createTestUser(t, "reset")
+	sessions := createTestSessions(t, user.ID, 1)
+
+	// 账号不存在时静默返回
+	if err := RequestPasswordReset("nobody", "127.0.0.1"); err != nil || len(notifier.messages) != 0 {
+		t.Fatalf("unexpected result for unknown user: %v %d", err, len(notifier.messages))
+	}
+	if err := RequestPasswordReset("reset", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RequestPasswordReset("reset", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(notifier.messages))
+	}
+	first, second := notifier.messages[0].Body, notifier.messages[1].Body
+
+	// 重新申请后此前的令牌失效
+	password := MD5("new password")
+	if err := ConfirmPasswordReset(first, password, 0); err != ErrInvalidPasswordResetToken {
+		t.Errorf("expected the previous token to be invalid, got %v", err)
+	}
+	if err := ConfirmPasswordReset(second, password, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfirmPasswordReset(second, MD5("another password"), 0); err != ErrInvalidPasswordResetToken {
+		t.Errorf("expected the token to be used once, got %v", err)
+	}
+	if !IsSignRevoked(sessions[0].Secret) {
+		t.Error("expected sessions to be revoked after reset")
+	}
+}
+
+func TestPasswordResetExpired(t *testing.T) {
+	defer useTestDB(t, &User{}, &PasswordResetToken{}, &PasswordHistory{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	notifier, restore := usePasswordResetNotifier()
+	defer restore()
+
+	createTestUser(t, "expired")
+	if err := RequestPasswordReset("expired", "127.0.0.1"); err != nil || len(notifier.messages) != 1 {
+		t.Fatalf("unexpected result: %v %d", err, len(notifier.messages))
+	}
+	DB().Model(&PasswordResetToken{}).UpdateColumn("exp", time.Now().Add(-time.Second).Unix())
+	if err := ConfirmPasswordReset(notifier.messages[0].Body, MD5("new password"), 0); err != ErrInvalidPasswordResetToken {
+		t.Errorf("expected the expired token to be rejected, got %v", err)
+	}
+	if err := ConfirmPasswordReset("", MD5("new password"), 0); err != ErrInvalidPasswordResetToken {
+		t.Errorf("expected an empty token to be rejected, got %v", err)
+	}
+}
+
+func TestSMTPNotifierTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// 接受连接后发送问候语，之后不再响应
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				w := bufio.NewWriter(conn)
+				_, _ = w.WriteString("220 localhost ESMTP\r\n")
+				_ = w.Flush()
+				time.Sleep(2 * time.Second)
+			}()
+		}
+	}()
+	prev := SMTPTimeout
+	SMTPTimeout = 200 * time.Millisecond
+	defer func() { SMTPTimeout = prev }()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	n := &SMTPNotifier{Host: "127.0.0.1", Port: addr.Port, From: "kuu@example.com"}
+	start := time.Now()
+	if err := n.Notify(&NotifyMessage{To: []string{"a@example.com"}, Subject: "test", Body: "test"}); err == nil {
+		t.Error("expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("notify blocked for %v", elapsed)
+	}
+}
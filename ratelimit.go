@@ -0,0 +1,271 @@
+package kuu
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RateLimitKeyIP 按客户端IP限流（默认）
+	RateLimitKeyIP = "ip"
+	// RateLimitKeyUID 按登录用户限流，未登录时按IP
+	RateLimitKeyUID = "uid"
+	// RateLimitKeyAPIKey 按API Key限流，非API Key请求按IP
+	RateLimitKeyAPIKey = "apikey"
+)
+
+// RateLimit 限流规则，按滑动窗口计数，计数保存在DefaultCache中以便多实例共享
+type RateLimit struct {
+	// Route 匹配的路由，如"POST /api/login"，方法为"*"时匹配所有方法，路径支持":name"和"*name"参数
+	Route string
+	// Model 匹配的RESTful模型名称，如"User"
+	Model string
+	// Limit 窗口内允许的请求数，为0时不限制
+	Limit int
+	// Window 窗口长度（秒），默认60
+	Window int
+	// KeyBy 限流维度：ip、uid、apikey或通过RegisterRateLimitKeyFunc注册的名称
+	KeyBy string
+	// KeyFunc 自定义限流维度，优先于KeyBy
+	KeyFunc func(*Context) string `json:"-"`
+
+	compiled bool
+	method   string
+	pattern  routePattern
+}
+
+// RateLimitConfig 对应配置rateLimit
+type RateLimitConfig struct {
+	// Global 对所有请求生效的规则
+	Global *RateLimit
+	// Rules 按路由或模型匹配的规则，第一条匹配的规则生效
+	Rules []RateLimit
+}
+
+type rateLimitResult struct {
+	key        string
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Time
+	retryAfter time.Duration
+}
+
+var (
+	rateLimitKeyFuncs   = make(map[string]func(*Context) string)
+	rateLimitKeyFuncsMu sync.RWMutex
+
+	// 请求方法和路径对应的RESTful模型名称
+	restModelRoutes = make(map[string]string)
+
+	rateLimitConfig     RateLimitConfig
+	rateLimitConfigOnce sync.Once
+)
+
+// RegisterRateLimitKeyFunc 注册自定义限流维度，返回空字符串时按IP
+func RegisterRateLimitKeyFunc(name string, fn func(*Context) string) {
+	rateLimitKeyFuncsMu.Lock()
+	defer rateLimitKeyFuncsMu.Unlock()
+	rateLimitKeyFuncs[strings.ToLower(name)] = fn
+}
+
+// GetRateLimitConfig
+func GetRateLimitConfig() (config RateLimitConfig) {
+	C().GetInterface("rateLimit", &config)
+	return
+}
+
+// 首次使用时解析配置并预编译规则的路由，修改配置后需重启生效
+func loadRateLimitConfig() *RateLimitConfig {
+	rateLimitConfigOnce.Do(func() {
+		rateLimitConfig = GetRateLimitConfig()
+		for i := range rateLimitConfig.Rules {
+			rateLimitConfig.Rules[i].compile()
+		}
+	})
+	return &rateLimitConfig
+}
+
+func (r *RateLimit) window() time.Duration {
+	if r.Window <= 0 {
+		return time.Minute
+	}
+	return time.Duration(r.Window) * time.Second
+}
+
+func (r *RateLimit) subject(c *Context) string {
+	var key string
+	if r.KeyFunc != nil {
+		key = r.KeyFunc(c)
+	} else {
+		switch strings.ToLower(r.KeyBy) {
+		case "", RateLimitKeyIP:
+		case RateLimitKeyUID:
+			if sign, err := c.DecodedContext(); err == nil && sign.IsValid() {
+				key = fmt.Sprintf("uid_%d", sign.UID)
+			}
+		case RateLimitKeyAPIKey:
+			if sign, err := c.DecodedContext(); err == nil && sign.IsValid() && sign.Secret != nil && sign.Secret.IsAPIKey.Bool {
+				key = fmt.Sprintf("apikey_%d", sign.Secret.ID)
+			}
+		default:
+			rateLimitKeyFuncsMu.RLock()
+			fn := rateLimitKeyFuncs[strings.ToLower(r.KeyBy)]
+			rateLimitKeyFuncsMu.RUnlock()
+			if fn != nil {
+				key = fn(c)
+			} else {
+				WARN("rate limit key func not found: %s", r.KeyBy)
+			}
+		}
+	}
+	if key == "" {
+		key = fmt.Sprintf("ip_%s", c.ClientIP())
+	}
+	return key
+}
+
+func (r *RateLimit) compile() {
+	r.method, r.pattern = "*", compileRoutePattern(r.Route)
+	if fields := strings.Fields(r.Route); len(fields) == 2 {
+		r.method, r.pattern = strings.ToUpper(fields[0]), compileRoutePattern(fields[1])
+	}
+	r.compiled = true
+}
+
+func (r *RateLimit) match(method, requestPath string) bool {
+	if r.Model != "" {
+		return strings.EqualFold(restModelRoutes[fmt.Sprintf("%s %s", method, requestPath)], r.Model)
+	}
+	if r.Route == "" {
+		return false
+	}
+	if !r.compiled {
+		r.compile()
+	}
+	return (r.method == "*" || r.method == method) && r.pattern.match(requestPath)
+}
+
+// 查找请求适用的规则：全局规则及路由上的规则或第一条匹配的配置规则
+func matchRateLimits(c *Context) (names []string, limits []*RateLimit) {
+	config := loadRateLimitConfig()
+	if config.Global != nil && config.Global.Limit > 0 {
+		names = append(names, "global")
+		limits = append(limits, config.Global)
+	}
+	method, requestPath := c.Request.Method, c.Request.URL.Path
+	if info := matchRouteInfo(method, requestPath); info != nil && info.RateLimit != nil && info.RateLimit.Limit > 0 {
+		names = append(names, fmt.Sprintf("route_%s", info.Name))
+		limits = append(limits, info.RateLimit)
+		return
+	}
+	for i := range config.Rules {
+		rule := &config.Rules[i]
+		if rule.Limit > 0 && rule.match(method, requestPath) {
+			names = append(names, fmt.Sprintf("rule_%d", i))
+			limits = append(limits, rule)
+			break
+		}
+	}
+	return
+}
+
+// 计数并判断是否超出限制，超出限制的请求不计入计数
+func takeRateLimit(key string, limit int, window time.Duration) (result rateLimitResult) {
+	now := time.Now().UnixNano()
+	idx := now / int64(window)
+	curKey := fmt.Sprintf("%s_%d", key, idx)
+	result.key = curKey
+	prev := GetCacheInt(fmt.Sprintf("%s_%d", key, idx-1))
+	cur := incrCacheWithExpiration(curKey, window*2)
+	elapsed := float64(now%int64(window)) / float64(window)
+	count := slidingWindowEstimate(prev, cur, elapsed)
+
+	result.limit = limit
+	result.reset = time.Unix(0, (idx+1)*int64(window))
+	if count <= limit {
+		result.allowed = true
+		result.remaining = limit - count
+		return
+	}
+	if releaseRateLimit(curKey) {
+		cur--
+	}
+	result.retryAfter = rateLimitRetryAfter(prev, cur, limit, elapsed, window)
+	return
+}
+
+// 撤销一次计数
+func releaseRateLimit(key string) bool {
+	if c, ok := DefaultCache.(StructuredCache); ok {
+		c.IncrBy(key, -1)
+		return true
+	}
+	return false
+}
+
+// 计算被拒绝后需要等待的时间：优先在当前窗口内等待前一窗口的计数衰减，不够时等待到下一窗口
+func rateLimitRetryAfter(prev, cur, limit int, elapsed float64, window time.Duration) time.Duration {
+	w := float64(window)
+	if free := limit - cur - 1; free >= 0 && prev > 0 {
+		if e := 1 - float64(free)/float64(prev); e > elapsed {
+			return time.Duration((e - elapsed) * w)
+		}
+	}
+	var e float64
+	if cur > 0 {
+		e = math.Max(0, 1-float64(limit-1)/float64(cur))
+	}
+	return time.Duration((1 - elapsed + e) * w)
+}
+
+func setRateLimitHeaders(c *Context, result rateLimitResult) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(result.reset.Unix(), 10))
+}
+
+// RateLimitMiddleware 按配置rateLimit及RouteInfo.RateLimit限流，超出限制时返回429
+func RateLimitMiddleware(c *Context) *STDReply {
+	names, limits := matchRateLimits(c)
+	if len(limits) == 0 {
+		c.Next()
+		return nil
+	}
+	var (
+		headers *rateLimitResult
+		taken   []string
+	)
+	for i, limit := range limits {
+		key := fmt.Sprintf("rate_limit_%s_%s", names[i], limit.subject(c))
+		result := takeRateLimit(key, limit.Limit, limit.window())
+		if !result.allowed {
+			// 被拒绝的请求不计入此前已通过的规则
+			for _, item := range taken {
+				releaseRateLimit(item)
+			}
+			seconds := int64(math.Max(1, math.Ceil(result.retryAfter.Seconds())))
+			setRateLimitHeaders(c, result)
+			c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+			// 不使用error作为响应数据，避免被攻击时输出大量错误日志
+			reply := c.stdErr("rate limit exceeded", http.StatusTooManyRequests, []interface{}{
+				"rate_limited", "Too many requests, please try again in {{seconds}} seconds", D{"seconds": seconds},
+			})
+			reply.HTTPAction = c.AbortWithStatusJSON
+			reply.HTTPCode = http.StatusTooManyRequests
+			return reply
+		}
+		taken = append(taken, result.key)
+		if headers == nil || result.remaining < headers.remaining {
+			headers = &result
+		}
+	}
+	setRateLimitHeaders(c, *headers)
+	c.Next()
+	return nil
+}
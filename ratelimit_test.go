@@ -0,0 +1,110 @@
+package kuu
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTakeRateLimit(t *testing.T) {
+	defer useTestCacheMemory()()
+
+	window := time.Hour
+	for i := 1; i <= 3; i++ {
+		if result := takeRateLimit("rl_test", 3, window); !result.allowed || result.remaining != 3-i {
+			t.Fatalf("request %d: unexpected result %+v", i, result)
+		}
+	}
+	result := takeRateLimit("rl_test", 3, window)
+	if result.allowed || result.retryAfter <= 0 || result.retryAfter > 2*window {
+		t.Fatalf("expected rejection, got %+v", result)
+	}
+	// 被拒绝的请求不计入计数
+	idx := time.Now().UnixNano() / int64(window)
+	if v := GetCacheInt(fmt.Sprintf("rl_test_%d", idx)); v != 3 {
+		t.Errorf("expected count 3, got %d", v)
+	}
+}
+
+func TestRateLimitRetryAfter(t *testing.T) {
+	window := 100 * time.Second
+	cases := []struct {
+		prev, cur, limit int
+		elapsed          float64
+		want             time.Duration
+	}{
+		// 当前窗口已满，等待到下一窗口
+		{0, 10, 10, 0.5, 50*time.Second + 10*time.Second},
+		// 前一窗口计数衰减后即可放行
+		{10, 5, 10, 0.2, 40 * time.Second},
+	}
+	for _, item := range cases {
+		got := rateLimitRetryAfter(item.prev, item.cur, item.limit, item.elapsed, window)
+		if diff := got - item.want; diff > time.Millisecond || diff < -time.Millisecond {
+			t.Errorf("%+v: expected %v, got %v", item, item.want, got)
+		}
+	}
+}
+
+func TestRateLimitMatch(t *testing.T) {
+	restModelRoutes["GET /api/user"] = "User"
+	defer delete(restModelRoutes, "GET /api/user")
+
+	cases := []struct {
+		rule         RateLimit
+		method, path string
+		want         bool
+	}{
+		{RateLimit{Route: "POST /api/login"}, "POST", "/api/login", true},
+		{RateLimit{Route: "POST /api/login"}, "GET", "/api/login", false},
+		{RateLimit{Route: "/api/captcha"}, "GET", "/api/captcha", true},
+		{RateLimit{Route: "* /api/files/*path"}, "GET", "/api/files/a/b", true},
+		{RateLimit{Model: "user"}, "GET", "/api/user", true},
+		{RateLimit{Model: "User"}, "POST", "/api/user", false},
+	}
+	for _, item := range cases {
+		if got := item.rule.match(item.method, item.path); got != item.want {
+			t.Errorf("%+v %s %s: expected %v", item.rule, item.method, item.path, item.want)
+		}
+	}
+}
+
+func useTestRateLimitConfig(data string) func() {
+	restoreConfig := useTestConfig(data)
+	rateLimitConfigOnce = sync.Once{}
+	return func() {
+		restoreConfig()
+		rateLimitConfigOnce = sync.Once{}
+	}
+}
+
+func TestRateLimitMiddlewareRollback(t *testing.T) {
+	defer useTestCacheMemory()()
+	defer useTestRateLimitConfig(`{"rateLimit":{"Global":{"Limit":10,"Window":3600},"Rules":[{"Route":"POST /api/login","Limit":1,"Window":3600}]}}`)()
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		ginCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ginCtx.Request = httptest.NewRequest("POST", "/api/login", nil)
+		reply := RateLimitMiddleware(&Context{Context: ginCtx})
+		if reply == nil {
+			codes = append(codes, 200)
+		} else {
+			codes = append(codes, reply.HTTPCode)
+		}
+	}
+	if fmt.Sprint(codes) != "[200 429 429]" {
+		t.Fatalf("unexpected codes: %v", codes)
+	}
+	// 被路由规则拒绝的请求不计入全局计数
+	idx := time.Now().UnixNano() / int64(time.Hour)
+	if v := GetCacheInt(fmt.Sprintf("rate_limit_global_ip_192.0.2.1_%d", idx)); v != 1 {
+		t.Errorf("expected global count 1, got %d", v)
+	}
+	if rule := loadRateLimitConfig().Rules[0]; !rule.compiled || rule.method != "POST" {
+		t.Errorf("rule not compiled: %+v", rule)
+	}
+}
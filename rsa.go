@@ -11,10 +11,14 @@ import (
 	"errors"
 )
 
-// GenRSAKey 生成RSA密钥
-func GenRSAKey() (prvKey, pubKey []byte) {
+// GenRSAKey 生成RSA密钥，默认长度为1024位
+func GenRSAKey(bits ...int) (prvKey, pubKey []byte) {
+	size := 1024
+	if len(bits) > 0 && bits[0] > 0 {
+		size = bits[0]
+	}
 	// 生成私钥文件
-	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	privateKey, err := rsa.GenerateKey(rand.Reader, size)
 	if err != nil {
 		panic(err)
 	}
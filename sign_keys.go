@@ -0,0 +1,329 @@
+package kuu
+
+import (
+	"crypto"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/jinzhu/gorm"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignAlgHS256 每个令牌使用独立随机密钥的HMAC签名（默认）
+	SignAlgHS256 = "HS256"
+	// SignAlgRS256
+	SignAlgRS256 = "RS256"
+	// SignAlgES256
+	SignAlgES256 = "ES256"
+)
+
+var (
+	// SignKeyRotationDays 签名密钥的默认轮换周期（天）
+	SignKeyRotationDays = 30
+	// SignKeyRSABits RSA签名密钥长度
+	SignKeyRSABits = 2048
+	// SignKeysReloadInterval 多实例部署时重新加载签名密钥的间隔
+	SignKeysReloadInterval = time.Minute
+	// SignKeyRotateLockTTL 轮换签名密钥时持有的锁的过期时间，多个实例中同一时间只有一个执行轮换
+	SignKeyRotateLockTTL = 30 * time.Second
+
+	signKeys = &signKeyStore{keys: make(map[string]*SignKey)}
+)
+
+type signKeyStore struct {
+	mu       sync.RWMutex
+	keys     map[string]*SignKey
+	current  *SignKey
+	loadedAt time.Time
+}
+
+// TokenAlg 令牌签名算法，对应配置token.alg
+func TokenAlg() string {
+	return strings.ToUpper(C().DefaultGetString("token.alg", SignAlgHS256))
+}
+
+// AsymmetricTokenEnabled 是否使用非对称签名（令牌可通过JWKS离线校验）
+func AsymmetricTokenEnabled() bool {
+	alg := TokenAlg()
+	return alg == SignAlgRS256 || alg == SignAlgES256
+}
+
+func signKeyRotation() time.Duration {
+	return time.Duration(C().DefaultGetInt("token.keyRotationDays", SignKeyRotationDays)) * 24 * time.Hour
+}
+
+// GenSignKey 生成签名密钥
+func GenSignKey(alg string) (*SignKey, error) {
+	var prvKey, pubKey []byte
+	switch alg {
+	case SignAlgRS256:
+		prvKey, pubKey = GenRSAKey(SignKeyRSABits)
+	case SignAlgES256:
+		prvKey, pubKey = GenECKey()
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", alg)
+	}
+	key := &SignKey{
+		Kid:        RandomHex(8),
+		Alg:        alg,
+		PrivateKey: string(prvKey),
+		PublicKey:  string(pubKey),
+	}
+	if err := key.parse(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *SignKey) parse() (err error) {
+	if k.publicKey != nil {
+		return nil
+	}
+	switch k.Alg {
+	case SignAlgRS256:
+		if k.privateKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(k.PrivateKey)); err != nil {
+			return
+		}
+		k.publicKey, err = jwt.ParseRSAPublicKeyFromPEM([]byte(k.PublicKey))
+	case SignAlgES256:
+		if k.privateKey, err = jwt.ParseECPrivateKeyFromPEM([]byte(k.PrivateKey)); err != nil {
+			return
+		}
+		k.publicKey, err = jwt.ParseECPublicKeyFromPEM([]byte(k.PublicKey))
+	default:
+		err = fmt.Errorf("unsupported signing algorithm: %s", k.Alg)
+	}
+	return
+}
+
+// SigningMethod
+func (k *SignKey) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+// Public
+func (k *SignKey) Public() crypto.PublicKey {
+	return k.publicKey
+}
+
+// JWK
+func (k *SignKey) JWK() (*JWK, error) {
+	return NewJWK(k.Kid, k.Alg, k.publicKey)
+}
+
+func (s *signKeyStore) load(force bool) error {
+	s.mu.RLock()
+	fresh := !force && time.Since(s.loadedAt) < SignKeysReloadInterval
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+	var list []SignKey
+	if err := DB().Order("id asc").Find(&list).Error; err != nil {
+		return err
+	}
+	keys := make(map[string]*SignKey)
+	var current *SignKey
+	for i := range list {
+		key := &list[i]
+		if err := key.parse(); err != nil {
+			ERROR(err)
+			continue
+		}
+		keys[key.Kid] = key
+		if key.RetiredAt == 0 {
+			current = key
+		}
+	}
+	s.mu.Lock()
+	s.keys = keys
+	s.current = current
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// CurrentSignKey 返回当前用于签发的密钥，不存在、算法不一致或已到轮换周期时自动生成
+func CurrentSignKey() (*SignKey, error) {
+	if key := currentSignKey(false); key != nil {
+		return key, nil
+	}
+	var key *SignKey
+	err := WithLock("sign_key_rotate", SignKeyRotateLockTTL, func(*CacheLock) (err error) {
+		// 其他协程或实例可能已完成轮换
+		if key = currentSignKey(true); key != nil {
+			return nil
+		}
+		key, err = rotateSignKey()
+		return err
+	})
+	return key, err
+}
+
+func currentSignKey(force bool) *SignKey {
+	if err := signKeys.load(force); err != nil {
+		ERROR(err)
+		return nil
+	}
+	signKeys.mu.RLock()
+	current := signKeys.current
+	signKeys.mu.RUnlock()
+	if current != nil && current.Alg == TokenAlg() && time.Since(current.CreatedAt) < signKeyRotation() {
+		return current
+	}
+	return nil
+}
+
+// FindSignKey 根据kid查找签名密钥，本地不存在时重新加载（其他实例可能已轮换）
+func FindSignKey(kid string) (*SignKey, error) {
+	for _, force := range []bool{false, true} {
+		if err := signKeys.load(force); err != nil {
+			return nil, err
+		}
+		signKeys.mu.RLock()
+		key := signKeys.keys[kid]
+		signKeys.mu.RUnlock()
+		if key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("signing key not found: %s", kid)
+}
+
+// RotateSignKey 生成新的签名密钥并停止使用旧密钥签发，旧密钥在其签发的令牌全部过期前仍可用于校验
+func RotateSignKey() (key *SignKey, err error) {
+	err = WithLock("sign_key_rotate", SignKeyRotateLockTTL, func(*CacheLock) (err error) {
+		key, err = rotateSignKey()
+		return err
+	})
+	return
+}
+
+func rotateSignKey() (*SignKey, error) {
+	key, err := GenSignKey(TokenAlg())
+	if err != nil {
+		return nil, err
+	}
+	err = WithTransaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&SignKey{}).Where("retired_at = ?", 0).UpdateColumn("retired_at", time.Now().Unix()).Error; err != nil {
+			return err
+		}
+		return tx.Create(key).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := signKeys.load(true); err != nil {
+		return nil, err
+	}
+	INFO("Token signing key rotated: kid=%s alg=%s", key.Kid, key.Alg)
+	return key, nil
+}
+
+// PurgeSignKeys 删除已停用且不再被有效令牌引用的签名密钥
+func PurgeSignKeys() error {
+	var list []SignKey
+	if err := DB().Where("retired_at > ?", 0).Find(&list).Error; err != nil {
+		return err
+	}
+	for _, key := range list {
+		var count int
+		if err := DB().Model(&SignSecret{}).
+			Where(&SignSecret{Kid: key.Kid, Method: SignMethodLogin}).
+			Where("exp > ?", time.Now().Unix()).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if err := DB().Unscoped().Delete(&SignKey{}, key.ID).Error; err != nil {
+			return err
+		}
+	}
+	return signKeys.load(true)
+}
+
+// EncodedTokenWithKey 使用非对称密钥签发令牌
+func EncodedTokenWithKey(claims jwt.MapClaims, key *SignKey) (string, error) {
+	if err := key.parse(); err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.Kid
+	return token.SignedString(key.privateKey)
+}
+
+// 根据令牌头的kid获取校验公钥，算法必须与密钥一致
+func signKeyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := FindSignKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.publicKey, nil
+}
+
+// SignKeysJWKS 返回所有可用于校验的公钥
+func SignKeysJWKS() (*JWKS, error) {
+	if err := signKeys.load(false); err != nil {
+		return nil, err
+	}
+	signKeys.mu.RLock()
+	defer signKeys.mu.RUnlock()
+	set := &JWKS{Keys: make([]JWK, 0, len(signKeys.keys))}
+	for _, key := range signKeys.keys {
+		jwk, err := key.JWK()
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, *jwk)
+	}
+	return set, nil
+}
+
+func initSignKeys() error {
+	if !AsymmetricTokenEnabled() {
+		return nil
+	}
+	if _, err := CurrentSignKey(); err != nil {
+		return err
+	}
+	_, err := AddJob("@daily", "Rotate token signing keys", func(c *JobContext) {
+		key, err := CurrentSignKey()
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		DEBUG("Current token signing key: %s", key.Kid)
+		if err := PurgeSignKeys(); err != nil {
+			c.Error(err)
+		}
+	})
+	return err
+}
+
+// JWKSRoute
+var JWKSRoute = RouteInfo{
+	Name:         "查询令牌签名公钥",
+	Method:       "GET",
+	Path:         "/.well-known/jwks.json",
+	IgnorePrefix: true,
+	IntlMessages: map[string]string{
+		"acc_jwks_failed": "Query signing keys failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		set, err := SignKeysJWKS()
+		if err != nil {
+			return c.STDErr(err, "acc_jwks_failed")
+		}
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(200, set)
+		return nil
+	},
+}
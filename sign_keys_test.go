@@ -0,0 +1,75 @@
+package kuu
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignKeyJWKRoundTrip(t *testing.T) {
+	for _, alg := range []string{SignAlgRS256, SignAlgES256} {
+		key, err := GenSignKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := EncodedTokenWithKey(jwt.MapClaims{"UID": 1, "exp": time.Now().Add(time.Minute).Unix()}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 模拟离线校验方：仅通过JWKS中的公钥验签
+		token, err := jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+			if token.Header["kid"] != jwk.Kid || token.Method.Alg() != jwk.Alg {
+				t.Errorf("%s: unexpected header %v", alg, token.Header)
+			}
+			return jwk.PublicKey()
+		})
+		if err != nil || !token.Valid {
+			t.Errorf("%s: verify with JWK failed: %v", alg, err)
+		}
+	}
+	if _, err := GenSignKey(SignAlgHS256); err == nil {
+		t.Errorf("GenSignKey() should reject symmetric algorithm")
+	}
+}
+
+func TestCurrentSignKeyRotatesOnce(t *testing.T) {
+	defer useTestDB(t, &SignKey{})()
+	defer useTestCacheMemory()()
+	defer useTestConfig(`{"token":{"alg":"ES256"}}`)()
+	prev := signKeys
+	signKeys = &signKeyStore{keys: make(map[string]*SignKey)}
+	defer func() { signKeys = prev }()
+
+	var (
+		wg   sync.WaitGroup
+		kids = make([]string, 5)
+	)
+	for i := range kids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key, err := CurrentSignKey()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			kids[i] = key.Kid
+		}(i)
+	}
+	wg.Wait()
+	for _, kid := range kids {
+		if kid != kids[0] {
+			t.Errorf("expected a single rotation, got kids %v", kids)
+			break
+		}
+	}
+	var count int
+	if err := DB().Model(&SignKey{}).Count(&count).Error; err != nil || count != 1 {
+		t.Errorf("expected 1 signing key, got %d: %v", count, err)
+	}
+}
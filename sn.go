@@ -13,6 +13,7 @@ func NewDateSn(keyPrefix string, valPrefix string, minLen ...int) string {
 	}
 	date := time.Now().Format("20060102")
 	key := fmt.Sprintf("%s_%s", keyPrefix, date)
-	val := IncrCache(key)
+	// 保留至次日，避免按日期生成的计数键长期堆积
+	val := incrCacheWithExpiration(key, 48*time.Hour)
 	return fmt.Sprintf("%s%s%0*d", valPrefix, date, min, val)
 }
@@ -75,6 +75,13 @@ func preflight() bool {
 	return false
 }
 
+func importSys() error {
+	if err := initAuditJobs(); err != nil {
+		return err
+	}
+	return initOutboxJobs()
+}
+
 func initSys() error {
 	// 初始化预置数据
 	err := WithTransaction(func(tx *gorm.DB) error {
@@ -356,7 +363,7 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 	failedTimesKey := getFailedTimesKey(body.Username)
 	failedTimes := GetCacheInt(failedTimesKey)
 	cacheFailedTimes := func() {
-		SetCacheInt(failedTimesKey, failedTimes+1)
+		SetCacheInt(failedTimesKey, failedTimes+1, time.Duration(GetLoginThrottle().ResetSeconds)*time.Second)
 	}
 	resp = &LoginHandlerResponse{
 		Username: body.Username,
@@ -408,7 +415,22 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 		cacheFailedTimes()
 		return
 	}
-	resp.Payload = jwt.MapClaims{
+	// 检测密码是否过期
+	if PasswordExpired(&user) {
+		resp.Error = ErrPasswordExpired
+		resp.LocaleMessageID = "acc_password_expired"
+		resp.LocaleMessageDefaultText = "Password expired, please change your password."
+		return
+	}
+	resp.Payload = userPayload(c, &user)
+	resp.Lang = user.Lang
+	resp.UID = user.ID
+	return
+}
+
+// 生成令牌载荷
+func userPayload(c *Context, user *User) jwt.MapClaims {
+	payload := jwt.MapClaims{
 		"UID":       user.ID,
 		"Username":  user.Username,
 		"Name":      user.Name,
@@ -419,15 +441,13 @@ func defaultLoginHandler(c *Context) (resp *LoginHandlerResponse) {
 		"CreatedAt": user.CreatedAt,
 		"UpdatedAt": user.UpdatedAt,
 	}
-	resp.Payload = SetPayloadAttrs(resp.Payload, &user)
+	payload = SetPayloadAttrs(payload, user)
 	// 处理Lang参数
 	if user.Lang == "" {
 		user.Lang = c.Lang()
 	}
-	resp.Payload["Lang"] = user.Lang
-	resp.Lang = user.Lang
-	resp.UID = user.ID
-	return
+	payload["Lang"] = user.Lang
+	return payload
 }
 
 // SetPayloadAttrs
@@ -456,6 +476,8 @@ func Sys() *Mod {
 		Models: []interface{}{
 			&ImportRecord{},
 			&User{},
+			&PasswordHistory{},
+			&PasswordResetToken{},
 			&Org{},
 			&RoleAssign{},
 			&Role{},
@@ -466,6 +488,11 @@ func Sys() *Mod {
 			&Param{},
 			&Message{},
 			&MessageReceipt{},
+			&EventLog{},
+			&EventLogLabel{},
+			&EventLogCheckpoint{},
+			&DataChangeLog{},
+			&OutboxEvent{},
 		},
 		Routes: RoutesInfo{
 			OrgLoginableRoute,
@@ -492,7 +519,16 @@ func Sys() *Mod {
 			JobRunRoute,
 			MessagesLatestRoute,
 			MessagesReadRoute,
+			ChangePasswordRoute,
+			PasswordResetRequestRoute,
+			PasswordResetConfirmRoute,
+			EventLogVerifyRoute,
+			CacheKeysRoute,
+			CacheValueRoute,
+			CacheDeleteRoute,
+			CacheStatsRoute,
 		},
-		OnInit: initSys,
+		OnInit:   initSys,
+		OnImport: importSys,
 	}
 }
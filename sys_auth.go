@@ -51,6 +51,8 @@ type PrivilegesDesc struct {
 	ActOrgCode               string
 	ActOrgName               string
 	RolesCode                []string
+	// ActorUID 模拟登录时为实际操作人ID，否则与UID相同
+	ActorUID uint
 }
 
 // IsWritableOrgID
@@ -82,6 +84,23 @@ func (desc *PrivilegesDesc) IsValid() bool {
 	return desc != nil && desc.Valid && desc.SignInfo != nil && desc.SignInfo.IsValid()
 }
 
+// Actor 返回实际操作人ID
+func (desc *PrivilegesDesc) Actor() uint {
+	if desc.ActorUID != 0 {
+		return desc.ActorUID
+	}
+	return desc.UID
+}
+
+// HasPermission
+func (desc *PrivilegesDesc) HasPermission(code string) bool {
+	if !desc.IsValid() {
+		return false
+	}
+	_, has := desc.PermissionMap[code]
+	return has
+}
+
 // NotRootUser
 func (desc *PrivilegesDesc) NotRootUser() bool {
 	return desc.IsValid() && desc.UID != RootUID()
@@ -374,6 +393,10 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 		PermissionMap: make(map[string]int64),
 		Valid:         true,
 		SignInfo:      sign,
+		ActorUID:      uid,
+	}
+	if sign != nil && sign.ActorUID != 0 {
+		desc.ActorUID = sign.ActorUID
 	}
 	type orange struct {
 		readable string
@@ -490,6 +513,14 @@ func GetPrivilegesDesc(signOrContextOrUID interface{}) (desc *PrivilegesDesc) {
 		return
 	}
 
+	// 令牌限定了授权范围时，仅保留范围内的权限
+	if sign != nil && sign.Secret.Scoped() {
+		for code := range desc.PermissionMap {
+			if !sign.Secret.PermissionAllowed(code) {
+				delete(desc.PermissionMap, code)
+			}
+		}
+	}
 	for code := range desc.PermissionMap {
 		desc.Permissions = append(desc.Permissions, code)
 	}
@@ -83,6 +83,22 @@ func registerCallbacks() {
 	if callback.Delete().Get("kuu:model_change") == nil {
 		callback.Delete().After("gorm:after_delete").Register("kuu:model_change", modelChangeCallback)
 	}
+	// 注册数据变更审计callback，gorm同时指定Before和After时会重复插入callback，故只指定Before
+	if callback.Update().Get("kuu:change_audit_before") == nil {
+		callback.Update().Before("gorm:update").Register("kuu:change_audit_before", changeAuditBeforeCallback)
+	}
+	if callback.Delete().Get("kuu:change_audit_before") == nil {
+		callback.Delete().Before("gorm:delete").Register("kuu:change_audit_before", changeAuditBeforeCallback)
+	}
+	if callback.Create().Get("kuu:change_audit") == nil {
+		callback.Create().Before("gorm:commit_or_rollback_transaction").Register("kuu:change_audit", changeAuditAfterCallback(DataChangeActionCreate))
+	}
+	if callback.Update().Get("kuu:change_audit") == nil {
+		callback.Update().Before("gorm:commit_or_rollback_transaction").Register("kuu:change_audit", changeAuditAfterCallback(DataChangeActionUpdate))
+	}
+	if callback.Delete().Get("kuu:change_audit") == nil {
+		callback.Delete().Before("gorm:commit_or_rollback_transaction").Register("kuu:change_audit", changeAuditAfterCallback(DataChangeActionDelete))
+	}
 }
 
 func uuidCreateCallback(scope *gorm.Scope) {
@@ -148,7 +164,7 @@ func createCallback(scope *gorm.Scope) {
 				createdByID = field.Field.Interface().(uint)
 			}
 			if field, ok := scope.FieldByName("UpdatedByID"); ok {
-				if err := scope.SetColumn(field.DBName, desc.UID); err != nil {
+				if err := scope.SetColumn(field.DBName, desc.Actor()); err != nil {
 					_ = scope.Err(fmt.Errorf("自动设置修改人ID失败：%s", err.Error()))
 					return
 				}
@@ -218,7 +234,7 @@ func deleteCallback(scope *gorm.Scope) {
 					sqlbuf.WriteString("%v=%v,")
 					attrs = append(attrs,
 						scope.Quote(f.DBName),
-						scope.AddToVars(desc.UID),
+						scope.AddToVars(desc.Actor()),
 					)
 				}
 			}
@@ -272,7 +288,7 @@ func updateCallback(scope *gorm.Scope) {
 				_ = scope.Err(err)
 				return
 			}
-			if err := scope.SetColumn("UpdatedByID", desc.UID); err != nil {
+			if err := scope.SetColumn("UpdatedByID", desc.Actor()); err != nil {
 				ERROR("自动设置修改人ID失败：%s", err.Error())
 			}
 		}
@@ -86,6 +86,12 @@ type User struct {
 	Lang        string       `name:"最近使用语言"`
 	DenyLogin   null.Bool    `name:"禁止登录"`
 	ActOrgID    uint         `name:"当前组织"`
+
+	PasswordChangedAt    int64 `name:"密码修改时间戳"`
+	PasswordScore        int   `name:"密码强度评分（客户端计算）" gorm:"-" json:",omitempty"`
+	IgnorePasswordPolicy bool  `gorm:"-" json:"-"`
+	passwordHash         string
+	checkPasswordScore   bool
 }
 
 // GetSubDocIDs
@@ -135,10 +141,22 @@ func (u *User) DelSubDocID(signType string) error {
 // BeforeSave
 func (u *User) BeforeSave(scope *gorm.Scope) (err error) {
 	if len(u.Password) == 32 {
+		if !u.IgnorePasswordPolicy {
+			if err = CheckPasswordPolicy(scope.NewDB(), u); err != nil {
+				return
+			}
+		}
 		var hashed string
-		if hashed, err = GenerateFromPassword(u.Password); err == nil {
-			err = scope.SetColumn("Password", hashed)
+		if hashed, err = GenerateFromPassword(u.Password); err != nil {
+			return
+		}
+		if err = scope.SetColumn("Password", hashed); err != nil {
+			return
 		}
+		if err = scope.SetColumn("PasswordChangedAt", time.Now().Unix()); err != nil {
+			return
+		}
+		u.passwordHash = hashed
 	}
 	if u.ID != 0 {
 		DelCache(fmt.Sprintf("user_%d", u.ID))
@@ -146,6 +164,15 @@ func (u *User) BeforeSave(scope *gorm.Scope) (err error) {
 	return
 }
 
+// AfterSave
+func (u *User) AfterSave(scope *gorm.Scope) (err error) {
+	if u.passwordHash != "" {
+		err = savePasswordHistory(scope.NewDB(), u.ID, u.passwordHash)
+		u.passwordHash = ""
+	}
+	return
+}
+
 // AfterDelete
 func (u *User) AfterDelete() {
 	if u.ID != 0 {
@@ -153,6 +180,23 @@ func (u *User) AfterDelete() {
 	}
 }
 
+// PasswordHistory
+type PasswordHistory struct {
+	gorm.Model `displayName:"密码历史"`
+	UID        uint   `name:"用户ID" gorm:"index"`
+	Password   string `name:"密码摘要" kuu:"password"`
+}
+
+// PasswordResetToken
+type PasswordResetToken struct {
+	gorm.Model `displayName:"密码重置令牌"`
+	UID        uint   `name:"用户ID" gorm:"index"`
+	TokenHash  string `name:"令牌摘要" gorm:"unique_index" kuu:"password"`
+	IP         string `name:"申请IP"`
+	Exp        int64  `name:"过期时间戳"`
+	UsedAt     int64  `name:"使用时间戳"`
+}
+
 // Org
 type Org struct {
 	// 引用Model将无法Preload，故复制字段
@@ -275,6 +319,8 @@ type Role struct {
 	OperationPrivileges []OperationPrivileges `name:"角色操作权限"`
 	DataPrivileges      []DataPrivileges      `name:"角色数据权限"`
 	IsBuiltIn           null.Bool             `name:"是否内置"`
+	RequireTwoFactor    null.Bool             `name:"是否要求双因素认证"`
+	MaxSessions         int                   `name:"最大同时在线会话数（0表示不限制）"`
 }
 
 // OperationPrivileges
@@ -891,7 +891,7 @@ var LangSwitchRoute = RouteInfo{
 
 // LoginAsRoute
 var LoginAsRoute = RouteInfo{
-	Name:   "以用户身份登录（该接口仅限root调用）",
+	Name:   "以用户身份登录（仅限root或拥有sys_login_as权限的用户调用）",
 	Method: "POST",
 	Path:   "/login_as",
 	IntlMessages: map[string]string{
@@ -900,10 +900,12 @@ var LoginAsRoute = RouteInfo{
 	},
 	HandlerFunc: func(c *Context) *STDReply {
 		var body struct {
-			UID uint
+			UID     uint
+			Reason  string
+			Minutes int
 		}
 
-		if c.SignInfo.UID != RootUID() {
+		if !CanLoginAs(c) {
 			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
 		}
 
@@ -911,29 +913,38 @@ var LoginAsRoute = RouteInfo{
 			return c.STDErr(err, "login_as_failed")
 		}
 
-		var (
-			secret SignSecret
-			user   User
-			db     = c.DB()
-		)
-		if err := db.Where(&SignSecret{UID: body.UID, Type: AdminSignType, Method: "LOGIN"}).Where(fmt.Sprintf("%s > ?", db.Dialect().Quote("exp")), time.Now().Unix()).Order("created_at desc").First(&secret).Error; err != nil {
-			return c.STDErr(err, "login_as_failed")
-		}
-		if err := db.Where(fmt.Sprintf("%s = ?", db.Dialect().Quote("id")), secret.UID).First(&user).Error; err != nil {
+		secret, err := LoginAs(c, body.UID, body.Reason, body.Minutes)
+		if err != nil {
 			return c.STDErr(err, "login_as_failed")
 		}
-		c.SetCookie(LangKey, user.Lang, ExpiresSeconds, "/", "", false, true)
-		c.SetCookie(TokenKey, secret.Token, ExpiresSeconds, "/", "", false, true)
-		return c.STDOK()
+		maxAge := int(secret.Exp - time.Now().Unix())
+		c.SetCookie(LangKey, GetUserFromCache(secret.UID).Lang, maxAge, "/", "", false, true)
+		c.SetCookie(TokenKey, secret.Token, maxAge, "/", "", false, true)
+		return c.STD(D{
+			"Token": secret.Token,
+			"Exp":   secret.Exp,
+		})
 	},
 }
 
 // LoginAsOutRoute
 var LoginAsOutRoute = RouteInfo{
-	Name:   "退出模拟登录（该接口仅限root调用）",
+	Name:   "退出模拟登录",
 	Method: "DELETE",
 	Path:   "/login_as",
+	IntlMessages: map[string]string{
+		"login_as_out_failed": "Exit impersonation failed",
+	},
 	HandlerFunc: func(c *Context) *STDReply {
+		// 模拟登录令牌退出后立即失效
+		if secret := c.SignInfo.Secret; secret != nil && secret.ImpersonatorID != 0 {
+			if err := RevokeSignSecrets(c.DB(), []SignSecret{*secret}); err != nil {
+				return c.STDErr(err, "login_as_out_failed")
+			}
+			addImpersonationEventLog(c, secret, "Impersonation ended", fmt.Sprintf("Impersonation of %s ended", secret.Username), D{
+				"SecretID": secret.ID,
+			})
+		}
 		c.SetCookie(TokenKey, c.SignInfo.Token, -1, "/", "", false, true)
 		c.SetCookie(LangKey, "", -1, "/", "", false, true)
 		return c.STDOK()
@@ -942,7 +953,7 @@ var LoginAsOutRoute = RouteInfo{
 
 // LoginAsUsersRoute
 var LoginAsUsersRoute = RouteInfo{
-	Name:   "查询可模拟登录的用户列表（该接口仅限root调用）",
+	Name:   "查询可模拟登录的用户列表（仅限root或拥有sys_login_as权限的用户调用）",
 	Method: "GET",
 	Path:   "/login_as/users",
 	IntlMessages: map[string]string{
@@ -950,7 +961,7 @@ var LoginAsUsersRoute = RouteInfo{
 		"login_as_failed":       "Login failed",
 	},
 	HandlerFunc: func(c *Context) *STDReply {
-		if c.SignInfo.UID != RootUID() {
+		if !CanLoginAs(c) {
 			return c.STDErr(fmt.Errorf("unauthorized operation: uid=%v", c.SignInfo.UID), "login_as_unauthorized")
 		}
 
@@ -18,6 +18,22 @@ type GenTokenDesc struct {
 	Desc     string
 	Payload  jwt.MapClaims
 	IsAPIKey bool
+	Scopes   []string
+	// AllowedCIDRs 仅对API Key生效
+	AllowedCIDRs []string
+
+	family         string
+	clientID       string
+	impersonatorID uint
+	reason         string
+	noRefresh      bool
+}
+
+// ParseScopes 解析以空格或逗号分隔的授权范围
+func ParseScopes(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t' || r == '\n'
+	})
 }
 
 // GenToken
@@ -28,7 +44,18 @@ func GenToken(desc GenTokenDesc) (secretData *SignSecret, err error) {
 	if desc.IsAPIKey && desc.Desc == "" {
 		return nil, errors.New("API Keys needs a description")
 	}
+	if _, err := ParseCIDRs(desc.AllowedCIDRs); err != nil {
+		return nil, err
+	}
 
+	// 启用刷新令牌时，访问令牌的有效期不能超过accessExpires
+	// 模拟登录令牌有效期固定、客户端凭证令牌可随时重新申请，均不签发刷新令牌
+	withRefresh := !desc.IsAPIKey && desc.impersonatorID == 0 && !desc.noRefresh && RefreshTokenEnabled()
+	if withRefresh {
+		if maxExp := time.Now().Add(time.Second * time.Duration(accessExpiresSeconds())).Unix(); desc.Exp > maxExp {
+			desc.Exp = maxExp
+		}
+	}
 	// 设置JWT令牌信息
 	iat := time.Now().Unix()
 	desc.Payload["iat"] = iat      // 签发时间：必须用全小写iat
@@ -55,18 +82,51 @@ func GenToken(desc GenTokenDesc) (secretData *SignSecret, err error) {
 		Desc:     desc.Desc,
 		Type:     desc.Type,
 		IsAPIKey: null.NewBool(desc.IsAPIKey, true),
+		ClientID: desc.clientID,
+		Scopes:   JoinScopes(desc.Scopes),
+
+		ImpersonatorID:      desc.impersonatorID,
+		ImpersonationReason: desc.reason,
+	}
+	if desc.IsAPIKey {
+		secretData.AllowedCIDRs = strings.Join(desc.AllowedCIDRs, " ")
+	}
+	if c := GetRoutineRequestContext(); c != nil {
+		secretData.IP = c.ClientIP()
+		secretData.UserAgent = c.Request.UserAgent()
 	}
 	// 签发令牌
-	if signed, err := EncodedToken(desc.Payload, secretData.Secret); err != nil {
+	if AsymmetricTokenEnabled() {
+		key, err := CurrentSignKey()
+		if err != nil {
+			return secretData, err
+		}
+		// 非对称签名时密钥不参与签名，可作为jti供离线校验方检查吊销状态
+		desc.Payload["jti"] = secretData.Secret
+		if v := C().GetString("token.issuer"); v != "" {
+			desc.Payload["iss"] = v
+		}
+		secretData.Kid = key.Kid
+		if secretData.Token, err = EncodedTokenWithKey(desc.Payload, key); err != nil {
+			return secretData, err
+		}
+	} else if signed, err := EncodedToken(desc.Payload, secretData.Secret); err != nil {
 		return secretData, err
 	} else {
 		secretData.Token = signed
 	}
-	desc.Payload[TokenKey] = secretData.Token
 	if err = DB().Create(secretData).Error; err != nil {
 		return
 	}
+	// 签发刷新令牌
+	if withRefresh {
+		if secretData.RefreshToken, err = issueRefreshToken(secretData, &desc); err != nil {
+			return
+		}
+		desc.Payload[RefreshTokenKey] = secretData.RefreshToken
+	}
+	desc.Payload[TokenKey] = secretData.Token
 	// 保存登入历史
-	saveHistory(secretData)
+	saveHistory(DB(), secretData)
 	return
 }
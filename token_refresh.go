@@ -0,0 +1,227 @@
+package kuu
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+var (
+	// RefreshTokenKey
+	RefreshTokenKey = "RefreshToken"
+	// AccessExpiresSeconds 启用刷新令牌后访问令牌的默认有效期
+	AccessExpiresSeconds = 900
+	// RefreshExpiresSeconds 刷新令牌的默认有效期
+	RefreshExpiresSeconds = 604800
+)
+
+// RefreshTokenEnabled
+func RefreshTokenEnabled() bool {
+	return C().GetBool("token.refresh")
+}
+
+func accessExpiresSeconds() int {
+	return C().DefaultGetInt("token.accessExpires", AccessExpiresSeconds)
+}
+
+func refreshExpiresSeconds() int {
+	return C().DefaultGetInt("token.refreshExpires", RefreshExpiresSeconds)
+}
+
+func issueRefreshToken(secretData *SignSecret, desc *GenTokenDesc) (string, error) {
+	family := desc.family
+	if family == "" {
+		family = strings.ReplaceAll(uuid.NewV4().String(), "-", "")
+	}
+	// 载荷中的签发信息在刷新时重新生成
+	payload := make(jwt.MapClaims)
+	for k, v := range desc.Payload {
+		switch k {
+		case "iat", "exp", TokenKey, RefreshTokenKey:
+			continue
+		}
+		payload[k] = v
+	}
+	token := RandomHex(32)
+	record := SignRefreshToken{
+		UID:       secretData.UID,
+		Username:  secretData.Username,
+		Type:      secretData.Type,
+		Desc:      secretData.Desc,
+		SecretID:  secretData.ID,
+		Family:    family,
+		TokenHash: Sha256(token),
+		Payload:   JSONStringify(payload),
+		ClientID:  desc.clientID,
+		Scopes:    JoinScopes(desc.Scopes),
+		Exp:       time.Now().Add(time.Second * time.Duration(refreshExpiresSeconds())).Unix(),
+	}
+	if err := DB().Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RefreshToken 使用刷新令牌换取新的访问令牌，刷新令牌只能使用一次，重复使用将吊销整个令牌族
+// OAuth客户端的刷新令牌须传入对应的clientID
+func RefreshToken(refreshToken string, clientID ...string) (secretData *SignSecret, err error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	var cid string
+	if len(clientID) > 0 {
+		cid = clientID[0]
+	}
+	var record SignRefreshToken
+	if err = DB().Where(&SignRefreshToken{TokenHash: Sha256(refreshToken)}).First(&record).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			err = ErrInvalidRefreshToken
+		}
+		return
+	}
+	if record.RevokedAt != 0 || record.ClientID != cid {
+		return nil, ErrInvalidRefreshToken
+	}
+	if record.UsedAt != 0 {
+		ERROR(RevokeRefreshTokenFamily(DB(), record.Family))
+		return nil, ErrRefreshTokenReused
+	}
+	now := time.Now().Unix()
+	if record.Exp < now {
+		return nil, ErrRefreshTokenExpired
+	}
+	err = WithTransaction(func(tx *gorm.DB) error {
+		// 标记为已使用（带条件更新，防止并发重复使用）
+		db := tx.Model(&SignRefreshToken{}).
+			Where(map[string]interface{}{"id": record.ID, "used_at": 0}).
+			UpdateColumn("used_at", now)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected < 1 {
+			return ErrRefreshTokenReused
+		}
+		// 吊销被替换的访问令牌
+		var prev SignSecret
+		if err := tx.Where("id = ?", record.SecretID).First(&prev).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return nil
+			}
+			return err
+		}
+		return revokeSignSecrets(tx, []SignSecret{prev}, SignMethodLogout)
+	})
+	if err == ErrRefreshTokenReused {
+		ERROR(RevokeRefreshTokenFamily(DB(), record.Family))
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 检测账号状态
+	user := GetUserFromCache(record.UID)
+	if user.ID == 0 || user.Disable.Bool || user.DenyLogin.Bool {
+		ERROR(RevokeRefreshTokenFamily(DB(), record.Family))
+		return nil, ErrInvalidRefreshToken
+	}
+	payload := make(jwt.MapClaims)
+	if err = JSONParse(record.Payload, &payload); err != nil {
+		return
+	}
+	gen := func() (err error) {
+		secretData, err = GenToken(GenTokenDesc{
+			UID:      record.UID,
+			Username: record.Username,
+			Exp:      time.Now().Add(time.Second * time.Duration(accessExpiresSeconds())).Unix(),
+			Type:     record.Type,
+			Desc:     record.Desc,
+			Payload:  payload,
+			Scopes:   SplitScopes(record.Scopes),
+			family:   record.Family,
+			clientID: record.ClientID,
+		})
+		return
+	}
+	// OAuth令牌不计入会话数
+	if record.ClientID != "" {
+		err = gen()
+	} else {
+		err = WithSessionLimit(record.UID, record.Type, gen)
+	}
+	return
+}
+
+// RevokeRefreshTokenFamily 吊销令牌族下的所有刷新令牌及其签发的访问令牌
+func RevokeRefreshTokenFamily(tx *gorm.DB, family string) error {
+	if family == "" {
+		return nil
+	}
+	var (
+		records []SignRefreshToken
+		secrets []SignSecret
+		ids     []uint
+	)
+	if err := tx.Where(&SignRefreshToken{Family: family}).Find(&records).Error; err != nil {
+		return err
+	}
+	for _, item := range records {
+		ids = append(ids, item.SecretID)
+	}
+	if err := tx.Model(&SignRefreshToken{}).
+		Where(map[string]interface{}{"family": family, "revoked_at": 0}).
+		UpdateColumn("revoked_at", time.Now().Unix()).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := tx.Where("id in (?)", ids).Where(&SignSecret{Method: SignMethodLogin}).Find(&secrets).Error; err != nil {
+		return err
+	}
+	return revokeSignSecrets(tx, secrets, SignMethodLogout)
+}
+
+// RevokeRefreshTokensBySecret 吊销访问令牌所在的令牌族
+func RevokeRefreshTokensBySecret(tx *gorm.DB, secretID uint) error {
+	var record SignRefreshToken
+	if err := tx.Where(&SignRefreshToken{SecretID: secretID}).First(&record).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	return RevokeRefreshTokenFamily(tx, record.Family)
+}
+
+// RefreshTokenRoute
+var RefreshTokenRoute = RouteInfo{
+	Name:   "刷新令牌接口",
+	Method: "POST",
+	Path:   "/token/refresh",
+	IntlMessages: map[string]string{
+		"acc_refresh_token_failed": "Refresh token failed",
+	},
+	HandlerFunc: func(c *Context) *STDReply {
+		var body struct {
+			RefreshToken string
+		}
+		_ = c.ShouldBindBodyWith(&body, binding.JSON)
+		if body.RefreshToken == "" {
+			body.RefreshToken = c.GetKey(RefreshTokenKey)
+		}
+		secretData, err := RefreshToken(body.RefreshToken)
+		if err != nil {
+			c.SetCookie(RefreshTokenKey, "", -1, "/", "", false, true)
+			return c.STDErrWithCode(err, 555, "acc_refresh_token_failed")
+		}
+		c.SetCookie(TokenKey, secretData.Token, ExpiresSeconds, "/", "", false, true)
+		c.SetCookie(RefreshTokenKey, secretData.RefreshToken, refreshExpiresSeconds(), "/", "", false, true)
+		return c.STD(D{
+			TokenKey:        secretData.Token,
+			RefreshTokenKey: secretData.RefreshToken,
+			"Exp":           secretData.Exp,
+		})
+	},
+}
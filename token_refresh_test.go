@@ -0,0 +1,134 @@
+package kuu
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"testing"
+	"time"
+)
+
+func genTestRefreshToken(t *testing.T, user User) *SignSecret {
+	secret, err := GenToken(GenTokenDesc{
+		UID:      user.ID,
+		Username: user.Username,
+		Exp:      time.Now().Add(time.Hour).Unix(),
+		Type:     AdminSignType,
+		Payload:  jwt.MapClaims{"UID": user.ID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.RefreshToken == "" {
+		t.Fatal("refresh token not issued")
+	}
+	return secret
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	defer useTestDB(t, &User{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestConfig(`{"token":{"refresh":true}}`)()
+	defer useTestMaxSessions(0)()
+
+	user := createTestUser(t, "refresh")
+	first := genTestRefreshToken(t, user)
+	if max := time.Now().Add(time.Duration(AccessExpiresSeconds) * time.Second).Unix(); first.Exp > max {
+		t.Errorf("access token lives longer than accessExpires: %d > %d", first.Exp, max)
+	}
+	second, err := RefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.RefreshToken == "" || second.RefreshToken == first.RefreshToken || second.Token == first.Token {
+		t.Fatalf("tokens not rotated: %+v", second)
+	}
+	var records []SignRefreshToken
+	DB().Order("id").Find(&records)
+	if len(records) != 2 || records[0].Family != records[1].Family || records[0].UsedAt == 0 || records[1].SecretID != second.ID {
+		t.Errorf("unexpected refresh tokens: %+v", records)
+	}
+	// 被替换的访问令牌立即失效
+	if !IsSignRevoked(first.Secret) || IsSignRevoked(second.Secret) {
+		t.Error("expected only the replaced access token to be revoked")
+	}
+	if active, _ := ActiveSessions(DB(), user.ID); len(active) != 1 || active[0].ID != second.ID {
+		t.Errorf("unexpected active sessions: %+v", active)
+	}
+	third, err := RefreshToken(second.RefreshToken)
+	if err != nil || third.RefreshToken == second.RefreshToken {
+		t.Fatalf("unexpected result: %v", err)
+	}
+	// OAuth客户端的刷新令牌须传入对应的clientID
+	if _, err := RefreshToken(third.RefreshToken, "app"); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+	if _, err := RefreshToken("unknown"); err != ErrInvalidRefreshToken {
+		t.Errorf("expected ErrInvalidRefreshToken, got %v", err)
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	defer useTestDB(t, &User{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestConfig(`{"token":{"refresh":true}}`)()
+	defer useTestMaxSessions(0)()
+
+	user := createTestUser(t, "reuse")
+	first := genTestRefreshToken(t, user)
+	second, err := RefreshToken(first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 重复使用已轮换的刷新令牌时吊销整个令牌族
+	if _, err := RefreshToken(first.RefreshToken); err != ErrRefreshTokenReused {
+		t.Fatalf("expected ErrRefreshTokenReused, got %v", err)
+	}
+	if _, err := RefreshToken(second.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("expected the family to be revoked, got %v", err)
+	}
+	for _, item := range []*SignSecret{first, second} {
+		var saved SignSecret
+		DB().First(&saved, item.ID)
+		if SignRevokedMethod(&saved) != SignMethodLogout {
+			t.Errorf("access token %d not revoked", item.ID)
+		}
+	}
+	// 其他令牌族不受影响
+	other := genTestRefreshToken(t, user)
+	if _, err := RefreshToken(other.RefreshToken); err != nil {
+		t.Errorf("unexpected error for another family: %v", err)
+	}
+}
+
+func TestRefreshTokenExpired(t *testing.T) {
+	defer useTestDB(t, &User{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{})()
+	defer useTestConfig(`{"token":{"refresh":true}}`)()
+	defer useTestMaxSessions(0)()
+
+	user := createTestUser(t, "expired")
+	secret := genTestRefreshToken(t, user)
+	DB().Model(&SignRefreshToken{}).UpdateColumn("exp", time.Now().Add(-time.Second).Unix())
+	if _, err := RefreshToken(secret.RefreshToken); err != ErrRefreshTokenExpired {
+		t.Errorf("expected ErrRefreshTokenExpired, got %v", err)
+	}
+}
+
+func TestRefreshTokenSessionLimit(t *testing.T) {
+	defer useTestDB(t, &User{}, &SignSecret{}, &SignHistory{}, &SignRefreshToken{}, &EventLog{})()
+	defer useTestConfig(`{"token":{"refresh":true},"sessionLimit":{"policy":"reject"}}`)()
+	defer useTestMaxSessions(1)()
+
+	user := createTestUser(t, "limited")
+	secret := genTestRefreshToken(t, user)
+	// 刷新不占用新的会话数
+	for i := 0; i < 3; i++ {
+		next, err := RefreshToken(secret.RefreshToken)
+		if err != nil {
+			t.Fatalf("refresh %d: %v", i, err)
+		}
+		secret = next
+	}
+	if active, _ := ActiveSessions(DB(), user.ID); len(active) != 1 {
+		t.Errorf("expected 1 active session, got %d", len(active))
+	}
+	if err := WithSessionLimit(user.ID, AdminSignType, func() error { return nil }); err == nil {
+		t.Error("expected a new login to be rejected")
+	}
+}
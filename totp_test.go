@@ -0,0 +1,56 @@
+package kuu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 6238 附录B（SHA1）
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		if got := hotp(key, uint64(tt.unix/30), 8); got != tt.want {
+			t.Errorf("hotp(%d) = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "050471" {
+		t.Errorf("TOTPCode() = %v, want %v", code, "050471")
+	}
+	counter, ok := ValidateTOTP(secret, code, now.Add(30*time.Second), 0)
+	if !ok || counter != TOTPCounter(now) {
+		t.Errorf("ValidateTOTP() should accept previous step")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(90*time.Second), 0); ok {
+		t.Errorf("ValidateTOTP() should reject expired code")
+	}
+	if _, ok := ValidateTOTP(secret, code, now, counter); ok {
+		t.Errorf("ValidateTOTP() should reject replayed code")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	want := "otpauth://totp/Kuu:admin?algorithm=SHA1&digits=6&issuer=Kuu&period=30&secret=ABC"
+	if got := TOTPURI("Kuu", "admin", "ABC"); got != want {
+		t.Errorf("TOTPURI() = %v, want %v", got, want)
+	}
+}